@@ -0,0 +1,48 @@
+package config
+
+import "fmt"
+
+// Admin states of a backend
+const (
+	AdminStateActive   = "active"   // receives requests
+	AdminStateDraining = "draining" // no new requests, requests in flight finish
+	AdminStateDisabled = "disabled" // no requests
+)
+
+// Admin returns the admin state of the backend, active if unset
+func (b Backend) Admin() string {
+	if b.AdminState == "" {
+		return AdminStateActive
+	}
+	return b.AdminState
+}
+
+// Routable reports whether new requests may be routed to the backend
+func (b Backend) Routable() bool {
+	return b.Admin() == AdminStateActive
+}
+
+// SetAdminState sets the admin state of a backend, keeping its routes
+func (c *Config) SetAdminState(ifMatch string, name string, state string) (string, error) {
+	return c.UpdateIfMatch(ifMatch, func(settings *Settings) error {
+		for i, b := range settings.Backends {
+			if b.Name == name {
+				if state == AdminStateActive {
+					state = ""
+				}
+				settings.Backends[i].AdminState = state
+				return nil
+			}
+		}
+		return ErrBackendNotFound
+	})
+}
+
+// validateAdminState checks an admin state
+func validateAdminState(state string) error {
+	switch state {
+	case "", AdminStateActive, AdminStateDraining, AdminStateDisabled:
+		return nil
+	}
+	return fmt.Errorf("invalid admin state %q, expected active, draining or disabled", state)
+}
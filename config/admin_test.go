@@ -0,0 +1,65 @@
+package config
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestSetAdminState(t *testing.T) {
+	tests := []struct {
+		name     string
+		backend  string
+		state    string
+		want     string // admin state after the update
+		routable bool
+		err      error
+	}{
+		{"drain", "gpu", AdminStateDraining, AdminStateDraining, false, nil},
+		{"disable", "gpu", AdminStateDisabled, AdminStateDisabled, false, nil},
+		{"activate", "gpu", AdminStateActive, AdminStateActive, true, nil},
+		{"invalid state", "gpu", "paused", AdminStateActive, true, &ValidationError{}},
+		{"unknown backend", "tpu", AdminStateDraining, AdminStateActive, true, ErrBackendNotFound},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c := testConfig(t, Settings{
+				DefaultBackend: "cpu",
+				Backends: []Backend{
+					{Name: "cpu", URL: "http://cpu:3003"},
+					{Name: "gpu", URL: "http://gpu:3003", Labels: map[string]string{"gpu": "true"}},
+				},
+				TaskRouting:      map[string]string{},
+				ModelTypeRouting: map[string]string{},
+			})
+
+			_, err := c.SetAdminState(c.ETag(), test.backend, test.state)
+			switch target := test.err.(type) {
+			case nil:
+				if err != nil {
+					t.Fatal(err)
+				}
+			case *ValidationError:
+				if !errors.As(err, &target) {
+					t.Fatalf("error %v, want a validation error", err)
+				}
+			default:
+				if !errors.Is(err, test.err) {
+					t.Fatalf("error %v, want %v", err, test.err)
+				}
+			}
+
+			gpu := c.GetBackend("gpu")
+			if gpu.Admin() != test.want || gpu.Routable() != test.routable {
+				t.Errorf("admin state %q routable %v, want %q routable %v", gpu.Admin(), gpu.Routable(), test.want, test.routable)
+			}
+			if test.state == AdminStateActive && gpu.AdminState != "" {
+				t.Errorf("active state saved as %q, want it unset", gpu.AdminState)
+			}
+			for _, target := range []string{"gpu", "gpu=true"} {
+				if resolved := len(c.ResolveTarget(target)) == 1; resolved != test.routable {
+					t.Errorf("target %s resolves to the backend: %v, want %v", target, resolved, test.routable)
+				}
+			}
+		})
+	}
+}
@@ -1,15 +1,94 @@
 package config
 
 import (
+	"context"
 	"encoding/json"
-	"os"
+	"log"
+	"net"
 	"sync"
 	"time"
 )
 
 type Backend struct {
-	Name string `json:"name"`
-	URL  string `json:"url"`
+	Name   string            `json:"name" schema:"required"`
+	URL    string            `json:"url" schema:"required"`
+	Labels map[string]string `json:"labels,omitempty"` // e.g. gpu=true, site=home; matched by routing selectors
+
+	AdminState string `json:"adminState,omitempty" enum:"active,draining,disabled"` // draining and disabled backends get no new requests; default active
+
+	// Transport profile, applied to every request sent to this backend
+	Headers map[string]string `json:"headers,omitempty"` // static headers added to every request
+	Auth    *BackendAuth      `json:"auth,omitempty"`
+	TLS     *BackendTLS       `json:"tls,omitempty"`
+	Proxy   string            `json:"proxy,omitempty"`   // upstream proxy: http://, https://, socks5:// or socks5h://
+	Timeout Duration          `json:"timeout,omitempty"` // overrides the default request timeout
+
+	SlowStart Duration `json:"slowStart,omitempty"` // after turning healthy, the backend's share of traffic ramps up over this window
+
+	// Load limits for predict requests
+	MaxConcurrency int      `json:"maxConcurrency,omitempty"` // concurrent requests, further requests queue by priority; 0 = unlimited
+	MaxQueue       int      `json:"maxQueue,omitempty"`       // queued requests before new ones are rejected with 503; 0 = unbounded
+	QueueTimeout   Duration `json:"queueTimeout,omitempty"`   // longest wait for a slot before a request is rejected with 503
+
+	AdaptiveConcurrency *AdaptiveConcurrency `json:"adaptiveConcurrency,omitempty"` // replaces maxConcurrency with a limit derived from latency and errors
+
+	WakeOnLAN *WakeOnLAN `json:"wakeOnLan,omitempty"` // wakes the backend when a request is routed to it while it is down
+	Lifecycle *Lifecycle `json:"lifecycle,omitempty"` // starts the backend on demand and stops it when idle
+}
+
+// AdaptiveConcurrency adjusts a backend's concurrency limit from the latency
+// and errors of its predict requests
+type AdaptiveConcurrency struct {
+	Algorithm        string   `json:"algorithm,omitempty" enum:"aimd,gradient"` // default aimd
+	MinLimit         int      `json:"minLimit,omitempty"`                       // default 1
+	MaxLimit         int      `json:"maxLimit,omitempty"`                       // default 32
+	LatencyThreshold Duration `json:"latencyThreshold,omitempty"`               // aimd: slower requests count as congestion
+}
+
+// WakeOnLAN wakes a sleeping backend with a magic packet. Requests routed to
+// the backend while it is not healthy wait until it answers /ping.
+type WakeOnLAN struct {
+	MAC         string   `json:"mac" schema:"required"`
+	Broadcast   string   `json:"broadcast,omitempty"`   // host:port the packet is sent to; default 255.255.255.255:9
+	WakeTimeout Duration `json:"wakeTimeout,omitempty"` // how long requests wait for the backend; default 60s
+	Fallback    string   `json:"fallback,omitempty"`    // backend name or selector used if the backend does not wake in time
+}
+
+// Lifecycle starts a backend with a hook when a request is routed to it while
+// it is down, and stops it with another hook after it has been idle
+type Lifecycle struct {
+	Start        *Hook    `json:"start" schema:"required"`
+	Stop         *Hook    `json:"stop,omitempty"`
+	IdleTimeout  Duration `json:"idleTimeout,omitempty"`  // stop after no requests for this long; 0 = never stop
+	StartTimeout Duration `json:"startTimeout,omitempty"` // how long requests wait for the backend to answer /ping; default 2m
+	Fallback     string   `json:"fallback,omitempty"`     // backend name or selector used if the backend does not start in time
+}
+
+// Hook is a command or an HTTP call. Commands are run without a shell and get
+// BACKEND_NAME and BACKEND_URL in their environment.
+type Hook struct {
+	Command []string          `json:"command,omitempty"` // program and arguments
+	URL     string            `json:"url,omitempty"`
+	Method  string            `json:"method,omitempty"` // default POST
+	Headers map[string]string `json:"headers,omitempty"`
+	Timeout Duration          `json:"timeout,omitempty"` // default 30s
+}
+
+// BackendAuth holds credentials sent as the Authorization header
+type BackendAuth struct {
+	Type     string `json:"type" enum:"bearer,basic" schema:"required"`
+	Token    string `json:"token,omitempty"` // bearer token
+	Username string `json:"username,omitempty"`
+	Password string `json:"password,omitempty"`
+}
+
+// BackendTLS configures TLS for https backends
+type BackendTLS struct {
+	CAFile             string `json:"caFile,omitempty"`   // PEM bundle used instead of the system roots
+	CertFile           string `json:"certFile,omitempty"` // client certificate (PEM)
+	KeyFile            string `json:"keyFile,omitempty"`  // client key (PEM)
+	ServerName         string `json:"serverName,omitempty"`
+	InsecureSkipVerify bool   `json:"insecureSkipVerify,omitempty"`
 }
 
 type HealthStatus string
@@ -26,65 +105,240 @@ type BackendHealth struct {
 	Error     string       `json:"error,omitempty"`
 }
 
+// Settings is the persisted part of the configuration, i.e. everything that is
+// written to the config file and returned by /api/config
+type Settings struct {
+	Version          int                `json:"version"`
+	DefaultBackend   string             `json:"defaultBackend"`
+	Backends         []Backend          `json:"backends"`
+	TaskRouting      map[string]string  `json:"taskRouting"`      // task (or task.modelType) -> backend name or label selector
+	ModelTypeRouting map[string]string  `json:"modelTypeRouting"` // modelType -> backend name or label selector (for clip: textual, visual)
+	Rules            []RoutingRule      `json:"rules,omitempty"`  // ordered routing rules, evaluated before the routing maps
+	PriorityClasses  []PriorityClass    `json:"priorityClasses,omitempty"`
+	Profiles         map[string]Profile `json:"profiles,omitempty"`  // name -> routing overrides activated by schedules
+	Schedules        []Schedule         `json:"schedules,omitempty"` // time windows activating profiles or enabling/disabling backends
+	OutlierDetection *OutlierDetection  `json:"outlierDetection,omitempty"`
+	DeepChecks       []DeepCheck        `json:"deepChecks,omitempty"` // synthetic predict requests checking each task on its backends
+	Readiness        *Readiness         `json:"readiness,omitempty"`  // tasks required for /readyz and optionally /ping
+	KeepWarm         *KeepWarm          `json:"keepWarm,omitempty"`   // periodic requests keeping models loaded
+	Discovery        *Discovery         `json:"discovery,omitempty"`  // probing backends for the models they can serve
+	Notifications    *Notifications     `json:"notifications,omitempty"`
+}
+
 type Config struct {
-		DefaultBackend   string            `json:"defaultBackend"`
-		Backends         []Backend         `json:"backends"`
-		TaskRouting      map[string]string `json:"taskRouting"` // task -> backend name mapping
-		ModelTypeRouting map[string]string `json:"modelTypeRouting"` // modelType -> backend name mapping (for clip: textual, visual)
-		Health           map[string]BackendHealth `json:"-"` // backend name -> health status
-		mu               sync.RWMutex
-	}
+	Settings
+	Health     map[string]BackendHealth         `json:"-"` // backend name -> health status
+	healthy    map[string]time.Time             // backend name -> when it last turned healthy
+	taskHealth map[string]map[string]TaskHealth // backend name -> task key -> deep check outcome
+	history    map[string]*HealthHistory        // backend name -> health transitions and checks
+	schedule   ScheduleState                    // schedules in effect, refreshed on changes and every minute
+	store      ConfigStore
+	mu         sync.RWMutex
+}
+
 var (
 	instance *Config
 	once     sync.Once
-	configFile = "config.json"
+	store    ConfigStore
 )
 
+// UseStore sets the store the configuration is loaded from and saved to.
+// Must be called before Load; defaults to a FileStore.
+func UseStore(s ConfigStore) {
+	store = s
+}
+
 func Load() *Config {
 	once.Do(func() {
+		if store == nil {
+			store = NewFileStore("")
+		}
 		instance = &Config{
-			DefaultBackend:   "",
-			Backends:         []Backend{},
-			TaskRouting:      make(map[string]string),
-			ModelTypeRouting: make(map[string]string),
-			Health:           make(map[string]BackendHealth),
+			Settings: Settings{
+				Version:          CurrentVersion,
+				DefaultBackend:   "",
+				Backends:         []Backend{},
+				TaskRouting:      make(map[string]string),
+				ModelTypeRouting: make(map[string]string),
+			},
+			Health:     make(map[string]BackendHealth),
+			healthy:    make(map[string]time.Time),
+			taskHealth: make(map[string]map[string]TaskHealth),
+			history:    make(map[string]*HealthHistory),
+			store:      store,
 		}
-		instance.loadFromFile()
+		instance.loadFromStore()
+		instance.mu.Lock()
+		instance.refreshSchedules()
+		instance.mu.Unlock()
 	})
 	return instance
 }
 
-func (c *Config) loadFromFile() {
+// Store returns the store backing the configuration
+func (c *Config) Store() ConfigStore {
+	return c.store
+}
+
+func (c *Config) loadFromStore() {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
-	data, err := os.ReadFile(configFile)
+	doc, err := c.store.Load()
 	if err != nil {
-		// File doesn't exist yet, use default configuration
+		if err != ErrNotFound {
+			log.Printf("Failed to load config from %s: %v", c.store, err)
+		}
+		// Nothing stored yet, use default configuration
 		return
 	}
 
-	var cfg Config
-	if err := json.Unmarshal(data, &cfg); err != nil {
+	fromVersion, err := Migrate(doc)
+	if err != nil {
+		log.Printf("Failed to migrate config from %s: %v", c.store, err)
 		return
 	}
 
-	c.DefaultBackend = cfg.DefaultBackend
-	c.Backends = cfg.Backends
-	c.TaskRouting = cfg.TaskRouting
-	c.ModelTypeRouting = cfg.ModelTypeRouting
+	settings, err := settingsFromDocument(doc)
+	if err != nil {
+		log.Printf("Failed to load config from %s: %v", c.store, err)
+		return
+	}
+	c.Settings = settings
+
+	// Write the upgraded config back, keeping a backup of file configs
+	if fromVersion != CurrentVersion {
+		if fileStore, ok := c.store.(*FileStore); ok {
+			backup, err := fileStore.Backup(fromVersion)
+			if err != nil {
+				log.Printf("Failed to back up config file %s: %v", fileStore.Path(), err)
+				return
+			}
+			log.Printf("Backed up config file %s to %s", fileStore.Path(), backup)
+		}
+		if err := c.store.Save(c.Settings); err != nil {
+			log.Printf("Failed to write migrated config to %s: %v", c.store, err)
+			return
+		}
+		log.Printf("Migrated config in %s from version %d to %d", c.store, fromVersion, CurrentVersion)
+	}
 }
 
 func (c *Config) Save() error {
 	c.mu.RLock()
 	defer c.mu.RUnlock()
 
-	data, err := json.MarshalIndent(c, "", "  ")
-	if err != nil {
+	return c.store.Save(c.Settings)
+}
+
+// Update applies fn to the settings and saves the result. Transactional
+// stores apply fn to their latest stored settings, so changes made by other
+// instances sharing the store are not lost.
+func (c *Config) Update(fn func(settings *Settings) error) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if txStore, ok := c.store.(TransactionalStore); ok {
+		settings, err := txStore.Update(fn)
+		if err != nil {
+			return err
+		}
+		c.Settings = settings
+		c.refreshSchedules()
+		return nil
+	}
+
+	settings := c.Settings.clone()
+	if err := fn(&settings); err != nil {
+		return err
+	}
+	settings.Version = CurrentVersion
+	if err := c.store.Save(settings); err != nil {
 		return err
 	}
+	c.Settings = settings
+	c.refreshSchedules()
+	return nil
+}
+
+// Watch applies changes reported by a WatchableStore until ctx is done.
+// It returns immediately for stores that cannot be watched.
+func (c *Config) Watch(ctx context.Context) {
+	watchable, ok := c.store.(WatchableStore)
+	if !ok {
+		return
+	}
 
-	return os.WriteFile(configFile, data, 0644)
+	watchable.Watch(ctx, func(doc map[string]interface{}) {
+		if _, err := Migrate(doc); err != nil {
+			log.Printf("Ignoring config change from %s: %v", c.store, err)
+			return
+		}
+		settings, err := settingsFromDocument(doc)
+		if err != nil {
+			log.Printf("Ignoring config change from %s: %v", c.store, err)
+			return
+		}
+
+		c.mu.Lock()
+		c.Settings = settings
+		c.refreshSchedules()
+		c.mu.Unlock()
+		log.Printf("Reloaded config from %s", c.store)
+	})
+}
+
+// History returns up to limit previous revisions if the store keeps them
+func (c *Config) History(limit int) ([]Revision, bool, error) {
+	historyStore, ok := c.store.(HistoryStore)
+	if !ok {
+		return nil, false, nil
+	}
+	revisions, err := historyStore.History(limit)
+	return revisions, true, err
+}
+
+// clone returns a deep copy of the settings
+func (s Settings) clone() Settings {
+	result := s
+	result.Backends = append([]Backend{}, s.Backends...)
+	result.Rules = append([]RoutingRule(nil), s.Rules...)
+	result.PriorityClasses = append([]PriorityClass(nil), s.PriorityClasses...)
+	result.Schedules = append([]Schedule(nil), s.Schedules...)
+	result.DeepChecks = append([]DeepCheck(nil), s.DeepChecks...)
+	if s.Readiness != nil {
+		readiness := *s.Readiness
+		readiness.RequiredTasks = append([]string(nil), s.Readiness.RequiredTasks...)
+		result.Readiness = &readiness
+	}
+	if s.KeepWarm != nil {
+		result.KeepWarm = s.KeepWarm.clone()
+	}
+	if s.Discovery != nil {
+		result.Discovery = s.Discovery.clone()
+	}
+	if s.Notifications != nil {
+		result.Notifications = s.Notifications.clone()
+	}
+	if s.OutlierDetection != nil {
+		outlierDetection := *s.OutlierDetection
+		result.OutlierDetection = &outlierDetection
+	}
+	if s.Profiles != nil {
+		result.Profiles = make(map[string]Profile, len(s.Profiles))
+		for k, v := range s.Profiles {
+			result.Profiles[k] = v
+		}
+	}
+	result.TaskRouting = make(map[string]string, len(s.TaskRouting))
+	for k, v := range s.TaskRouting {
+		result.TaskRouting[k] = v
+	}
+	result.ModelTypeRouting = make(map[string]string, len(s.ModelTypeRouting))
+	for k, v := range s.ModelTypeRouting {
+		result.ModelTypeRouting[k] = v
+	}
+	return result
 }
 
 func (c *Config) GetBackendURL(task string) string {
@@ -122,69 +376,110 @@ func (c *Config) GetAllBackendURLs() []string {
 	return urls
 }
 
-func (c *Config) AddBackend(name, url string) {
-	c.mu.Lock()
-	defer c.mu.Unlock()
-
-	for i, b := range c.Backends {
-		if b.Name == name {
-			c.Backends[i].URL = url
-			return
+// AddBackend adds a backend or replaces the backend with the same name
+func (c *Config) AddBackend(ifMatch string, backend Backend) (string, error) {
+	return c.UpdateIfMatch(ifMatch, func(settings *Settings) error {
+		for i, b := range settings.Backends {
+			if b.Name == backend.Name {
+				settings.Backends[i] = backend
+				return nil
+			}
 		}
-	}
-	c.Backends = append(c.Backends, Backend{Name: name, URL: url})
+		settings.Backends = append(settings.Backends, backend)
+		return nil
+	})
 }
 
-func (c *Config) RemoveBackend(name string) {
-	c.mu.Lock()
-	defer c.mu.Unlock()
-
-	for i, b := range c.Backends {
-		if b.Name == name {
-			c.Backends = append(c.Backends[:i], c.Backends[i+1:]...)
-			// Remove task routing for this backend
-			for task, backendName := range c.TaskRouting {
-				if backendName == name {
-					delete(c.TaskRouting, task)
+// RemoveBackend removes a backend together with all routing that points to it
+func (c *Config) RemoveBackend(ifMatch string, name string) (string, error) {
+	return c.UpdateIfMatch(ifMatch, func(settings *Settings) error {
+		for i, b := range settings.Backends {
+			if b.Name == name {
+				settings.Backends = append(settings.Backends[:i], settings.Backends[i+1:]...)
+				// Remove task routing for this backend
+				for task, backendName := range settings.TaskRouting {
+					if backendName == name {
+						delete(settings.TaskRouting, task)
+					}
 				}
+				// Remove modelType routing for this backend
+				for modelType, backendName := range settings.ModelTypeRouting {
+					if backendName == name {
+						delete(settings.ModelTypeRouting, modelType)
+					}
+				}
+				// Reset default backend if needed
+				if settings.DefaultBackend == name {
+					settings.DefaultBackend = ""
+				}
+				return nil
 			}
-			// Reset default backend if needed
-			if c.DefaultBackend == name {
-				c.DefaultBackend = ""
-			}
-			return
 		}
-	}
+		return ErrBackendNotFound
+	})
 }
 
-func (c *Config) SetTaskRouting(task, backendName string) {
-	c.mu.Lock()
-	defer c.mu.Unlock()
-	c.TaskRouting[task] = backendName
+// SetTaskRouting routes a task to a backend
+func (c *Config) SetTaskRouting(ifMatch string, task, backendName string) (string, error) {
+	return c.UpdateIfMatch(ifMatch, func(settings *Settings) error {
+		settings.TaskRouting[task] = backendName
+		return nil
+	})
 }
 
-func (c *Config) SetDefaultBackend(name string) {
-	c.mu.Lock()
-	defer c.mu.Unlock()
-	c.DefaultBackend = name
+// RemoveTaskRouting removes the routing of a task so it goes to the default backend
+func (c *Config) RemoveTaskRouting(ifMatch string, task string) (string, error) {
+	return c.UpdateIfMatch(ifMatch, func(settings *Settings) error {
+		if _, ok := settings.TaskRouting[task]; !ok {
+			return ErrRouteNotFound
+		}
+		delete(settings.TaskRouting, task)
+		return nil
+	})
+}
+
+// SetModelTypeRouting routes a modelType (e.g. textual, visual) to a backend
+func (c *Config) SetModelTypeRouting(ifMatch string, modelType, backendName string) (string, error) {
+	return c.UpdateIfMatch(ifMatch, func(settings *Settings) error {
+		settings.ModelTypeRouting[modelType] = backendName
+		return nil
+	})
+}
+
+// RemoveModelTypeRouting removes the routing of a modelType
+func (c *Config) RemoveModelTypeRouting(ifMatch string, modelType string) (string, error) {
+	return c.UpdateIfMatch(ifMatch, func(settings *Settings) error {
+		if _, ok := settings.ModelTypeRouting[modelType]; !ok {
+			return ErrRouteNotFound
+		}
+		delete(settings.ModelTypeRouting, modelType)
+		return nil
+	})
+}
+
+// SetDefaultBackend sets the backend that handles all types without routing
+func (c *Config) SetDefaultBackend(ifMatch string, name string) (string, error) {
+	return c.UpdateIfMatch(ifMatch, func(settings *Settings) error {
+		settings.DefaultBackend = name
+		return nil
+	})
 }
 
 func (c *Config) ToJSON() ([]byte, error) {
+	data, _, err := c.ToJSONWithETag()
+	return data, err
+}
+
+// ToJSONWithETag returns the settings as JSON together with their ETag. The
+// JSON also reports the active profile and schedules, which are not part of the
+// settings and ignored when posted back.
+func (c *Config) ToJSONWithETag() ([]byte, string, error) {
 	c.mu.RLock()
 	defer c.mu.RUnlock()
 
 	// Create a copy to avoid modifying the original
-	result := struct {
-		DefaultBackend   string            `json:"defaultBackend"`
-		Backends         []Backend         `json:"backends"`
-		TaskRouting      map[string]string `json:"taskRouting"`
-		ModelTypeRouting map[string]string `json:"modelTypeRouting"`
-	}{
-		DefaultBackend:   c.DefaultBackend,
-		Backends:         c.Backends,
-		TaskRouting:      c.TaskRouting,
-		ModelTypeRouting: c.ModelTypeRouting,
-	}
+	result := c.Settings
+	result.Version = CurrentVersion
 
 	// Ensure maps are not nil
 	if result.TaskRouting == nil {
@@ -194,16 +489,72 @@ func (c *Config) ToJSON() ([]byte, error) {
 		result.ModelTypeRouting = make(map[string]string)
 	}
 
-	return json.MarshalIndent(result, "", "  ")
+	data, err := json.MarshalIndent(struct {
+		Settings
+		ActiveProfile   string   `json:"activeProfile,omitempty"`
+		ActiveSchedules []string `json:"activeSchedules,omitempty"`
+	}{result, c.schedule.Profile, c.schedule.Active}, "", "  ")
+	return data, c.Settings.etag(), err
+}
+
+// GetBackends returns a copy of all backends
+func (c *Config) GetBackends() []Backend {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return append([]Backend{}, c.Backends...)
+}
+
+// GetBackend returns the backend with the given name, or nil
+func (c *Config) GetBackend(name string) *Backend {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	for _, backend := range c.Backends {
+		if backend.Name == name {
+			return &backend
+		}
+	}
+	return nil
+}
+
+// GetTaskRouting returns a copy of the task routing
+func (c *Config) GetTaskRouting() map[string]string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	result := make(map[string]string, len(c.TaskRouting))
+	for k, v := range c.TaskRouting {
+		result[k] = v
+	}
+	return result
 }
 
-// SetHealthStatus sets the health status for a backend
+// GetModelTypeRouting returns a copy of the modelType routing
+func (c *Config) GetModelTypeRouting() map[string]string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	result := make(map[string]string, len(c.ModelTypeRouting))
+	for k, v := range c.ModelTypeRouting {
+		result[k] = v
+	}
+	return result
+}
+
+// SetHealthStatus sets the health status for a backend and records changes
+// in its health history
 func (c *Config) SetHealthStatus(backendName string, status HealthStatus, error string) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
+	now := time.Now()
+	previous := HealthStatusUnknown
+	if health, ok := c.Health[backendName]; ok {
+		previous = health.Status
+	}
+	if status == HealthStatusHealthy && previous != HealthStatusHealthy {
+		c.healthy[backendName] = now
+	}
+	c.recordTransition(backendName, previous, status, error, now)
 	c.Health[backendName] = BackendHealth{
 		Status:    status,
-		LastCheck: time.Now().Unix(),
+		LastCheck: now.Unix(),
 		Error:     error,
 	}
 }
@@ -232,21 +583,17 @@ func (c *Config) GetAllHealthStatus() map[string]BackendHealth {
 	return result
 }
 
-// GetBackendsByType returns backends that handle the specified type
+// GetBackendsByType returns backends that handle the specified type under the
+// active profile. The routing target may be a backend name or a label selector.
 func (c *Config) GetBackendsByType(typeName string) []Backend {
 	c.mu.RLock()
 	defer c.mu.RUnlock()
 
 	// Check if this type has a specific routing in taskRouting
-	backendName, hasRouting := c.TaskRouting[typeName]
+	target, hasRouting := c.activeRouting().TaskRouting[typeName]
 
 	if hasRouting {
-		// Return the specific backend for this type
-		for _, backend := range c.Backends {
-			if backend.Name == backendName {
-				return []Backend{backend}
-			}
-		}
+		return c.resolveTarget(target)
 	}
 
 	// No specific routing, return empty (type not supported)
@@ -255,37 +602,31 @@ func (c *Config) GetBackendsByType(typeName string) []Backend {
 
 // GetHealthyBackendsByType returns healthy backends that handle the specified type
 func (c *Config) GetHealthyBackendsByType(typeName string) []Backend {
+	return c.FilterHealthy(c.GetBackendsByType(typeName))
+}
+
+// FilterHealthy returns the backends whose health status is healthy
+func (c *Config) FilterHealthy(backends []Backend) []Backend {
 	c.mu.RLock()
 	defer c.mu.RUnlock()
 
-	// Check if this type has a specific routing in taskRouting
-	backendName, hasRouting := c.TaskRouting[typeName]
-
-	if hasRouting {
-		// Check if the specific backend is healthy
-		for _, backend := range c.Backends {
-			if backend.Name == backendName {
-				if health, ok := c.Health[backend.Name]; ok && health.Status == HealthStatusHealthy {
-					return []Backend{backend}
-				}
-				// Backend exists but is not healthy
-				return []Backend{}
-			}
+	result := []Backend{}
+	for _, backend := range backends {
+		if health, ok := c.Health[backend.Name]; ok && health.Status == HealthStatusHealthy {
+			result = append(result, backend)
 		}
 	}
-
-	// No specific routing or backend not found
-	return []Backend{}
+	return result
 }
 
-// GetAllTypes returns all unique types from taskRouting
+// GetAllTypes returns all unique types from taskRouting, including the active profile's
 // Note: This doesn't include types handled by defaultBackend, as those are unknown
 func (c *Config) GetAllTypes() []string {
 	c.mu.RLock()
 	defer c.mu.RUnlock()
 
 	typeMap := make(map[string]bool)
-	for task := range c.TaskRouting {
+	for task := range c.activeRouting().TaskRouting {
 		typeMap[task] = true
 	}
 
@@ -313,19 +654,52 @@ func (c *Config) GetDefaultBackend() *Backend {
 	return nil
 }
 
-// GetBackendByModelType returns the backend for a specific modelType (e.g., "textual", "visual")
-// Returns nil if no specific routing is configured for this modelType
-func (c *Config) GetBackendByModelType(modelType string) *Backend {
+// StartFallback returns the routing target used when the backend could not be
+// woken up or started, or "" if there is none
+func (b Backend) StartFallback() string {
+	if b.Lifecycle != nil && b.Lifecycle.Fallback != "" {
+		return b.Lifecycle.Fallback
+	}
+	if b.WakeOnLAN != nil {
+		return b.WakeOnLAN.Fallback
+	}
+	return ""
+}
+
+// BroadcastAddress returns the host:port the magic packet is sent to
+func (w *WakeOnLAN) BroadcastAddress() string {
+	if w.Broadcast == "" {
+		return "255.255.255.255:9"
+	}
+	if _, _, err := net.SplitHostPort(w.Broadcast); err != nil {
+		return net.JoinHostPort(w.Broadcast, "9")
+	}
+	return w.Broadcast
+}
+
+// HealthySince returns when the backend's health last changed to healthy,
+// false if it never did
+func (c *Config) HealthySince(backendName string) (time.Time, bool) {
 	c.mu.RLock()
 	defer c.mu.RUnlock()
+	since, ok := c.healthy[backendName]
+	return since, ok
+}
 
-	if backendName, ok := c.ModelTypeRouting[modelType]; ok {
-		for _, backend := range c.Backends {
-			if backend.Name == backendName {
-				return &backend
-			}
-		}
-	}
+// minSlowStartWeight is the share of traffic a backend gets when its slow start begins
+const minSlowStartWeight = 0.1
 
-	return nil
-}
\ No newline at end of file
+// SlowStartWeight returns the selection weight of a backend between 0.1 and 1.
+// It ramps up linearly over the backend's slow-start window after the backend
+// turned healthy, and is 1 for backends without slow start.
+func (c *Config) SlowStartWeight(backend Backend) float64 {
+	if backend.SlowStart <= 0 {
+		return 1
+	}
+	since, ok := c.HealthySince(backend.Name)
+	elapsed := time.Since(since)
+	if !ok || elapsed >= backend.SlowStart.Std() {
+		return 1
+	}
+	return minSlowStartWeight + (1-minSlowStartWeight)*elapsed.Seconds()/backend.SlowStart.Std().Seconds()
+}
@@ -0,0 +1,60 @@
+package config
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestSlowStartWeight(t *testing.T) {
+	window := Duration(100 * time.Second)
+	tests := []struct {
+		name      string
+		slowStart Duration
+		healthy   time.Duration // how long ago the backend turned healthy, negative if never
+		want      float64
+	}{
+		{"no slow start", 0, 0, 1},
+		{"never healthy", window, -1, 1},
+		{"just turned healthy", window, 0, 0.1},
+		{"half way", window, 50 * time.Second, 0.55},
+		{"window over", window, 100 * time.Second, 1},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c := testConfig(t, Settings{})
+			if test.healthy >= 0 {
+				c.healthy["gpu"] = time.Now().Add(-test.healthy)
+			}
+			got := c.SlowStartWeight(Backend{Name: "gpu", SlowStart: test.slowStart})
+			if math.Abs(got-test.want) > 0.01 {
+				t.Errorf("weight %.3f, want %.3f", got, test.want)
+			}
+		})
+	}
+}
+
+func TestSlowStartRestartsWhenHealthyAgain(t *testing.T) {
+	c := testConfig(t, Settings{})
+	backend := Backend{Name: "gpu", SlowStart: Duration(time.Hour)}
+
+	c.SetHealthStatus("gpu", HealthStatusHealthy, "")
+	first, _ := c.HealthySince("gpu")
+	c.SetHealthStatus("gpu", HealthStatusHealthy, "")
+	if again, _ := c.HealthySince("gpu"); !again.Equal(first) {
+		t.Error("staying healthy restarted the slow start")
+	}
+	if weight := c.SlowStartWeight(backend); weight > 0.11 {
+		t.Errorf("weight %.3f right after turning healthy, want about 0.1", weight)
+	}
+
+	c.healthy["gpu"] = time.Now().Add(-2 * time.Hour)
+	if weight := c.SlowStartWeight(backend); weight != 1 {
+		t.Errorf("weight %.3f after the window, want 1", weight)
+	}
+	c.SetHealthStatus("gpu", HealthStatusUnhealthy, "down")
+	c.SetHealthStatus("gpu", HealthStatusHealthy, "")
+	if weight := c.SlowStartWeight(backend); weight > 0.11 {
+		t.Errorf("weight %.3f after recovering, want the slow start to begin again", weight)
+	}
+}
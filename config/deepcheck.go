@@ -0,0 +1,144 @@
+package config
+
+import (
+	"fmt"
+	"time"
+)
+
+// DeepCheck sends a small test image or text through /predict on every backend
+// a task routes to and validates the response, so a backend that answers
+// /ping but cannot run the model is noticed
+type DeepCheck struct {
+	Task      string                 `json:"task" schema:"required"`            // e.g. clip, facial-recognition, ocr
+	ModelType string                 `json:"modelType" schema:"required"`       // e.g. visual, textual, detection
+	ModelName string                 `json:"modelName" schema:"required"`       // model to run, e.g. ViT-B-32__openai
+	Options   map[string]interface{} `json:"options,omitempty"`                 // model options sent with the entry
+	Input     string                 `json:"input,omitempty" enum:"image,text"` // defaults to text for textual models, image otherwise
+	Text      string                 `json:"text,omitempty"`                    // test text; default "a photo of a cat"
+	Interval  Duration               `json:"interval,omitempty"`                // default 5m
+	Timeout   Duration               `json:"timeout,omitempty"`                 // default 2m, the first request may have to load the model
+}
+
+const (
+	DeepCheckInputImage = "image"
+	DeepCheckInputText  = "text"
+)
+
+// Key identifies the task and model type a deep check covers, e.g. "clip/visual"
+func (d DeepCheck) Key() string {
+	return TaskKey(d.Task, d.ModelType)
+}
+
+// InputKind returns whether the check sends an image or text
+func (d DeepCheck) InputKind() string {
+	if d.Input != "" {
+		return d.Input
+	}
+	if d.ModelType == "textual" {
+		return DeepCheckInputText
+	}
+	return DeepCheckInputImage
+}
+
+// TaskKey identifies a task and model type in task health, e.g. "clip/visual"
+func TaskKey(task, modelType string) string {
+	return task + "/" + modelType
+}
+
+// TaskHealth is the outcome of the last deep check of a task on a backend
+type TaskHealth struct {
+	Status    HealthStatus `json:"status"`
+	LastCheck time.Time    `json:"lastCheck"`
+	Since     time.Time    `json:"since"` // when the status last changed
+	LatencyMs int64        `json:"latencyMs"`
+	Error     string       `json:"error,omitempty"`
+}
+
+// GetDeepChecks returns a copy of the configured deep checks
+func (c *Config) GetDeepChecks() []DeepCheck {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return append([]DeepCheck(nil), c.DeepChecks...)
+}
+
+// SetTaskHealth records the outcome of a deep check of a task on a backend,
+// also in the backend's health history
+func (c *Config) SetTaskHealth(backendName, key string, health TaskHealth) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	tasks, ok := c.taskHealth[backendName]
+	if !ok {
+		tasks = make(map[string]TaskHealth)
+		c.taskHealth[backendName] = tasks
+	}
+	health.Since = health.LastCheck
+	if previous, ok := tasks[key]; ok && previous.Status == health.Status {
+		health.Since = previous.Since
+	}
+	tasks[key] = health
+	c.recordDeepCheck(backendName, HealthCheck{
+		Time:      health.LastCheck,
+		Task:      key,
+		Status:    health.Status,
+		LatencyMs: health.LatencyMs,
+		Error:     health.Error,
+	})
+}
+
+// GetTaskHealth returns the deep check outcome of a task on a backend, false
+// if the task has not been checked there
+func (c *Config) GetTaskHealth(backendName, key string) (TaskHealth, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	health, ok := c.taskHealth[backendName][key]
+	return health, ok
+}
+
+// GetAllTaskHealth returns the deep check outcomes of a backend by task key
+func (c *Config) GetAllTaskHealth(backendName string) map[string]TaskHealth {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	result := make(map[string]TaskHealth, len(c.taskHealth[backendName]))
+	for k, v := range c.taskHealth[backendName] {
+		result[k] = v
+	}
+	return result
+}
+
+// PruneTaskHealth drops task health of checks that are no longer configured
+func (c *Config) PruneTaskHealth() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	keys := make(map[string]bool)
+	for _, check := range c.DeepChecks {
+		keys[check.Key()] = true
+	}
+	for _, tasks := range c.taskHealth {
+		for key := range tasks {
+			if !keys[key] {
+				delete(tasks, key)
+			}
+		}
+	}
+}
+
+// validateDeepChecks checks the deep check settings
+func validateDeepChecks(checks []DeepCheck) error {
+	seen := make(map[string]bool)
+	for i, check := range checks {
+		if check.Task == "" || check.ModelType == "" || check.ModelName == "" {
+			return fmt.Errorf("deep check %d: task, modelType and modelName are required", i+1)
+		}
+		if seen[check.Key()] {
+			return fmt.Errorf("deep check %d: %s is checked twice", i+1, check.Key())
+		}
+		seen[check.Key()] = true
+		if check.Input != "" && check.Input != DeepCheckInputImage && check.Input != DeepCheckInputText {
+			return fmt.Errorf("deep check %s: input must be image or text", check.Key())
+		}
+		if check.Interval < 0 || check.Timeout < 0 {
+			return fmt.Errorf("deep check %s: interval and timeout must not be negative", check.Key())
+		}
+	}
+	return nil
+}
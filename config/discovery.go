@@ -0,0 +1,98 @@
+package config
+
+import "fmt"
+
+// TaskModel is a model of a task, e.g. the textual CLIP model ViT-B-32__openai
+type TaskModel struct {
+	Task      string                 `json:"task" schema:"required"`
+	ModelType string                 `json:"modelType" schema:"required"`
+	ModelName string                 `json:"modelName" schema:"required"`
+	Options   map[string]interface{} `json:"options,omitempty"` // model options sent with the entry
+}
+
+// Key identifies the model, e.g. "clip/textual/ViT-B-32__openai"
+func (m TaskModel) Key() string {
+	return TaskKey(m.Task, m.ModelType) + "/" + m.ModelName
+}
+
+// Discovery probes backends with tiny requests for the known models to find
+// out which ones they can serve
+type Discovery struct {
+	Interval Duration    `json:"interval,omitempty"` // default 30m
+	Timeout  Duration    `json:"timeout,omitempty"`  // default 2m, the first request may have to load the model
+	Models   []TaskModel `json:"models,omitempty"`   // default Immich's default models; deep check and keep-warm models are always probed
+
+	// Probe every model on every backend, not only on the backends its route
+	// sends it to, e.g. to find out where a model could be moved
+	AllBackends bool `json:"allBackends,omitempty"`
+}
+
+// defaultDiscoveryModels are the models Immich uses out of the box
+var defaultDiscoveryModels = []TaskModel{
+	{Task: "clip", ModelType: "visual", ModelName: "ViT-B-32__openai"},
+	{Task: "clip", ModelType: "textual", ModelName: "ViT-B-32__openai"},
+	{Task: "facial-recognition", ModelType: "detection", ModelName: "buffalo_l"},
+	{Task: "facial-recognition", ModelType: "recognition", ModelName: "buffalo_l"},
+	{Task: "ocr", ModelType: "detection", ModelName: "PP-OCRv5_mobile"},
+	{Task: "ocr", ModelType: "recognition", ModelName: "PP-OCRv5_mobile"},
+}
+
+// GetDiscovery returns a copy of the discovery settings, nil if disabled
+func (c *Config) GetDiscovery() *Discovery {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if c.Discovery == nil {
+		return nil
+	}
+	return c.Discovery.clone()
+}
+
+// DiscoveryModels returns the models discovery probes backends for: the
+// configured ones or Immich's defaults, and those of deep checks and keep-warm
+func (c *Config) DiscoveryModels() []TaskModel {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	models := defaultDiscoveryModels
+	if c.Discovery != nil && len(c.Discovery.Models) > 0 {
+		models = c.Discovery.Models
+	}
+	for _, check := range c.DeepChecks {
+		models = append(models, TaskModel{Task: check.Task, ModelType: check.ModelType, ModelName: check.ModelName, Options: check.Options})
+	}
+	if c.KeepWarm != nil {
+		models = append(models, c.KeepWarm.Models...)
+	}
+
+	seen := make(map[string]bool)
+	var result []TaskModel
+	for _, model := range models {
+		if !seen[model.Key()] {
+			seen[model.Key()] = true
+			result = append(result, model)
+		}
+	}
+	return result
+}
+
+func (d *Discovery) clone() *Discovery {
+	result := *d
+	result.Models = append([]TaskModel(nil), d.Models...)
+	return &result
+}
+
+// validate checks the discovery settings, if any
+func (d *Discovery) validate() error {
+	if d == nil {
+		return nil
+	}
+	if d.Interval < 0 || d.Timeout < 0 {
+		return fmt.Errorf("interval and timeout must not be negative")
+	}
+	for i, model := range d.Models {
+		if model.Task == "" || model.ModelType == "" || model.ModelName == "" {
+			return fmt.Errorf("model %d: task, modelType and modelName are required", i+1)
+		}
+	}
+	return nil
+}
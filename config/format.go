@@ -0,0 +1,143 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/pelletier/go-toml/v2"
+	"gopkg.in/yaml.v3"
+)
+
+// Format is a config file encoding
+type Format string
+
+const (
+	FormatJSON Format = "json"
+	FormatYAML Format = "yaml"
+	FormatTOML Format = "toml"
+)
+
+// FormatFromPath derives the config format from a file extension, defaulting to JSON
+func FormatFromPath(path string) Format {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		return FormatYAML
+	case ".toml":
+		return FormatTOML
+	default:
+		return FormatJSON
+	}
+}
+
+// DecodeDocument decodes a config file into a generic document so it can be
+// migrated before being mapped onto Settings
+func DecodeDocument(data []byte, format Format) (map[string]interface{}, error) {
+	doc := make(map[string]interface{})
+
+	switch format {
+	case FormatYAML:
+		if err := yaml.Unmarshal(data, &doc); err != nil {
+			return nil, err
+		}
+	case FormatTOML:
+		if err := toml.Unmarshal(data, &doc); err != nil {
+			return nil, err
+		}
+	case FormatJSON:
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.UseNumber()
+		if err := decoder.Decode(&doc); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unsupported config format: %s", format)
+	}
+
+	if doc == nil {
+		// An empty YAML file decodes to nil
+		doc = make(map[string]interface{})
+	}
+	return doc, nil
+}
+
+// EncodeSettings encodes settings in the given format
+func EncodeSettings(settings Settings, format Format) ([]byte, error) {
+	data, err := json.MarshalIndent(settings, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	if format == FormatJSON {
+		return data, nil
+	}
+
+	// Go through a generic document so YAML and TOML use the JSON field names
+	doc, err := DecodeDocument(data, FormatJSON)
+	if err != nil {
+		return nil, err
+	}
+	normalized := normalizeNumbers(doc)
+
+	switch format {
+	case FormatYAML:
+		return yaml.Marshal(normalized)
+	case FormatTOML:
+		return toml.Marshal(normalized)
+	default:
+		return nil, fmt.Errorf("unsupported config format: %s", format)
+	}
+}
+
+// settingsFromDocument maps a (migrated) generic document onto Settings
+func settingsFromDocument(doc map[string]interface{}) (Settings, error) {
+	var settings Settings
+
+	data, err := json.Marshal(doc)
+	if err != nil {
+		return settings, err
+	}
+	if err := json.Unmarshal(data, &settings); err != nil {
+		return settings, err
+	}
+
+	// Ensure maps are not nil
+	if settings.Backends == nil {
+		settings.Backends = []Backend{}
+	}
+	if settings.TaskRouting == nil {
+		settings.TaskRouting = make(map[string]string)
+	}
+	if settings.ModelTypeRouting == nil {
+		settings.ModelTypeRouting = make(map[string]string)
+	}
+	return settings, nil
+}
+
+// normalizeNumbers replaces json.Number values with int64 or float64 so that
+// YAML and TOML encoders write them as numbers
+func normalizeNumbers(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, item := range v {
+			v[key] = normalizeNumbers(item)
+		}
+		return v
+	case []interface{}:
+		for i, item := range v {
+			v[i] = normalizeNumbers(item)
+		}
+		return v
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return i
+		}
+		if f, err := v.Float64(); err == nil {
+			return f
+		}
+		return v.String()
+	default:
+		return v
+	}
+}
@@ -0,0 +1,77 @@
+package config
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestFormatFromPath(t *testing.T) {
+	tests := map[string]Format{
+		"config.json":     FormatJSON,
+		"config.yaml":     FormatYAML,
+		"/etc/config.YML": FormatYAML,
+		"config.toml":     FormatTOML,
+		"config":          FormatJSON,
+	}
+	for path, want := range tests {
+		if got := FormatFromPath(path); got != want {
+			t.Errorf("%s: format %s, want %s", path, got, want)
+		}
+	}
+}
+
+func TestEncodeSettingsRoundTrip(t *testing.T) {
+	settings := Settings{
+		Version:        CurrentVersion,
+		DefaultBackend: "gpu",
+		Backends: []Backend{
+			{Name: "gpu", URL: "http://gpu:3003", Labels: map[string]string{"site": "home"}},
+			{Name: "cpu", URL: "http://cpu:3003", Lifecycle: &Lifecycle{
+				Start:       &Hook{URL: "http://hooks/start", Timeout: Duration(10 * time.Second)},
+				IdleTimeout: Duration(15 * time.Minute),
+			}},
+		},
+		TaskRouting:      map[string]string{"ocr": "cpu"},
+		ModelTypeRouting: map[string]string{"textual": "site=home"},
+		OutlierDetection: &OutlierDetection{LatencyFactor: 2.5, MaxEjectionPercent: 30},
+	}
+	want, err := json.Marshal(settings)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, format := range []Format{FormatJSON, FormatYAML, FormatTOML} {
+		t.Run(string(format), func(t *testing.T) {
+			data, err := EncodeSettings(settings, format)
+			if err != nil {
+				t.Fatal(err)
+			}
+			doc, err := DecodeDocument(data, format)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if from, err := Migrate(doc); err != nil || from != CurrentVersion {
+				t.Fatalf("migrated from %d: %v", from, err)
+			}
+			decoded, err := settingsFromDocument(doc)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := json.Marshal(decoded)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != string(want) {
+				t.Errorf("round trip changed the settings:\n got %s\nwant %s", got, want)
+			}
+		})
+	}
+}
+
+func TestDecodeEmptyYAML(t *testing.T) {
+	doc, err := DecodeDocument([]byte(""), FormatYAML)
+	if err != nil || doc == nil {
+		t.Errorf("empty YAML decoded to %v, %v; want an empty document", doc, err)
+	}
+}
@@ -0,0 +1,301 @@
+package config
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"math"
+	"os"
+	"sort"
+	"time"
+)
+
+const (
+	historyRetention      = 7 * 24 * time.Hour // history older than this is dropped
+	maxHistoryTransitions = 2000               // per backend
+	maxPingChecks         = 1500               // per backend, a day of samples
+	maxDeepChecks         = 1000               // per backend
+	pingSampleInterval    = time.Minute        // /ping checks that do not change the status are kept at most this often
+	historySaveInterval   = time.Minute
+)
+
+// HealthTransition is a change of a backend's health status
+type HealthTransition struct {
+	Time  time.Time    `json:"time"`
+	From  HealthStatus `json:"from"`
+	To    HealthStatus `json:"to"`
+	Error string       `json:"error,omitempty"`
+}
+
+// HealthCheck is the result of a /ping health check or a deep check of a backend
+type HealthCheck struct {
+	Time      time.Time    `json:"time"`
+	Task      string       `json:"task,omitempty"` // task key of deep checks
+	Status    HealthStatus `json:"status"`
+	LatencyMs int64        `json:"latencyMs"`
+	Error     string       `json:"error,omitempty"`
+}
+
+// HealthHistory is the recorded health of a backend, oldest first. /ping is
+// polled every few seconds, so its checks are sampled: one that does not
+// change the status is only kept if the last kept one is a minute old.
+type HealthHistory struct {
+	Transitions []HealthTransition `json:"transitions"`
+	PingChecks  []HealthCheck      `json:"pingChecks"`
+	DeepChecks  []HealthCheck      `json:"deepChecks"`
+}
+
+// Uptime is the share of a time window a backend was healthy. Time in which
+// its health was unknown, e.g. while the proxy was not running, is left out.
+type Uptime struct {
+	Percent          *float64 `json:"percent"` // nil if the backend was not monitored in the window
+	MonitoredSeconds int64    `json:"monitoredSeconds"`
+	Failures         int      `json:"failures"` // transitions to unhealthy in the window
+}
+
+// historyFile is the persisted form of the health history
+type historyFile struct {
+	SavedAt  time.Time                 `json:"savedAt"`
+	Backends map[string]*HealthHistory `json:"backends"`
+}
+
+// recordTransition appends a transition if the status changed. Callers must hold mu.
+func (c *Config) recordTransition(backendName string, from, to HealthStatus, error string, now time.Time) {
+	if from == to {
+		return
+	}
+	history := c.historyOf(backendName)
+	history.Transitions = append(history.Transitions, HealthTransition{Time: now, From: from, To: to, Error: error})
+	if len(history.Transitions) > maxHistoryTransitions {
+		history.Transitions = history.Transitions[len(history.Transitions)-maxHistoryTransitions:]
+	}
+}
+
+// recordPingCheck appends a /ping check result if it changed the status or
+// the last one kept is older than pingSampleInterval. Callers must hold mu.
+func (c *Config) recordPingCheck(backendName string, check HealthCheck) {
+	history := c.historyOf(backendName)
+	if n := len(history.PingChecks); n > 0 {
+		last := history.PingChecks[n-1]
+		if last.Status == check.Status && check.Time.Sub(last.Time) < pingSampleInterval {
+			return
+		}
+	}
+	history.PingChecks = appendCheck(history.PingChecks, check, maxPingChecks)
+}
+
+// recordDeepCheck appends a deep check result. Callers must hold mu.
+func (c *Config) recordDeepCheck(backendName string, check HealthCheck) {
+	history := c.historyOf(backendName)
+	history.DeepChecks = appendCheck(history.DeepChecks, check, maxDeepChecks)
+}
+
+// appendCheck appends a check, dropping the oldest ones beyond max
+func appendCheck(checks []HealthCheck, check HealthCheck, max int) []HealthCheck {
+	checks = append(checks, check)
+	if len(checks) > max {
+		checks = checks[len(checks)-max:]
+	}
+	return checks
+}
+
+// historyOf returns the history of a backend, creating it if needed. Callers must hold mu.
+func (c *Config) historyOf(backendName string) *HealthHistory {
+	history, ok := c.history[backendName]
+	if !ok {
+		history = &HealthHistory{}
+		c.history[backendName] = history
+	}
+	return history
+}
+
+// RecordHealthCheck records the result of a /ping health check, sampled, and
+// updates the backend's health status
+func (c *Config) RecordHealthCheck(backendName string, status HealthStatus, latency time.Duration, error string) {
+	c.SetHealthStatus(backendName, status, error)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.recordPingCheck(backendName, HealthCheck{
+		Time:      time.Now(),
+		Status:    status,
+		LatencyMs: latency.Milliseconds(),
+		Error:     error,
+	})
+}
+
+// GetHealthHistory returns the transitions and checks of a backend since a
+// time. The transitions start with the one in effect at since, if any.
+func (c *Config) GetHealthHistory(backendName string, since time.Time) HealthHistory {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	result := HealthHistory{Transitions: []HealthTransition{}}
+	history, ok := c.history[backendName]
+	if !ok {
+		return result
+	}
+
+	first := sort.Search(len(history.Transitions), func(i int) bool {
+		return history.Transitions[i].Time.After(since)
+	})
+	if first > 0 {
+		first--
+	}
+	result.Transitions = append(result.Transitions, history.Transitions[first:]...)
+	result.PingChecks = checksSince(history.PingChecks, since)
+	result.DeepChecks = checksSince(history.DeepChecks, since)
+	return result
+}
+
+// checksSince returns a copy of the checks at or after since
+func checksSince(checks []HealthCheck, since time.Time) []HealthCheck {
+	result := []HealthCheck{}
+	for _, check := range checks {
+		if !check.Time.Before(since) {
+			result = append(result, check)
+		}
+	}
+	return result
+}
+
+// GetUptime returns the uptime of a backend over the window ending now
+func (c *Config) GetUptime(backendName string, window time.Duration) Uptime {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	var uptime Uptime
+	history, ok := c.history[backendName]
+	if !ok {
+		return uptime
+	}
+
+	now := time.Now()
+	start := now.Add(-window)
+	var healthy, monitored time.Duration
+	for i, transition := range history.Transitions {
+		from := transition.Time
+		to := now
+		if i+1 < len(history.Transitions) {
+			to = history.Transitions[i+1].Time
+		}
+		if from.Before(start) {
+			from = start
+		}
+		if to.After(from) && transition.To != HealthStatusUnknown {
+			monitored += to.Sub(from)
+			if transition.To == HealthStatusHealthy {
+				healthy += to.Sub(from)
+			}
+		}
+		if transition.To == HealthStatusUnhealthy && !transition.Time.Before(start) {
+			uptime.Failures++
+		}
+	}
+
+	uptime.MonitoredSeconds = int64(monitored.Seconds())
+	if monitored > 0 {
+		percent := math.Round(float64(healthy)/float64(monitored)*10000) / 100
+		uptime.Percent = &percent
+	}
+	return uptime
+}
+
+// LoadHealthHistory reads the health history persisted at path. The time the
+// proxy was not running since it was saved counts as unknown.
+func (c *Config) LoadHealthHistory(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	var file historyFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for name, history := range file.Backends {
+		if history == nil {
+			continue
+		}
+		if n := len(history.Transitions); n > 0 && history.Transitions[n-1].To != HealthStatusUnknown {
+			last := history.Transitions[n-1]
+			history.Transitions = append(history.Transitions, HealthTransition{
+				Time:  file.SavedAt,
+				From:  last.To,
+				To:    HealthStatusUnknown,
+				Error: "proxy stopped",
+			})
+		}
+		c.history[name] = history
+	}
+	c.pruneHistory(time.Now())
+	return nil
+}
+
+// RunHealthHistory saves the health history to path every minute and when
+// ctx is done
+func (c *Config) RunHealthHistory(ctx context.Context, path string) {
+	ticker := time.NewTicker(historySaveInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			if err := c.saveHealthHistory(path); err != nil {
+				log.Printf("Failed to save health history: %v", err)
+			}
+			return
+		case <-ticker.C:
+			if err := c.saveHealthHistory(path); err != nil {
+				log.Printf("Failed to save health history: %v", err)
+			}
+		}
+	}
+}
+
+// saveHealthHistory drops history that is too old or of removed backends and
+// writes the rest to path
+func (c *Config) saveHealthHistory(path string) error {
+	now := time.Now()
+	c.mu.Lock()
+	c.pruneHistory(now)
+	data, err := json.Marshal(historyFile{SavedAt: now, Backends: c.history})
+	c.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	// Write to a temporary file first so a crash never leaves a truncated history
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// pruneHistory drops history older than the retention, keeping the transition
+// in effect at its start, and the history of backends that are no longer
+// configured. Callers must hold mu.
+func (c *Config) pruneHistory(now time.Time) {
+	current := make(map[string]bool)
+	for _, backend := range c.Backends {
+		current[backend.Name] = true
+	}
+
+	cutoff := now.Add(-historyRetention)
+	for name, history := range c.history {
+		if !current[name] {
+			delete(c.history, name)
+			continue
+		}
+		first := sort.Search(len(history.Transitions), func(i int) bool {
+			return history.Transitions[i].Time.After(cutoff)
+		})
+		if first > 0 {
+			history.Transitions = history.Transitions[first-1:]
+		}
+		history.PingChecks = checksSince(history.PingChecks, cutoff)
+		history.DeepChecks = checksSince(history.DeepChecks, cutoff)
+	}
+}
@@ -0,0 +1,117 @@
+package config
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// transitionsAgo builds transitions from the given statuses and how long ago they happened
+func transitionsAgo(now time.Time, steps ...interface{}) []HealthTransition {
+	var result []HealthTransition
+	from := HealthStatusUnknown
+	for i := 0; i < len(steps); i += 2 {
+		to := steps[i+1].(HealthStatus)
+		result = append(result, HealthTransition{Time: now.Add(-steps[i].(time.Duration)), From: from, To: to})
+		from = to
+	}
+	return result
+}
+
+func TestGetUptime(t *testing.T) {
+	h := time.Hour
+	tests := []struct {
+		name      string
+		steps     []interface{} // how long ago, status
+		percent   float64       // -1 if not monitored
+		monitored time.Duration
+		failures  int
+	}{
+		{"no history", nil, -1, 0, 0},
+		{"healthy throughout", []interface{}{20 * h, HealthStatusHealthy}, 100, 10 * h, 0},
+		{"one outage", []interface{}{8 * h, HealthStatusHealthy, 6 * h, HealthStatusUnhealthy, 5 * h, HealthStatusHealthy}, 87.5, 8 * h, 1},
+		{"unknown gap is left out", []interface{}{8 * h, HealthStatusHealthy, 6 * h, HealthStatusUnknown, 2 * h, HealthStatusHealthy}, 100, 4 * h, 0},
+		{"outage before the window", []interface{}{12 * h, HealthStatusUnhealthy, 5 * h, HealthStatusHealthy}, 50, 10 * h, 0},
+		{"unknown throughout", []interface{}{5 * h, HealthStatusUnknown}, -1, 0, 0},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c := testConfig(t, Settings{})
+			if test.steps != nil {
+				c.history["gpu"] = &HealthHistory{Transitions: transitionsAgo(time.Now(), test.steps...)}
+			}
+
+			uptime := c.GetUptime("gpu", 10*h)
+			if test.percent < 0 {
+				if uptime.Percent != nil {
+					t.Errorf("uptime %.2f%%, want none", *uptime.Percent)
+				}
+			} else if uptime.Percent == nil || *uptime.Percent != test.percent {
+				t.Errorf("uptime %v, want %.2f%%", uptime.Percent, test.percent)
+			}
+			if diff := uptime.MonitoredSeconds - int64(test.monitored.Seconds()); diff < -1 || diff > 1 {
+				t.Errorf("monitored %ds, want %ds", uptime.MonitoredSeconds, int64(test.monitored.Seconds()))
+			}
+			if uptime.Failures != test.failures {
+				t.Errorf("%d failures, want %d", uptime.Failures, test.failures)
+			}
+		})
+	}
+}
+
+func TestPruneHistory(t *testing.T) {
+	now := time.Now()
+	day := 24 * time.Hour
+	c := testConfig(t, Settings{Backends: []Backend{{Name: "gpu"}}})
+	c.history["gpu"] = &HealthHistory{
+		Transitions: transitionsAgo(now, 10*day, HealthStatusHealthy, 8*day, HealthStatusUnhealthy, day, HealthStatusHealthy),
+		PingChecks:  []HealthCheck{{Time: now.Add(-8 * day)}, {Time: now.Add(-time.Hour)}},
+		DeepChecks:  []HealthCheck{{Time: now.Add(-8 * day)}},
+	}
+	c.history["removed"] = &HealthHistory{Transitions: transitionsAgo(now, time.Hour, HealthStatusHealthy)}
+
+	c.pruneHistory(now)
+	if _, ok := c.history["removed"]; ok {
+		t.Error("history of a removed backend was kept")
+	}
+	history := c.history["gpu"]
+	// The transition in effect when the retention starts is kept
+	if len(history.Transitions) != 2 || history.Transitions[0].To != HealthStatusUnhealthy {
+		t.Errorf("transitions %+v, want the last two", history.Transitions)
+	}
+	if len(history.PingChecks) != 1 || len(history.DeepChecks) != 0 {
+		t.Errorf("%d ping and %d deep checks, want 1 and 0", len(history.PingChecks), len(history.DeepChecks))
+	}
+}
+
+func TestLoadHealthHistoryMarksDowntimeUnknown(t *testing.T) {
+	now := time.Now()
+	path := filepath.Join(t.TempDir(), "history.json")
+	data, err := json.Marshal(historyFile{
+		SavedAt:  now.Add(-time.Hour),
+		Backends: map[string]*HealthHistory{"gpu": {Transitions: transitionsAgo(now, 3*time.Hour, HealthStatusHealthy)}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	c := testConfig(t, Settings{Backends: []Backend{{Name: "gpu"}}})
+	if err := c.LoadHealthHistory(path); err != nil {
+		t.Fatal(err)
+	}
+	transitions := c.history["gpu"].Transitions
+	if len(transitions) != 2 || transitions[1].To != HealthStatusUnknown {
+		t.Fatalf("transitions %+v, want the healthy one followed by unknown", transitions)
+	}
+
+	// The hour the proxy was not running does not count against the uptime
+	uptime := c.GetUptime("gpu", 24*time.Hour)
+	if uptime.Percent == nil || *uptime.Percent != 100 || uptime.MonitoredSeconds != int64((2*time.Hour).Seconds()) {
+		t.Errorf("uptime %v over %ds, want 100%% over 2h", uptime.Percent, uptime.MonitoredSeconds)
+	}
+}
@@ -0,0 +1,81 @@
+package config
+
+import "fmt"
+
+// KeepWarm sends minimal predict requests at an interval so backends keep
+// their models loaded instead of unloading them after their idle TTL
+type KeepWarm struct {
+	Interval  Duration    `json:"interval,omitempty"`  // default 4m, below Immich ML's default model TTL of 5m
+	Timeout   Duration    `json:"timeout,omitempty"`   // default 2m, loading a model can take a while
+	Schedules []string    `json:"schedules,omitempty"` // names of schedules; warm only while one is active, always if empty
+	Models    []TaskModel `json:"models" schema:"required"`
+}
+
+// GetKeepWarm returns a copy of the keep-warm settings, nil if disabled
+func (c *Config) GetKeepWarm() *KeepWarm {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if c.KeepWarm == nil {
+		return nil
+	}
+	return c.KeepWarm.clone()
+}
+
+// KeepWarmActive reports whether keep-warm requests should be sent now, i.e.
+// keep-warm is configured and one of its schedules is active, if it has any
+func (c *Config) KeepWarmActive() bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if c.KeepWarm == nil {
+		return false
+	}
+	if len(c.KeepWarm.Schedules) == 0 {
+		return true
+	}
+	for _, name := range c.KeepWarm.Schedules {
+		for _, active := range c.schedule.Active {
+			if name == active {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (k *KeepWarm) clone() *KeepWarm {
+	result := *k
+	result.Schedules = append([]string(nil), k.Schedules...)
+	result.Models = append([]TaskModel(nil), k.Models...)
+	return &result
+}
+
+// validate checks the keep-warm settings, if any
+func (k *KeepWarm) validate(schedules []Schedule) error {
+	if k == nil {
+		return nil
+	}
+	if k.Interval < 0 || k.Timeout < 0 {
+		return fmt.Errorf("interval and timeout must not be negative")
+	}
+	if len(k.Models) == 0 {
+		return fmt.Errorf("at least one model is required")
+	}
+	for i, model := range k.Models {
+		if model.Task == "" || model.ModelType == "" || model.ModelName == "" {
+			return fmt.Errorf("model %d: task, modelType and modelName are required", i+1)
+		}
+	}
+	for _, name := range k.Schedules {
+		found := false
+		for _, schedule := range schedules {
+			if schedule.Name == name {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("schedule %s does not exist", name)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,91 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+)
+
+// CurrentVersion is the config schema version written by this build
+const CurrentVersion = 2
+
+// migration upgrades a generic config document by exactly one version
+type migration func(doc map[string]interface{}) error
+
+// migrations maps a version to the step that upgrades it to the next version.
+// Files written before the version field existed are treated as version 1.
+var migrations = map[int]migration{
+	1: migrateV1ToV2,
+}
+
+// Migrate upgrades doc in place to CurrentVersion and returns the version it started from
+func Migrate(doc map[string]interface{}) (int, error) {
+	version, err := documentVersion(doc)
+	if err != nil {
+		return 0, err
+	}
+	if version > CurrentVersion {
+		return version, fmt.Errorf("config version %d is newer than supported version %d", version, CurrentVersion)
+	}
+
+	from := version
+	for version < CurrentVersion {
+		step, ok := migrations[version]
+		if !ok {
+			return from, fmt.Errorf("no migration from config version %d", version)
+		}
+		if err := step(doc); err != nil {
+			return from, fmt.Errorf("migrating config from version %d: %w", version, err)
+		}
+		version++
+		doc["version"] = version
+	}
+	return from, nil
+}
+
+// documentVersion reads the version field of a generic document
+func documentVersion(doc map[string]interface{}) (int, error) {
+	raw, ok := doc["version"]
+	if !ok || raw == nil {
+		return 1, nil
+	}
+
+	switch v := raw.(type) {
+	case json.Number:
+		i, err := v.Int64()
+		if err != nil {
+			return 0, fmt.Errorf("invalid config version: %s", v)
+		}
+		return int(i), nil
+	case int:
+		return v, nil
+	case int64:
+		return int(v), nil
+	case float64:
+		return int(v), nil
+	case string:
+		i, err := strconv.Atoi(v)
+		if err != nil {
+			return 0, fmt.Errorf("invalid config version: %q", v)
+		}
+		return i, nil
+	default:
+		return 0, fmt.Errorf("invalid config version: %v", raw)
+	}
+}
+
+// migrateV1ToV2 upgrades unversioned files, which may predate modelTypeRouting
+func migrateV1ToV2(doc map[string]interface{}) error {
+	if _, ok := doc["backends"]; !ok {
+		doc["backends"] = []interface{}{}
+	}
+	for _, key := range []string{"taskRouting", "modelTypeRouting"} {
+		if value, ok := doc[key]; !ok || value == nil {
+			doc[key] = map[string]interface{}{}
+		}
+	}
+	if _, ok := doc["defaultBackend"]; !ok {
+		doc["defaultBackend"] = ""
+	}
+	return nil
+}
@@ -0,0 +1,55 @@
+package config
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestMigrate(t *testing.T) {
+	tests := []struct {
+		name    string
+		doc     map[string]interface{}
+		from    int
+		wantErr bool
+	}{
+		{"unversioned", map[string]interface{}{"defaultBackend": "gpu"}, 1, false},
+		{"version 1", map[string]interface{}{"version": json.Number("1")}, 1, false},
+		{"current", map[string]interface{}{"version": json.Number("2"), "backends": []interface{}{}}, 2, false},
+		{"string version", map[string]interface{}{"version": "1"}, 1, false},
+		{"yaml version", map[string]interface{}{"version": 2}, 2, false},
+		{"newer", map[string]interface{}{"version": json.Number("3")}, 3, true},
+		{"invalid", map[string]interface{}{"version": "two"}, 0, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			from, err := Migrate(test.doc)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("error %v, want error %v", err, test.wantErr)
+			}
+			if from != test.from {
+				t.Errorf("migrated from version %d, want %d", from, test.from)
+			}
+			if version, _ := documentVersion(test.doc); err == nil && version != CurrentVersion {
+				t.Errorf("version %d after migration, want %d", version, CurrentVersion)
+			}
+		})
+	}
+}
+
+func TestMigrateV1FillsMissingFields(t *testing.T) {
+	doc := map[string]interface{}{
+		"defaultBackend": "gpu",
+		"taskRouting":    nil,
+	}
+	if _, err := Migrate(doc); err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"backends", "taskRouting", "modelTypeRouting"} {
+		if doc[key] == nil {
+			t.Errorf("%s missing after migration", key)
+		}
+	}
+	if doc["defaultBackend"] != "gpu" {
+		t.Errorf("defaultBackend %v, want it kept", doc["defaultBackend"])
+	}
+}
@@ -0,0 +1,131 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"text/template"
+)
+
+// Notifications sends alerts about health transitions, ejections and
+// configuration changes to webhooks, ntfy or Gotify
+type Notifications struct {
+	MinDuration Duration             `json:"minDuration,omitempty"` // a new backend or task state must last this long before it is notified; default 1m
+	Debounce    Duration             `json:"debounce,omitempty"`    // minimum time between notifications about the same subject; default 5m
+	Targets     []NotificationTarget `json:"targets" schema:"required"`
+}
+
+// NotificationTarget is an endpoint notifications are sent to
+type NotificationTarget struct {
+	Name     string            `json:"name" schema:"required"`
+	Type     string            `json:"type" schema:"required" enum:"webhook,ntfy,gotify"`
+	URL      string            `json:"url" schema:"required"` // webhook URL, ntfy topic URL or Gotify server URL
+	Headers  map[string]string `json:"headers,omitempty"`     // extra request headers
+	Template string            `json:"template,omitempty"`    // webhook body as a Go template over the event; default the event as JSON
+	Token    string            `json:"token,omitempty"`       // ntfy access token or Gotify application token
+	Priority int               `json:"priority,omitempty"`    // ntfy 1-5, Gotify 0-10; 0 = server default
+	Events   []string          `json:"events,omitempty"`      // backend, task, ejection or config; default all
+}
+
+const (
+	NotifyWebhook = "webhook"
+	NotifyNtfy    = "ntfy"
+	NotifyGotify  = "gotify"
+)
+
+// Notification event kinds
+const (
+	EventBackend  = "backend"  // backend health changed
+	EventTask     = "task"     // deep check outcome of a task on a backend changed
+	EventEjection = "ejection" // outlier detection ejected a backend
+	EventConfig   = "config"   // configuration changed
+)
+
+// TemplateFuncs are the functions available in webhook templates
+var TemplateFuncs = template.FuncMap{
+	// json encodes a value as JSON, e.g. "text": {{json .Message}}
+	"json": func(v interface{}) (string, error) {
+		data, err := json.Marshal(v)
+		return string(data), err
+	},
+}
+
+// Wants reports whether the target is notified about events of the kind
+func (t NotificationTarget) Wants(kind string) bool {
+	if len(t.Events) == 0 {
+		return true
+	}
+	for _, event := range t.Events {
+		if event == kind {
+			return true
+		}
+	}
+	return false
+}
+
+// GetNotifications returns a copy of the notification settings, nil if disabled
+func (c *Config) GetNotifications() *Notifications {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if c.Notifications == nil {
+		return nil
+	}
+	return c.Notifications.clone()
+}
+
+func (n *Notifications) clone() *Notifications {
+	result := *n
+	result.Targets = append([]NotificationTarget(nil), n.Targets...)
+	return &result
+}
+
+// validate checks the notification settings, if any
+func (n *Notifications) validate() error {
+	if n == nil {
+		return nil
+	}
+	if n.MinDuration < 0 || n.Debounce < 0 {
+		return fmt.Errorf("minDuration and debounce must not be negative")
+	}
+	names := make(map[string]bool)
+	for _, target := range n.Targets {
+		if target.Name == "" {
+			return fmt.Errorf("target name must not be empty")
+		}
+		if names[target.Name] {
+			return fmt.Errorf("duplicate target name: %s", target.Name)
+		}
+		names[target.Name] = true
+
+		switch target.Type {
+		case NotifyWebhook, NotifyNtfy, NotifyGotify:
+		default:
+			return fmt.Errorf("target %s: type must be webhook, ntfy or gotify", target.Name)
+		}
+		if u, err := url.Parse(target.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("target %s: url must be an http(s) URL", target.Name)
+		}
+		if target.Template != "" {
+			if target.Type != NotifyWebhook {
+				return fmt.Errorf("target %s: template is only supported for webhooks", target.Name)
+			}
+			if _, err := template.New(target.Name).Funcs(TemplateFuncs).Parse(target.Template); err != nil {
+				return fmt.Errorf("target %s: template: %v", target.Name, err)
+			}
+		}
+		if target.Type == NotifyNtfy && (target.Priority < 0 || target.Priority > 5) {
+			return fmt.Errorf("target %s: priority must be between 0 and 5 (0 = server default)", target.Name)
+		}
+		if target.Type == NotifyGotify && (target.Priority < 0 || target.Priority > 10) {
+			return fmt.Errorf("target %s: priority must be between 0 and 10", target.Name)
+		}
+		for _, event := range target.Events {
+			switch event {
+			case EventBackend, EventTask, EventEjection, EventConfig:
+			default:
+				return fmt.Errorf("target %s: unknown event %q", target.Name, event)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,43 @@
+package config
+
+import "fmt"
+
+// OutlierDetection ejects backends whose error rate or latency is far worse
+// than that of the other backends in their pool
+type OutlierDetection struct {
+	Interval           Duration `json:"interval,omitempty"`           // how often backends are compared; default 10s
+	MinRequests        int      `json:"minRequests,omitempty"`        // requests a backend needs in its stats window to be judged; default 10
+	ErrorRateMargin    float64  `json:"errorRateMargin,omitempty"`    // eject when the error rate exceeds the pool's median by this much; default 0.3
+	LatencyFactor      float64  `json:"latencyFactor,omitempty"`      // eject when median latency exceeds this multiple of the pool's median; default 3
+	BaseEjectionTime   Duration `json:"baseEjectionTime,omitempty"`   // first ejection, multiplied by the number of ejections; default 30s
+	MaxEjectionTime    Duration `json:"maxEjectionTime,omitempty"`    // default 5m
+	MaxEjectionPercent int      `json:"maxEjectionPercent,omitempty"` // share of a pool that may be ejected at once; default 50
+}
+
+// GetOutlierDetection returns a copy of the outlier detection settings, nil if disabled
+func (c *Config) GetOutlierDetection() *OutlierDetection {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if c.OutlierDetection == nil {
+		return nil
+	}
+	settings := *c.OutlierDetection
+	return &settings
+}
+
+// validate checks the outlier detection settings, if any
+func (o *OutlierDetection) validate() error {
+	if o == nil {
+		return nil
+	}
+	if o.Interval < 0 || o.MinRequests < 0 || o.ErrorRateMargin < 0 || o.LatencyFactor < 0 || o.BaseEjectionTime < 0 || o.MaxEjectionTime < 0 {
+		return fmt.Errorf("settings must not be negative")
+	}
+	if o.LatencyFactor > 0 && o.LatencyFactor < 1 {
+		return fmt.Errorf("latencyFactor must be at least 1")
+	}
+	if o.MaxEjectionPercent < 0 || o.MaxEjectionPercent > 100 {
+		return fmt.Errorf("maxEjectionPercent must be between 0 and 100")
+	}
+	return nil
+}
@@ -0,0 +1,54 @@
+package config
+
+import "fmt"
+
+// DefaultPriorityClass is the class of requests whose rule names no priority
+const DefaultPriorityClass = "default"
+
+// PriorityClass is a scheduling lane. When a backend's concurrency slots are
+// all in use, queued requests are dequeued weighted-fair across classes, so a
+// class with weight 8 gets eight slots for every slot of a class with weight 1.
+type PriorityClass struct {
+	Name   string `json:"name" schema:"required"`
+	Weight int    `json:"weight" schema:"required"`
+}
+
+// GetPriorityClasses returns a copy of the configured priority classes
+func (c *Config) GetPriorityClasses() []PriorityClass {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return append([]PriorityClass{}, c.PriorityClasses...)
+}
+
+// PriorityWeight returns the weight of a priority class. The default class
+// and unknown classes have weight 1 unless configured.
+func (c *Config) PriorityWeight(class string) int {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	for _, pc := range c.PriorityClasses {
+		if pc.Name == class {
+			return pc.Weight
+		}
+	}
+	return 1
+}
+
+// validatePriorityClasses checks class names and weights
+func validatePriorityClasses(classes []PriorityClass) (map[string]bool, error) {
+	names := map[string]bool{DefaultPriorityClass: true}
+	seen := make(map[string]bool, len(classes))
+	for _, pc := range classes {
+		if pc.Name == "" {
+			return nil, fmt.Errorf("priority class name must not be empty")
+		}
+		if seen[pc.Name] {
+			return nil, fmt.Errorf("duplicate priority class: %s", pc.Name)
+		}
+		seen[pc.Name] = true
+		names[pc.Name] = true
+		if pc.Weight < 1 {
+			return nil, fmt.Errorf("priority class %s: weight must be at least 1", pc.Name)
+		}
+	}
+	return names, nil
+}
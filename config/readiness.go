@@ -0,0 +1,54 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Readiness selects the tasks the proxy has to be able to serve to be ready
+type Readiness struct {
+	RequiredTasks []string `json:"requiredTasks,omitempty"`            // task or task.modelType, e.g. clip or facial-recognition.detection; default all routed tasks
+	Ping          string   `json:"ping,omitempty" enum:"all,required"` // what /ping checks; default all
+}
+
+const (
+	PingAll      = "all"      // default backend and all routed tasks, like Immich ML expects
+	PingRequired = "required" // only the required tasks
+)
+
+// GetReadiness returns a copy of the readiness settings, empty if not configured
+func (c *Config) GetReadiness() Readiness {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if c.Readiness == nil {
+		return Readiness{}
+	}
+	readiness := *c.Readiness
+	readiness.RequiredTasks = append([]string(nil), c.Readiness.RequiredTasks...)
+	return readiness
+}
+
+// SplitTask splits a task or task.modelType routing key
+func SplitTask(key string) (task, modelType string) {
+	task, modelType, _ = strings.Cut(key, ".")
+	return task, modelType
+}
+
+// validate checks the readiness settings, if any
+func (r *Readiness) validate() error {
+	if r == nil {
+		return nil
+	}
+	if r.Ping != "" && r.Ping != PingAll && r.Ping != PingRequired {
+		return fmt.Errorf("ping must be all or required")
+	}
+	if r.Ping == PingRequired && len(r.RequiredTasks) == 0 {
+		return fmt.Errorf("ping is required but no requiredTasks are set")
+	}
+	for _, task := range r.RequiredTasks {
+		if name, _ := SplitTask(task); name == "" {
+			return fmt.Errorf("required task %q is empty", task)
+		}
+	}
+	return nil
+}
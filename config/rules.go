@@ -0,0 +1,141 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"path"
+	"strings"
+)
+
+// RoutingRule routes matching predict requests to a target pool. Rules are
+// evaluated in order and the first matching rule with a non-empty pool wins;
+// taskRouting, modelTypeRouting and defaultBackend apply after all rules.
+type RoutingRule struct {
+	Name   string    `json:"name" schema:"required"`
+	Match  RuleMatch `json:"match"`
+	Target string    `json:"target,omitempty"` // backend name or label selector
+	Tiers  []Tier    `json:"tiers,omitempty"`  // ordered targets with spill conditions, instead of target
+
+	// Optional overrides for requests routed by this rule
+	Timeout Duration `json:"timeout,omitempty"` // request timeout
+	Retries int      `json:"retries,omitempty"` // retries on other backends of the pool after a failure
+
+	Priority string `json:"priority,omitempty"`                                              // priority class, see PriorityClass
+	Balance  string `json:"balance,omitempty" enum:"round-robin,consistent-hash,least-time"` // default round-robin
+}
+
+// Balancing strategies of a rule
+const (
+	BalanceRoundRobin     = "round-robin"
+	BalanceConsistentHash = "consistent-hash" // same image or text goes to the same backend
+	BalanceLeastTime      = "least-time"      // lowest estimated completion time for the payload size
+)
+
+// Tier is one level of a spillover chain. Requests go to the first tier
+// whose spill conditions do not hold; the last tier takes whatever is left.
+type Tier struct {
+	Target    string         `json:"target" schema:"required"` // backend name or label selector
+	SpillWhen SpillCondition `json:"spillWhen"`
+}
+
+// SpillCondition describes when a tier is too busy and requests spill to the
+// next tier. Any condition that is set and holds causes a spill; a tier
+// without healthy backends always spills.
+type SpillCondition struct {
+	QueueLength int      `json:"queueLength,omitempty"` // requests waiting for a slot across the tier's backends
+	LatencyP95  Duration `json:"latencyP95,omitempty"`  // p95 latency of the tier's fastest healthy backend
+}
+
+// RuleMatch lists the conditions of a rule. Empty conditions match anything;
+// all non-empty conditions must match.
+type RuleMatch struct {
+	Tasks         []string          `json:"tasks,omitempty"`         // e.g. clip, facial-recognition
+	ModelTypes    []string          `json:"modelTypes,omitempty"`    // e.g. textual, visual, detection
+	ModelNames    []string          `json:"modelNames,omitempty"`    // glob patterns, e.g. ViT-*
+	MinImageBytes int64             `json:"minImageBytes,omitempty"` // size of the uploaded image
+	MaxImageBytes int64             `json:"maxImageBytes,omitempty"`
+	Clients       []string          `json:"clients,omitempty"` // client IPs or CIDRs
+	Headers       map[string]string `json:"headers,omitempty"` // header name -> glob pattern ("*" = present)
+}
+
+// GetRules returns a copy of the routing rules
+func (c *Config) GetRules() []RoutingRule {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return append([]RoutingRule{}, c.Rules...)
+}
+
+// validateRules checks rule names, targets, tiers, priority classes and patterns
+func validateRules(rules []RoutingRule, names map[string]bool, classes map[string]bool) error {
+	seen := make(map[string]bool, len(rules))
+	for _, rule := range rules {
+		if rule.Name == "" {
+			return fmt.Errorf("rule name must not be empty")
+		}
+		if seen[rule.Name] {
+			return fmt.Errorf("duplicate rule name: %s", rule.Name)
+		}
+		seen[rule.Name] = true
+
+		if (rule.Target == "") == (len(rule.Tiers) == 0) {
+			return fmt.Errorf("rule %s: exactly one of target and tiers must be set", rule.Name)
+		}
+		if rule.Target != "" {
+			if err := validateTarget(rule.Target, names); err != nil {
+				return fmt.Errorf("rule %s: %v", rule.Name, err)
+			}
+		}
+		for i, tier := range rule.Tiers {
+			if err := validateTarget(tier.Target, names); err != nil {
+				return fmt.Errorf("rule %s: tier %d: %v", rule.Name, i+1, err)
+			}
+			if tier.SpillWhen.QueueLength < 0 || tier.SpillWhen.LatencyP95 < 0 {
+				return fmt.Errorf("rule %s: tier %d: spill conditions must not be negative", rule.Name, i+1)
+			}
+		}
+		if rule.Timeout < 0 || rule.Retries < 0 {
+			return fmt.Errorf("rule %s: timeout and retries must not be negative", rule.Name)
+		}
+		switch rule.Balance {
+		case "", BalanceRoundRobin, BalanceConsistentHash, BalanceLeastTime:
+		default:
+			return fmt.Errorf("rule %s: unknown balance %q", rule.Name, rule.Balance)
+		}
+		if rule.Priority != "" && !classes[rule.Priority] {
+			return fmt.Errorf("rule %s: unknown priority class %s", rule.Name, rule.Priority)
+		}
+		if err := rule.Match.validate(); err != nil {
+			return fmt.Errorf("rule %s: %v", rule.Name, err)
+		}
+	}
+	return nil
+}
+
+func (m RuleMatch) validate() error {
+	for _, pattern := range m.ModelNames {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid modelName pattern %q", pattern)
+		}
+	}
+	for header, pattern := range m.Headers {
+		if header == "" {
+			return fmt.Errorf("header name must not be empty")
+		}
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid pattern %q for header %s", pattern, header)
+		}
+	}
+	for _, client := range m.Clients {
+		if strings.Contains(client, "/") {
+			if _, _, err := net.ParseCIDR(client); err != nil {
+				return fmt.Errorf("invalid client CIDR %q", client)
+			}
+		} else if net.ParseIP(client) == nil {
+			return fmt.Errorf("invalid client IP %q", client)
+		}
+	}
+	if m.MaxImageBytes > 0 && m.MinImageBytes > m.MaxImageBytes {
+		return fmt.Errorf("minImageBytes is larger than maxImageBytes")
+	}
+	return nil
+}
@@ -0,0 +1,393 @@
+package config
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Profile is a named set of routing overrides that a schedule can activate.
+// Routing map entries override the base entries with the same key, rules are
+// evaluated before the base rules, and a default backend replaces the base one.
+type Profile struct {
+	DefaultBackend   string            `json:"defaultBackend,omitempty"`
+	TaskRouting      map[string]string `json:"taskRouting,omitempty"`
+	ModelTypeRouting map[string]string `json:"modelTypeRouting,omitempty"`
+	Rules            []RoutingRule     `json:"rules,omitempty"`
+}
+
+// Schedule activates a profile and enables or disables backends during its
+// time windows. When several schedules with a profile are active, the first
+// one in the list wins.
+type Schedule struct {
+	Name     string       `json:"name" schema:"required"`
+	Timezone string       `json:"timezone,omitempty"` // IANA name, e.g. Europe/Berlin; default is the local timezone
+	Windows  []TimeWindow `json:"windows" schema:"required"`
+	Profile  string       `json:"profile,omitempty"`
+
+	// Backend names or label selectors. Backends listed in enableBackends of
+	// any schedule are disabled outside the windows of those schedules.
+	EnableBackends  []string `json:"enableBackends,omitempty"`
+	DisableBackends []string `json:"disableBackends,omitempty"`
+}
+
+// TimeWindow is a daily time range on some weekdays. An end before the start
+// spans midnight and belongs to the day it starts on.
+type TimeWindow struct {
+	Days  []string `json:"days,omitempty"`          // mon, tue, ... sun; empty means every day
+	Start string   `json:"start" schema:"required"` // HH:MM
+	End   string   `json:"end" schema:"required"`   // HH:MM, end of the window (exclusive)
+}
+
+// ScheduleState is the outcome of evaluating the schedules at one point in time
+type ScheduleState struct {
+	Profile   string            `json:"profile,omitempty"`
+	Active    []string          `json:"active"`             // names of active schedules
+	Disabled  map[string]string `json:"disabled,omitempty"` // backend name -> schedule that disabled it
+	Evaluated time.Time         `json:"evaluated"`
+}
+
+// Routing holds the routing settings in effect, i.e. the base settings with
+// the overrides of the active profile applied
+type Routing struct {
+	Profile          string
+	DefaultBackend   string
+	TaskRouting      map[string]string
+	ModelTypeRouting map[string]string
+	Rules            []RoutingRule
+}
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday,
+	"thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday,
+}
+
+// RunSchedules re-evaluates the schedules at every minute boundary until ctx
+// is done, so profiles switch at the start and end of their windows
+func (c *Config) RunSchedules(ctx context.Context) {
+	for {
+		now := time.Now()
+		timer := time.NewTimer(now.Truncate(time.Minute).Add(time.Minute).Sub(now))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+			c.mu.Lock()
+			c.refreshSchedules()
+			c.mu.Unlock()
+		}
+	}
+}
+
+// GetScheduleState returns the current schedule state
+func (c *Config) GetScheduleState() ScheduleState {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	state := c.schedule
+	state.Active = append([]string{}, c.schedule.Active...)
+	state.Disabled = make(map[string]string, len(c.schedule.Disabled))
+	for k, v := range c.schedule.Disabled {
+		state.Disabled[k] = v
+	}
+	return state
+}
+
+// ActiveRouting returns the routing settings in effect
+func (c *Config) ActiveRouting() Routing {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.activeRouting()
+}
+
+// activeRouting merges the active profile into the base routing. Callers must hold mu.
+func (c *Config) activeRouting() Routing {
+	routing := Routing{
+		Profile:          c.schedule.Profile,
+		DefaultBackend:   c.DefaultBackend,
+		TaskRouting:      make(map[string]string, len(c.TaskRouting)),
+		ModelTypeRouting: make(map[string]string, len(c.ModelTypeRouting)),
+		Rules:            append([]RoutingRule{}, c.Rules...),
+	}
+	for k, v := range c.TaskRouting {
+		routing.TaskRouting[k] = v
+	}
+	for k, v := range c.ModelTypeRouting {
+		routing.ModelTypeRouting[k] = v
+	}
+
+	profile, ok := c.Profiles[c.schedule.Profile]
+	if !ok {
+		return routing
+	}
+	if profile.DefaultBackend != "" {
+		routing.DefaultBackend = profile.DefaultBackend
+	}
+	for k, v := range profile.TaskRouting {
+		routing.TaskRouting[k] = v
+	}
+	for k, v := range profile.ModelTypeRouting {
+		routing.ModelTypeRouting[k] = v
+	}
+	routing.Rules = append(append([]RoutingRule{}, profile.Rules...), c.Rules...)
+	return routing
+}
+
+// GetActiveDefaultBackend returns the default backend of the active profile,
+// or nil if there is none or a schedule disabled it
+func (c *Config) GetActiveDefaultBackend() *Backend {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	name := c.activeRouting().DefaultBackend
+	for _, backend := range c.resolveTarget(name) {
+		if backend.Name == name {
+			return &backend
+		}
+	}
+	return nil
+}
+
+// IsBackendEnabled reports whether the schedules currently allow requests to the backend
+func (c *Config) IsBackendEnabled(name string) bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	_, disabled := c.schedule.Disabled[name]
+	return !disabled
+}
+
+// refreshSchedules evaluates the schedules now and logs changes. Callers must hold mu.
+func (c *Config) refreshSchedules() {
+	state := c.Settings.evaluateSchedules(time.Now())
+
+	if state.Profile != c.schedule.Profile {
+		if state.Profile == "" {
+			log.Printf("Routing profile %s deactivated", c.schedule.Profile)
+		} else {
+			log.Printf("Routing profile %s activated", state.Profile)
+		}
+	}
+	for name, schedule := range state.Disabled {
+		if _, ok := c.schedule.Disabled[name]; !ok {
+			log.Printf("Backend %s disabled by schedule %s", name, schedule)
+		}
+	}
+	for name := range c.schedule.Disabled {
+		if _, ok := state.Disabled[name]; !ok {
+			log.Printf("Backend %s enabled by schedules", name)
+		}
+	}
+	c.schedule = state
+}
+
+// evaluateSchedules returns which schedules, profile and disabled backends apply at now
+func (s Settings) evaluateSchedules(now time.Time) ScheduleState {
+	state := ScheduleState{
+		Active:    []string{},
+		Disabled:  make(map[string]string),
+		Evaluated: now,
+	}
+
+	active := make(map[string]bool, len(s.Schedules))
+	for _, schedule := range s.Schedules {
+		if schedule.activeAt(now) {
+			active[schedule.Name] = true
+			state.Active = append(state.Active, schedule.Name)
+			if state.Profile == "" && schedule.Profile != "" {
+				state.Profile = schedule.Profile
+			}
+		}
+	}
+
+	// Backends that are only enabled during some schedules
+	enabledBy := make(map[string][]string)
+	for _, schedule := range s.Schedules {
+		for _, target := range schedule.EnableBackends {
+			for _, backend := range s.matchBackends(target) {
+				enabledBy[backend] = append(enabledBy[backend], schedule.Name)
+			}
+		}
+	}
+	for backend, schedules := range enabledBy {
+		enabled := false
+		for _, name := range schedules {
+			enabled = enabled || active[name]
+		}
+		if !enabled {
+			state.Disabled[backend] = strings.Join(schedules, ",")
+		}
+	}
+
+	for _, schedule := range s.Schedules {
+		if !active[schedule.Name] {
+			continue
+		}
+		for _, target := range schedule.DisableBackends {
+			for _, backend := range s.matchBackends(target) {
+				if _, ok := state.Disabled[backend]; !ok {
+					state.Disabled[backend] = schedule.Name
+				}
+			}
+		}
+	}
+
+	sort.Strings(state.Active)
+	return state
+}
+
+// matchBackends returns the names of the backends a name or selector refers to
+func (s Settings) matchBackends(target string) []string {
+	var names []string
+	if !IsSelector(target) {
+		for _, backend := range s.Backends {
+			if backend.Name == target {
+				names = append(names, backend.Name)
+			}
+		}
+		return names
+	}
+	selector, err := ParseSelector(target)
+	if err != nil {
+		return nil
+	}
+	for _, backend := range s.Backends {
+		if selector.Matches(backend.Labels) {
+			names = append(names, backend.Name)
+		}
+	}
+	return names
+}
+
+// activeAt reports whether any window of the schedule contains t
+func (s Schedule) activeAt(t time.Time) bool {
+	location, err := loadLocation(s.Timezone)
+	if err != nil {
+		return false
+	}
+	t = t.In(location)
+	for _, window := range s.Windows {
+		if window.contains(t) {
+			return true
+		}
+	}
+	return false
+}
+
+// contains reports whether t, in the schedule's timezone, falls into the window
+func (w TimeWindow) contains(t time.Time) bool {
+	start, err1 := parseClock(w.Start)
+	end, err2 := parseClock(w.End)
+	if err1 != nil || err2 != nil {
+		return false
+	}
+	minute := t.Hour()*60 + t.Minute()
+
+	switch {
+	case start == end:
+		return w.onDay(t.Weekday())
+	case start < end:
+		return w.onDay(t.Weekday()) && minute >= start && minute < end
+	default:
+		// Spans midnight: the evening part of today's window or the morning part of yesterday's
+		yesterday := (t.Weekday() + 6) % 7
+		return (w.onDay(t.Weekday()) && minute >= start) || (w.onDay(yesterday) && minute < end)
+	}
+}
+
+func (w TimeWindow) onDay(day time.Weekday) bool {
+	if len(w.Days) == 0 {
+		return true
+	}
+	for _, d := range w.Days {
+		if weekdays[strings.ToLower(d)] == day {
+			return true
+		}
+	}
+	return false
+}
+
+// parseClock parses HH:MM into minutes since midnight
+func parseClock(value string) (int, error) {
+	t, err := time.Parse("15:04", value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time %q, expected HH:MM", value)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+func loadLocation(name string) (*time.Location, error) {
+	if name == "" {
+		return time.Local, nil
+	}
+	return time.LoadLocation(name)
+}
+
+// validateSchedules checks profiles and schedules
+func (s Settings) validateSchedules(names map[string]bool, classes map[string]bool) error {
+	for name, profile := range s.Profiles {
+		if name == "" {
+			return fmt.Errorf("profile name must not be empty")
+		}
+		if profile.DefaultBackend != "" && !names[profile.DefaultBackend] {
+			return fmt.Errorf("profile %s: default backend %s does not exist", name, profile.DefaultBackend)
+		}
+		for task, target := range profile.TaskRouting {
+			if err := validateTarget(target, names); err != nil {
+				return fmt.Errorf("profile %s: task %s: %v", name, task, err)
+			}
+		}
+		for modelType, target := range profile.ModelTypeRouting {
+			if err := validateTarget(target, names); err != nil {
+				return fmt.Errorf("profile %s: modelType %s: %v", name, modelType, err)
+			}
+		}
+		if err := validateRules(profile.Rules, names, classes); err != nil {
+			return fmt.Errorf("profile %s: %v", name, err)
+		}
+	}
+
+	seen := make(map[string]bool, len(s.Schedules))
+	for _, schedule := range s.Schedules {
+		if schedule.Name == "" {
+			return fmt.Errorf("schedule name must not be empty")
+		}
+		if seen[schedule.Name] {
+			return fmt.Errorf("duplicate schedule name: %s", schedule.Name)
+		}
+		seen[schedule.Name] = true
+
+		if _, err := loadLocation(schedule.Timezone); err != nil {
+			return fmt.Errorf("schedule %s: unknown timezone %q", schedule.Name, schedule.Timezone)
+		}
+		if len(schedule.Windows) == 0 {
+			return fmt.Errorf("schedule %s: at least one window is required", schedule.Name)
+		}
+		for _, window := range schedule.Windows {
+			if _, err := parseClock(window.Start); err != nil {
+				return fmt.Errorf("schedule %s: %v", schedule.Name, err)
+			}
+			if _, err := parseClock(window.End); err != nil {
+				return fmt.Errorf("schedule %s: %v", schedule.Name, err)
+			}
+			for _, day := range window.Days {
+				if _, ok := weekdays[strings.ToLower(day)]; !ok {
+					return fmt.Errorf("schedule %s: invalid day %q, expected mon, tue, wed, thu, fri, sat or sun", schedule.Name, day)
+				}
+			}
+		}
+		if schedule.Profile != "" {
+			if _, ok := s.Profiles[schedule.Profile]; !ok {
+				return fmt.Errorf("schedule %s: unknown profile %s", schedule.Name, schedule.Profile)
+			}
+		}
+		for _, target := range append(append([]string{}, schedule.EnableBackends...), schedule.DisableBackends...) {
+			if err := validateTarget(target, names); err != nil {
+				return fmt.Errorf("schedule %s: %v", schedule.Name, err)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,97 @@
+package config
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func utc(value string) time.Time {
+	t, err := time.Parse("2006-01-02 15:04", value)
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+func TestTimeWindowContains(t *testing.T) {
+	overnight := TimeWindow{Days: []string{"fri"}, Start: "22:00", End: "06:00"}
+	daytime := TimeWindow{Days: []string{"Mon", "fri"}, Start: "09:00", End: "17:00"}
+	allDay := TimeWindow{Days: []string{"sat", "sun"}, Start: "00:00", End: "00:00"}
+
+	// 2026-10-16 is a Friday
+	tests := []struct {
+		name   string
+		window TimeWindow
+		at     string
+		want   bool
+	}{
+		{"daytime start", daytime, "2026-10-16 09:00", true},
+		{"daytime end is exclusive", daytime, "2026-10-16 17:00", false},
+		{"daytime wrong day", daytime, "2026-10-15 12:00", false},
+		{"overnight before start", overnight, "2026-10-16 21:59", false},
+		{"overnight evening", overnight, "2026-10-16 23:00", true},
+		{"overnight after midnight", overnight, "2026-10-17 05:59", true},
+		{"overnight end", overnight, "2026-10-17 06:00", false},
+		{"overnight evening of the next day", overnight, "2026-10-17 23:00", false},
+		{"overnight morning of the start day", overnight, "2026-10-16 02:00", false},
+		{"all day", allDay, "2026-10-18 12:00", true},
+		{"all day wrong day", allDay, "2026-10-16 12:00", false},
+		{"invalid time", TimeWindow{Start: "25:00", End: "06:00"}, "2026-10-16 23:00", false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := test.window.contains(utc(test.at)); got != test.want {
+				t.Errorf("contains(%s) = %v, want %v", test.at, got, test.want)
+			}
+		})
+	}
+}
+
+func TestEvaluateSchedules(t *testing.T) {
+	settings := Settings{
+		Backends: []Backend{
+			{Name: "gpu", Labels: map[string]string{"gpu": "true"}},
+			{Name: "cloud"},
+			{Name: "cpu"},
+		},
+		Schedules: []Schedule{
+			{Name: "night", Timezone: "America/New_York", Windows: []TimeWindow{{Start: "22:00", End: "06:00"}}, Profile: "night", EnableBackends: []string{"cloud"}},
+			{Name: "weekend", Timezone: "Europe/Berlin", Windows: []TimeWindow{{Days: []string{"sat", "sun"}, Start: "00:00", End: "00:00"}}, Profile: "weekend", DisableBackends: []string{"gpu=true"}},
+			{Name: "broken", Timezone: "Nowhere/Special", Windows: []TimeWindow{{Start: "00:00", End: "00:00"}}, DisableBackends: []string{"cpu"}},
+		},
+	}
+
+	tests := []struct {
+		name     string
+		at       string // UTC
+		active   []string
+		profile  string
+		disabled map[string]string
+	}{
+		// 12:00 UTC is 08:00 in New York and 14:00 in Berlin on a Friday
+		{"no schedule active", "2026-10-16 12:00", []string{}, "", map[string]string{"cloud": "night"}},
+		// 03:00 UTC is 23:00 on Thursday in New York
+		{"overnight in New York", "2026-10-16 03:00", []string{"night"}, "night", map[string]string{}},
+		// 22:30 UTC on Friday is already Saturday in Berlin but 18:30 in New York
+		{"weekend starts in Berlin first", "2026-10-16 22:30", []string{"weekend"}, "weekend", map[string]string{"gpu": "weekend", "cloud": "night"}},
+		// 03:00 UTC on Saturday is 23:00 on Friday in New York
+		{"first profile wins", "2026-10-17 03:00", []string{"night", "weekend"}, "night", map[string]string{"gpu": "weekend"}},
+		// 22:30 UTC on Sunday is already Monday in Berlin
+		{"weekend ends in Berlin first", "2026-10-18 22:30", []string{}, "", map[string]string{"cloud": "night"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			state := settings.evaluateSchedules(utc(test.at))
+			if fmt.Sprint(state.Active) != fmt.Sprint(test.active) {
+				t.Errorf("active %v, want %v", state.Active, test.active)
+			}
+			if state.Profile != test.profile {
+				t.Errorf("profile %q, want %q", state.Profile, test.profile)
+			}
+			if fmt.Sprint(state.Disabled) != fmt.Sprint(test.disabled) {
+				t.Errorf("disabled %v, want %v", state.Disabled, test.disabled)
+			}
+		})
+	}
+}
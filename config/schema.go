@@ -0,0 +1,137 @@
+package config
+
+import (
+	"reflect"
+	"strings"
+)
+
+// SchemaDialect is the JSON Schema draft the generated schema conforms to
+const SchemaDialect = "https://json-schema.org/draft/2020-12/schema"
+
+// durationPattern matches Go duration strings such as "30s" or "1h30m"
+const durationPattern = `^([0-9]+(\.[0-9]+)?(ns|us|µs|ms|s|m|h))+$`
+
+// JSONSchema returns a JSON Schema describing the current config file format.
+// It is generated from the Settings struct so it cannot drift from the code.
+// Fields tagged `schema:"required"` are required, `enum:"a,b"` restricts values.
+func JSONSchema() map[string]interface{} {
+	schema := schemaForType(reflect.TypeOf(Settings{}))
+	schema["$schema"] = SchemaDialect
+	schema["title"] = "Immich ML Proxy configuration"
+
+	properties := schema["properties"].(map[string]interface{})
+	properties["version"] = map[string]interface{}{
+		"type":    "integer",
+		"minimum": 1,
+		"maximum": CurrentVersion,
+	}
+
+	// GET /api/config reports these too; they are ignored when posted back
+	properties["activeProfile"] = map[string]interface{}{
+		"type":     "string",
+		"readOnly": true,
+	}
+	properties["activeSchedules"] = map[string]interface{}{
+		"type":     "array",
+		"items":    map[string]interface{}{"type": "string"},
+		"readOnly": true,
+	}
+	return schema
+}
+
+// schemaForType builds the schema for a Go type following encoding/json rules
+func schemaForType(t reflect.Type) map[string]interface{} {
+	if t == reflect.TypeOf(Duration(0)) {
+		// A duration string, or a number of seconds
+		return map[string]interface{}{
+			"oneOf": []interface{}{
+				map[string]interface{}{"type": "string", "pattern": durationPattern},
+				map[string]interface{}{"type": "number", "minimum": 0},
+			},
+		}
+	}
+
+	switch t.Kind() {
+	case reflect.Ptr:
+		return schemaForType(t.Elem())
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		return map[string]interface{}{
+			"type":  "array",
+			"items": schemaForType(t.Elem()),
+		}
+	case reflect.Map:
+		return map[string]interface{}{
+			"type":                 "object",
+			"additionalProperties": schemaForType(t.Elem()),
+		}
+	case reflect.Struct:
+		return schemaForStruct(t)
+	default:
+		return map[string]interface{}{}
+	}
+}
+
+func schemaForStruct(t reflect.Type) map[string]interface{} {
+	properties := make(map[string]interface{})
+	var required []string
+
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+
+		tag := field.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name := strings.Split(tag, ",")[0]
+
+		// Embedded structs without a name are flattened like encoding/json does
+		if field.Anonymous && name == "" {
+			embedded := schemaForType(field.Type)
+			if props, ok := embedded["properties"].(map[string]interface{}); ok {
+				for k, v := range props {
+					properties[k] = v
+				}
+			}
+			if req, ok := embedded["required"].([]string); ok {
+				required = append(required, req...)
+			}
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+
+		property := schemaForType(field.Type)
+		if enum := field.Tag.Get("enum"); enum != "" {
+			values := strings.Split(enum, ",")
+			property["enum"] = values
+		}
+		properties[name] = property
+
+		if field.Tag.Get("schema") == "required" {
+			required = append(required, name)
+		}
+	}
+
+	schema := map[string]interface{}{
+		"type":                 "object",
+		"properties":           properties,
+		"additionalProperties": false,
+	}
+	if len(required) > 0 {
+		schema["required"] = required
+	}
+	return schema
+}
@@ -0,0 +1,52 @@
+package config
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestSchemaCoversConfigDocument(t *testing.T) {
+	c := &Config{Settings: Settings{
+		DefaultBackend: "gpu",
+		Backends:       []Backend{{Name: "gpu", URL: "http://gpu:3003"}},
+	}}
+	c.schedule.Profile = "night"
+	c.schedule.Active = []string{"nightly"}
+	data, _, err := c.ToJSONWithETag()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var doc map[string]interface{}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		t.Fatal(err)
+	}
+
+	properties := JSONSchema()["properties"].(map[string]interface{})
+	for key := range doc {
+		if _, ok := properties[key]; !ok {
+			t.Errorf("GET /api/config returns %s, which the schema does not allow", key)
+		}
+	}
+}
+
+func TestSchemaDuration(t *testing.T) {
+	schema := schemaForType(reflect.TypeOf(Duration(0)))
+	variants, ok := schema["oneOf"].([]interface{})
+	if !ok || len(variants) != 2 {
+		t.Fatalf("duration schema %v, want a string or a number", schema)
+	}
+	types := map[interface{}]bool{}
+	for _, variant := range variants {
+		types[variant.(map[string]interface{})["type"]] = true
+	}
+	if !types["string"] || !types["number"] {
+		t.Errorf("duration schema %v, want a string or a number", schema)
+	}
+
+	// Plain numbers are read as seconds
+	var d Duration
+	if err := json.Unmarshal([]byte("90"), &d); err != nil || d.Std().Seconds() != 90 {
+		t.Errorf("90 read as %s, %v", d.Std(), err)
+	}
+}
@@ -0,0 +1,115 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Selector matches backends by label. It is written as a comma-separated list
+// of requirements that must all hold:
+//
+//	key=value   label key has the given value
+//	key!=value  label key is missing or has a different value
+//	key         label key is present (only inside a list, see IsSelector)
+//	!key        label key is missing
+type Selector []labelRequirement
+
+type labelRequirement struct {
+	key    string
+	value  string
+	negate bool
+	exists bool // only check presence of the key
+}
+
+// IsSelector reports whether a routing target is a label selector rather than
+// a backend name. Backend names cannot contain '=', ',' or a leading '!'.
+func IsSelector(target string) bool {
+	return strings.ContainsAny(target, "=,") || strings.HasPrefix(target, "!")
+}
+
+// ParseSelector parses a label selector such as "gpu=true,site=home"
+func ParseSelector(text string) (Selector, error) {
+	var selector Selector
+	for _, part := range strings.Split(text, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			return nil, fmt.Errorf("empty requirement in selector %q", text)
+		}
+
+		var req labelRequirement
+		switch {
+		case strings.Contains(part, "!="):
+			kv := strings.SplitN(part, "!=", 2)
+			req = labelRequirement{key: strings.TrimSpace(kv[0]), value: strings.TrimSpace(kv[1]), negate: true}
+		case strings.Contains(part, "="):
+			kv := strings.SplitN(part, "=", 2)
+			req = labelRequirement{key: strings.TrimSpace(kv[0]), value: strings.TrimSpace(kv[1])}
+		case strings.HasPrefix(part, "!"):
+			req = labelRequirement{key: strings.TrimSpace(part[1:]), exists: true, negate: true}
+		default:
+			req = labelRequirement{key: part, exists: true}
+		}
+
+		if req.key == "" {
+			return nil, fmt.Errorf("missing label key in selector %q", text)
+		}
+		selector = append(selector, req)
+	}
+	return selector, nil
+}
+
+// Matches reports whether labels satisfy every requirement of the selector
+func (s Selector) Matches(labels map[string]string) bool {
+	for _, req := range s {
+		value, ok := labels[req.key]
+		var matched bool
+		if req.exists {
+			matched = ok
+		} else {
+			matched = ok && value == req.value
+		}
+		if matched == req.negate {
+			return false
+		}
+	}
+	return true
+}
+
+// resolveTarget returns the backends a routing target refers to: the backend
+// with that name, or every backend matching the label selector. Backends
+// that are draining, disabled or disabled by a schedule are left out.
+// Callers must hold mu.
+func (c *Config) resolveTarget(target string) []Backend {
+	if !IsSelector(target) {
+		for _, backend := range c.Backends {
+			if backend.Name == target && c.routable(backend) {
+				return []Backend{backend}
+			}
+		}
+		return []Backend{}
+	}
+
+	selector, err := ParseSelector(target)
+	if err != nil {
+		return []Backend{}
+	}
+	result := []Backend{}
+	for _, backend := range c.Backends {
+		if selector.Matches(backend.Labels) && c.routable(backend) {
+			result = append(result, backend)
+		}
+	}
+	return result
+}
+
+// routable reports whether new requests may be sent to the backend. Callers must hold mu.
+func (c *Config) routable(backend Backend) bool {
+	return backend.Routable() && c.schedule.Disabled[backend.Name] == ""
+}
+
+// ResolveTarget returns the backends a routing target (backend name or label selector) refers to
+func (c *Config) ResolveTarget(target string) []Backend {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.resolveTarget(target)
+}
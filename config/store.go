@@ -0,0 +1,152 @@
+package config
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrNotFound is returned by ConfigStore.Load when nothing has been stored yet
+	ErrNotFound = errors.New("configuration not found")
+	// ErrReadOnly is returned when writing to a store that cannot be written
+	ErrReadOnly = errors.New("configuration store is read-only")
+)
+
+// ConfigStore persists the settings document
+type ConfigStore interface {
+	// Load returns the stored document as a generic map, before migration.
+	// It returns ErrNotFound if nothing has been stored yet.
+	Load() (map[string]interface{}, error)
+	// Save stores the settings in the current schema version
+	Save(settings Settings) error
+	// String describes the store for logs
+	String() string
+}
+
+// TransactionalStore is a ConfigStore that can apply read-modify-write
+// updates atomically against the latest stored settings
+type TransactionalStore interface {
+	ConfigStore
+	Update(fn func(settings *Settings) error) (Settings, error)
+}
+
+// Revision is one stored version of the settings
+type Revision struct {
+	ID        int64     `json:"id"`
+	CreatedAt time.Time `json:"createdAt"`
+	Settings  Settings  `json:"settings"`
+}
+
+// HistoryStore is a ConfigStore that keeps previous revisions
+type HistoryStore interface {
+	ConfigStore
+	// History returns up to limit revisions, newest first
+	History(limit int) ([]Revision, error)
+}
+
+// WatchableStore is a ConfigStore that can report changes made by others,
+// e.g. another proxy instance sharing the same store
+type WatchableStore interface {
+	ConfigStore
+	// Watch calls onChange with the new document whenever the stored
+	// configuration changes, until ctx is done
+	Watch(ctx context.Context, onChange func(doc map[string]interface{}))
+}
+
+// OpenStore opens a ConfigStore from a location:
+//   - http:// or https:// URLs open a read-only RemoteStore
+//   - sqlite:// URLs and paths ending in .db, .sqlite or .sqlite3 open a SQLiteStore
+//   - anything else is a config file path (an empty location picks the default file)
+//
+// pollInterval is how often shared stores are polled for changes and must be
+// positive.
+func OpenStore(location string, pollInterval time.Duration) (ConfigStore, error) {
+	if pollInterval <= 0 {
+		return nil, fmt.Errorf("poll interval must be positive, got %s", pollInterval)
+	}
+	lower := strings.ToLower(location)
+
+	switch {
+	case strings.HasPrefix(lower, "http://") || strings.HasPrefix(lower, "https://"):
+		return NewRemoteStore(location, pollInterval), nil
+	case strings.HasPrefix(lower, "sqlite://"):
+		return NewSQLiteStore(location[len("sqlite://"):], pollInterval)
+	case strings.HasSuffix(lower, ".db") || strings.HasSuffix(lower, ".sqlite") || strings.HasSuffix(lower, ".sqlite3"):
+		return NewSQLiteStore(location, pollInterval)
+	default:
+		return NewFileStore(location), nil
+	}
+}
+
+// configFileCandidates are tried in order when no config file was set explicitly
+var configFileCandidates = []string{"config.json", "config.yaml", "config.yml", "config.toml"}
+
+// FileStore stores the settings in a local JSON, YAML or TOML file.
+// The format is derived from the file extension.
+type FileStore struct {
+	path string
+}
+
+// NewFileStore creates a file store. If path is empty, the first existing file
+// of config.json, config.yaml, config.yml and config.toml is used.
+func NewFileStore(path string) *FileStore {
+	if path == "" {
+		path = configFileCandidates[0]
+		for _, candidate := range configFileCandidates {
+			if _, err := os.Stat(candidate); err == nil {
+				path = candidate
+				break
+			}
+		}
+	}
+	return &FileStore{path: path}
+}
+
+// Path returns the path of the config file
+func (s *FileStore) Path() string {
+	return s.path
+}
+
+func (s *FileStore) String() string {
+	return "file " + s.path
+}
+
+func (s *FileStore) Load() (map[string]interface{}, error) {
+	data, err := os.ReadFile(s.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	return DecodeDocument(data, FormatFromPath(s.path))
+}
+
+func (s *FileStore) Save(settings Settings) error {
+	settings.Version = CurrentVersion
+	data, err := EncodeSettings(settings, FormatFromPath(s.path))
+	if err != nil {
+		return err
+	}
+
+	// Write to a temporary file first so a crash never leaves a truncated config
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.path)
+}
+
+// Backup copies the current file next to it before it is overwritten by a migration
+func (s *FileStore) Backup(fromVersion int) (string, error) {
+	data, err := os.ReadFile(s.path)
+	if err != nil {
+		return "", err
+	}
+	backup := fmt.Sprintf("%s.v%d.bak", s.path, fromVersion)
+	return backup, os.WriteFile(backup, data, 0644)
+}
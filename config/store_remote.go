@@ -0,0 +1,135 @@
+package config
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// RemoteStore pulls the configuration from an HTTP(S) URL. It is read-only;
+// changes are picked up by polling with If-None-Match.
+type RemoteStore struct {
+	url          string
+	pollInterval time.Duration
+	client       *http.Client
+	mu           sync.Mutex
+	etag         string
+}
+
+// NewRemoteStore creates a store that reads the configuration from rawURL
+func NewRemoteStore(rawURL string, pollInterval time.Duration) *RemoteStore {
+	return &RemoteStore{
+		url:          rawURL,
+		pollInterval: pollInterval,
+		client: &http.Client{
+			Timeout: 10 * time.Second,
+		},
+	}
+}
+
+func (s *RemoteStore) String() string {
+	return "remote " + s.url
+}
+
+func (s *RemoteStore) Load() (map[string]interface{}, error) {
+	doc, _, err := s.fetch(false)
+	return doc, err
+}
+
+func (s *RemoteStore) Save(settings Settings) error {
+	return ErrReadOnly
+}
+
+// Watch polls the URL and reports the document whenever its ETag changes
+func (s *RemoteStore) Watch(ctx context.Context, onChange func(doc map[string]interface{})) {
+	ticker := time.NewTicker(s.pollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		doc, changed, err := s.fetch(true)
+		if err != nil {
+			log.Printf("Failed to poll config from %s: %v", s, err)
+			continue
+		}
+		if changed {
+			onChange(doc)
+		}
+	}
+}
+
+// fetch downloads the document. With conditional set, the last ETag is sent
+// and changed is false when the server answers 304 Not Modified.
+func (s *RemoteStore) fetch(conditional bool) (map[string]interface{}, bool, error) {
+	req, err := http.NewRequest("GET", s.url, nil)
+	if err != nil {
+		return nil, false, err
+	}
+	req.Header.Set("Accept", "application/json, application/yaml, application/toml")
+
+	s.mu.Lock()
+	if conditional && s.etag != "" {
+		req.Header.Set("If-None-Match", s.etag)
+	}
+	s.mu.Unlock()
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, false, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotModified:
+		return nil, false, nil
+	case http.StatusNotFound:
+		return nil, false, ErrNotFound
+	default:
+		return nil, false, fmt.Errorf("unexpected response: %s", resp.Status)
+	}
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, false, err
+	}
+	doc, err := DecodeDocument(data, remoteFormat(resp.Header.Get("Content-Type"), s.url))
+	if err != nil {
+		return nil, false, err
+	}
+
+	s.mu.Lock()
+	s.etag = resp.Header.Get("ETag")
+	s.mu.Unlock()
+
+	return doc, true, nil
+}
+
+// remoteFormat picks the format from the Content-Type, falling back to the URL extension
+func remoteFormat(contentType string, rawURL string) Format {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	switch mediaType {
+	case "application/yaml", "application/x-yaml", "text/yaml", "text/x-yaml":
+		return FormatYAML
+	case "application/toml", "text/toml":
+		return FormatTOML
+	case "application/json":
+		return FormatJSON
+	default:
+		if u, err := url.Parse(rawURL); err == nil {
+			return FormatFromPath(u.Path)
+		}
+		return FormatJSON
+	}
+}
@@ -0,0 +1,252 @@
+package config
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+// maxRevisions is the number of revisions kept in the history table
+const maxRevisions = 200
+
+// SQLiteStore stores every saved configuration as a revision in an embedded
+// SQLite database. Several proxy instances can share one database file.
+type SQLiteStore struct {
+	db           *sql.DB
+	path         string
+	pollInterval time.Duration
+	mu           sync.Mutex
+	lastID       int64 // newest revision known to this instance
+}
+
+// NewSQLiteStore opens (and creates if needed) the database at path
+func NewSQLiteStore(path string, pollInterval time.Duration) (*SQLiteStore, error) {
+	dsn := fmt.Sprintf("file:%s?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)", path)
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = db.Exec(`CREATE TABLE IF NOT EXISTS config_revisions (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		created_at INTEGER NOT NULL,
+		document TEXT NOT NULL
+	)`)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &SQLiteStore{db: db, path: path, pollInterval: pollInterval}, nil
+}
+
+func (s *SQLiteStore) String() string {
+	return "sqlite " + s.path
+}
+
+// Close closes the database
+func (s *SQLiteStore) Close() error {
+	return s.db.Close()
+}
+
+func (s *SQLiteStore) Load() (map[string]interface{}, error) {
+	_, document, err := s.latest(s.db.QueryRow)
+	if err != nil {
+		return nil, err
+	}
+	return DecodeDocument([]byte(document), FormatJSON)
+}
+
+func (s *SQLiteStore) Save(settings Settings) error {
+	ctx := context.Background()
+	conn, err := s.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	return s.insert(ctx, conn, settings)
+}
+
+// Update applies fn to the latest stored settings inside an immediate
+// transaction, so concurrent writers from other instances are serialized
+func (s *SQLiteStore) Update(fn func(settings *Settings) error) (Settings, error) {
+	ctx := context.Background()
+	conn, err := s.db.Conn(ctx)
+	if err != nil {
+		return Settings{}, err
+	}
+	defer conn.Close()
+
+	// BEGIN IMMEDIATE takes the write lock up front instead of on the first write
+	if _, err := conn.ExecContext(ctx, "BEGIN IMMEDIATE"); err != nil {
+		return Settings{}, err
+	}
+	committed := false
+	defer func() {
+		if !committed {
+			conn.ExecContext(ctx, "ROLLBACK")
+		}
+	}()
+
+	queryRow := func(query string, args ...interface{}) *sql.Row {
+		return conn.QueryRowContext(ctx, query, args...)
+	}
+
+	var settings Settings
+	_, document, err := s.latest(queryRow)
+	switch {
+	case err == ErrNotFound:
+		settings = Settings{
+			Backends:         []Backend{},
+			TaskRouting:      make(map[string]string),
+			ModelTypeRouting: make(map[string]string),
+		}
+	case err != nil:
+		return Settings{}, err
+	default:
+		doc, err := DecodeDocument([]byte(document), FormatJSON)
+		if err != nil {
+			return Settings{}, err
+		}
+		if _, err := Migrate(doc); err != nil {
+			return Settings{}, err
+		}
+		if settings, err = settingsFromDocument(doc); err != nil {
+			return Settings{}, err
+		}
+	}
+
+	if err := fn(&settings); err != nil {
+		return Settings{}, err
+	}
+	if err := s.insert(ctx, conn, settings); err != nil {
+		return Settings{}, err
+	}
+
+	if _, err := conn.ExecContext(ctx, "COMMIT"); err != nil {
+		return Settings{}, err
+	}
+	committed = true
+	settings.Version = CurrentVersion
+	return settings, nil
+}
+
+func (s *SQLiteStore) History(limit int) ([]Revision, error) {
+	rows, err := s.db.Query(`SELECT id, created_at, document FROM config_revisions ORDER BY id DESC LIMIT ?`, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var revisions []Revision
+	for rows.Next() {
+		var revision Revision
+		var createdAt int64
+		var document string
+		if err := rows.Scan(&revision.ID, &createdAt, &document); err != nil {
+			return nil, err
+		}
+		revision.CreatedAt = time.Unix(createdAt, 0)
+
+		doc, err := DecodeDocument([]byte(document), FormatJSON)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := Migrate(doc); err != nil {
+			return nil, err
+		}
+		if revision.Settings, err = settingsFromDocument(doc); err != nil {
+			return nil, err
+		}
+		revisions = append(revisions, revision)
+	}
+	return revisions, rows.Err()
+}
+
+// Watch polls the latest revision id and reports new revisions
+func (s *SQLiteStore) Watch(ctx context.Context, onChange func(doc map[string]interface{})) {
+	if id, _, err := s.latest(s.db.QueryRow); err == nil {
+		s.seen(id)
+	} else if err != ErrNotFound {
+		log.Printf("Failed to read config revision from %s: %v", s, err)
+	}
+
+	ticker := time.NewTicker(s.pollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		id, document, err := s.latest(s.db.QueryRow)
+		if err != nil {
+			if err != ErrNotFound {
+				log.Printf("Failed to read config revision from %s: %v", s, err)
+			}
+			continue
+		}
+		if !s.seen(id) {
+			continue
+		}
+
+		doc, err := DecodeDocument([]byte(document), FormatJSON)
+		if err != nil {
+			log.Printf("Failed to parse config revision %d from %s: %v", id, s, err)
+			continue
+		}
+		onChange(doc)
+	}
+}
+
+// seen records id as known and reports whether it is newer than any revision seen before
+func (s *SQLiteStore) seen(id int64) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if id <= s.lastID {
+		return false
+	}
+	s.lastID = id
+	return true
+}
+
+// latest returns the newest revision using the given query function
+func (s *SQLiteStore) latest(queryRow func(query string, args ...interface{}) *sql.Row) (int64, string, error) {
+	var id int64
+	var document string
+	err := queryRow(`SELECT id, document FROM config_revisions ORDER BY id DESC LIMIT 1`).Scan(&id, &document)
+	if err == sql.ErrNoRows {
+		return 0, "", ErrNotFound
+	}
+	return id, document, err
+}
+
+// insert stores settings as a new revision and prunes old ones
+func (s *SQLiteStore) insert(ctx context.Context, conn *sql.Conn, settings Settings) error {
+	settings.Version = CurrentVersion
+	document, err := json.Marshal(settings)
+	if err != nil {
+		return err
+	}
+
+	result, err := conn.ExecContext(ctx, `INSERT INTO config_revisions (created_at, document) VALUES (?, ?)`,
+		time.Now().Unix(), string(document))
+	if err != nil {
+		return err
+	}
+	// Our own writes are not reported by Watch
+	if id, err := result.LastInsertId(); err == nil {
+		s.seen(id)
+	}
+	_, err = conn.ExecContext(ctx, `DELETE FROM config_revisions WHERE id <= (SELECT MAX(id) FROM config_revisions) - ?`, maxRevisions)
+	return err
+}
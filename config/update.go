@@ -0,0 +1,448 @@
+package config
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+)
+
+var (
+	// ErrPreconditionFailed is returned when the If-Match ETag does not match the current config
+	ErrPreconditionFailed = errors.New("configuration was modified, reload and try again")
+	// ErrPreconditionRequired is returned when a write that must be conditional has no If-Match
+	ErrPreconditionRequired = errors.New("If-Match header required, send the ETag of the configuration you changed")
+	// ErrBackendNotFound is returned when a backend does not exist
+	ErrBackendNotFound = errors.New("backend not found")
+	// ErrRouteNotFound is returned when a routing entry does not exist
+	ErrRouteNotFound = errors.New("route not found")
+	// ErrExecHookForbidden is returned when an API write adds or changes a
+	// lifecycle command hook while command hooks are not allowed through the API
+	ErrExecHookForbidden = errors.New("lifecycle command hooks can only be set in the config file unless the proxy runs with --allow-exec-hooks")
+)
+
+// ValidationError is returned when an update would leave the config invalid
+type ValidationError struct {
+	Message string
+}
+
+func (e *ValidationError) Error() string {
+	return e.Message
+}
+
+func invalid(format string, args ...interface{}) error {
+	return &ValidationError{Message: fmt.Sprintf(format, args...)}
+}
+
+// ETag returns the entity tag of the current settings
+func (c *Config) ETag() string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.Settings.etag()
+}
+
+// etag hashes the canonical JSON encoding of the settings
+func (s Settings) etag() string {
+	s.Version = CurrentVersion
+	data, err := json.Marshal(s)
+	if err != nil {
+		return ""
+	}
+	sum := sha256.Sum256(data)
+	return `"` + hex.EncodeToString(sum[:8]) + `"`
+}
+
+// etagMatches implements If-Match semantics: empty means no precondition and
+// matches any, as does "*", and a list of ETags matches if one of them is
+// equal. If-Match uses strong comparison, so weak ETags (W/"...") never match.
+func etagMatches(ifMatch string, etag string) bool {
+	if ifMatch == "" || ifMatch == "*" {
+		return true
+	}
+	for _, candidate := range strings.Split(ifMatch, ",") {
+		if strings.TrimSpace(candidate) == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// allowExecHooks permits API writes to add or change lifecycle command hooks
+var allowExecHooks bool
+
+// AllowExecHooks sets whether API writes may add or change lifecycle command
+// hooks. Command hooks run programs on the proxy host, so by default they can
+// only be set in the config file loaded at startup; HTTP hooks are always allowed.
+func AllowExecHooks(allow bool) {
+	allowExecHooks = allow
+}
+
+// UpdateIfMatch applies fn like Update, but only if ifMatch matches the ETag of
+// the settings fn is applied to. The result is validated before it is saved.
+// It returns the ETag of the new settings. This is the write path of the API,
+// so it rejects new or changed command hooks unless AllowExecHooks is set.
+func (c *Config) UpdateIfMatch(ifMatch string, fn func(settings *Settings) error) (string, error) {
+	var etag string
+	err := c.Update(func(settings *Settings) error {
+		if !etagMatches(ifMatch, settings.etag()) {
+			return ErrPreconditionFailed
+		}
+		before := settings.clone()
+		if settings.TaskRouting == nil {
+			settings.TaskRouting = make(map[string]string)
+		}
+		if settings.ModelTypeRouting == nil {
+			settings.ModelTypeRouting = make(map[string]string)
+		}
+		if err := fn(settings); err != nil {
+			return err
+		}
+		if err := settings.Validate(); err != nil {
+			return err
+		}
+		if !allowExecHooks {
+			if err := checkExecHooks(before, *settings); err != nil {
+				return err
+			}
+		}
+		etag = settings.etag()
+		return nil
+	})
+	return etag, err
+}
+
+// checkExecHooks returns ErrExecHookForbidden if after has a command hook
+// that before does not have in the same place. Writing back unchanged command
+// hooks, e.g. when the web UI saves the whole configuration, is allowed.
+func checkExecHooks(before, after Settings) error {
+	previous := make(map[string]*Lifecycle)
+	for _, backend := range before.Backends {
+		previous[backend.Name] = backend.Lifecycle
+	}
+	for _, backend := range after.Backends {
+		if backend.Lifecycle == nil {
+			continue
+		}
+		var old Lifecycle
+		if lc := previous[backend.Name]; lc != nil {
+			old = *lc
+		}
+		if !sameCommand(old.Start, backend.Lifecycle.Start) || !sameCommand(old.Stop, backend.Lifecycle.Stop) {
+			return fmt.Errorf("backend %s: %w", backend.Name, ErrExecHookForbidden)
+		}
+	}
+	return nil
+}
+
+// sameCommand reports whether hook runs no command or the same command as before
+func sameCommand(before, hook *Hook) bool {
+	if hook == nil || len(hook.Command) == 0 {
+		return true
+	}
+	if before == nil || len(before.Command) != len(hook.Command) {
+		return false
+	}
+	for i := range hook.Command {
+		if before.Command[i] != hook.Command[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// Replace replaces the whole settings document
+func (c *Config) Replace(ifMatch string, replacement Settings) (string, error) {
+	return c.UpdateIfMatch(ifMatch, func(settings *Settings) error {
+		*settings = replacement.clone()
+		return nil
+	})
+}
+
+// Patch applies a JSON merge patch (RFC 7396) to the settings document
+func (c *Config) Patch(ifMatch string, patch map[string]interface{}) (string, error) {
+	return c.UpdateIfMatch(ifMatch, func(settings *Settings) error {
+		data, err := json.Marshal(settings)
+		if err != nil {
+			return err
+		}
+		var doc map[string]interface{}
+		if err := json.Unmarshal(data, &doc); err != nil {
+			return err
+		}
+
+		merged, ok := mergePatch(doc, patch).(map[string]interface{})
+		if !ok {
+			return invalid("patch must be a JSON object")
+		}
+		merged["version"] = CurrentVersion
+
+		patched, err := settingsFromDocument(merged)
+		if err != nil {
+			return invalid("invalid patch: %v", err)
+		}
+		*settings = patched
+		return nil
+	})
+}
+
+// mergePatch applies an RFC 7396 merge patch to target
+func mergePatch(target interface{}, patch interface{}) interface{} {
+	patchObject, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+
+	targetObject, ok := target.(map[string]interface{})
+	if !ok {
+		targetObject = make(map[string]interface{})
+	}
+	for key, value := range patchObject {
+		if value == nil {
+			delete(targetObject, key)
+			continue
+		}
+		targetObject[key] = mergePatch(targetObject[key], value)
+	}
+	return targetObject
+}
+
+// Validate checks that backend names are unique and that all routing
+// references existing backends
+func (s Settings) Validate() error {
+	names := make(map[string]bool, len(s.Backends))
+	for _, backend := range s.Backends {
+		if backend.Name == "" {
+			return invalid("backend name must not be empty")
+		}
+		if IsSelector(backend.Name) {
+			return invalid("backend name %q must not contain '=', ',' or start with '!'", backend.Name)
+		}
+		if names[backend.Name] {
+			return invalid("duplicate backend name: %s", backend.Name)
+		}
+		names[backend.Name] = true
+
+		u, err := url.Parse(backend.URL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return invalid("backend %s: invalid URL: %q", backend.Name, backend.URL)
+		}
+		if err := backend.validateTransport(); err != nil {
+			return invalid("backend %s: %v", backend.Name, err)
+		}
+		if backend.MaxConcurrency < 0 || backend.MaxQueue < 0 || backend.QueueTimeout < 0 {
+			return invalid("backend %s: maxConcurrency, maxQueue and queueTimeout must not be negative", backend.Name)
+		}
+		if backend.SlowStart < 0 {
+			return invalid("backend %s: slowStart must not be negative", backend.Name)
+		}
+		if err := backend.AdaptiveConcurrency.validate(); err != nil {
+			return invalid("backend %s: adaptiveConcurrency: %v", backend.Name, err)
+		}
+		if err := validateAdminState(backend.AdminState); err != nil {
+			return invalid("backend %s: %v", backend.Name, err)
+		}
+		if err := backend.WakeOnLAN.validate(); err != nil {
+			return invalid("backend %s: wakeOnLan: %v", backend.Name, err)
+		}
+		if err := backend.Lifecycle.validate(); err != nil {
+			return invalid("backend %s: lifecycle: %v", backend.Name, err)
+		}
+		for key := range backend.Labels {
+			if key == "" || strings.ContainsAny(key, "=,!") {
+				return invalid("backend %s: invalid label key %q", backend.Name, key)
+			}
+		}
+	}
+
+	if s.DefaultBackend != "" && !names[s.DefaultBackend] {
+		return invalid("default backend %s does not exist", s.DefaultBackend)
+	}
+	for task, target := range s.TaskRouting {
+		if err := validateTarget(target, names); err != nil {
+			return invalid("task %s: %v", task, err)
+		}
+	}
+	for modelType, target := range s.ModelTypeRouting {
+		if err := validateTarget(target, names); err != nil {
+			return invalid("modelType %s: %v", modelType, err)
+		}
+	}
+	classes, err := validatePriorityClasses(s.PriorityClasses)
+	if err != nil {
+		return invalid("%v", err)
+	}
+	if err := validateRules(s.Rules, names, classes); err != nil {
+		return invalid("%v", err)
+	}
+	if err := s.validateSchedules(names, classes); err != nil {
+		return invalid("%v", err)
+	}
+	if err := s.OutlierDetection.validate(); err != nil {
+		return invalid("outlierDetection: %v", err)
+	}
+	if err := validateDeepChecks(s.DeepChecks); err != nil {
+		return invalid("%v", err)
+	}
+	if err := s.Readiness.validate(); err != nil {
+		return invalid("readiness: %v", err)
+	}
+	if err := s.KeepWarm.validate(s.Schedules); err != nil {
+		return invalid("keepWarm: %v", err)
+	}
+	if err := s.Discovery.validate(); err != nil {
+		return invalid("discovery: %v", err)
+	}
+	if err := s.Notifications.validate(); err != nil {
+		return invalid("notifications: %v", err)
+	}
+	for _, backend := range s.Backends {
+		if backend.WakeOnLAN != nil && backend.WakeOnLAN.Fallback != "" {
+			if err := validateTarget(backend.WakeOnLAN.Fallback, names); err != nil {
+				return invalid("backend %s: wakeOnLan fallback: %v", backend.Name, err)
+			}
+		}
+		if backend.Lifecycle != nil && backend.Lifecycle.Fallback != "" {
+			if err := validateTarget(backend.Lifecycle.Fallback, names); err != nil {
+				return invalid("backend %s: lifecycle fallback: %v", backend.Name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// validateTarget checks a routing target. Selectors only need to parse, since
+// they may match backends that are labelled later.
+func validateTarget(target string, names map[string]bool) error {
+	if IsSelector(target) {
+		_, err := ParseSelector(target)
+		return err
+	}
+	if !names[target] {
+		return fmt.Errorf("routes to unknown backend %s", target)
+	}
+	return nil
+}
+
+// validateTransport checks the transport profile of a backend
+func (b Backend) validateTransport() error {
+	if b.Auth != nil {
+		switch b.Auth.Type {
+		case "bearer":
+			if b.Auth.Token == "" {
+				return fmt.Errorf("bearer auth requires a token")
+			}
+		case "basic":
+			if b.Auth.Username == "" {
+				return fmt.Errorf("basic auth requires a username")
+			}
+		default:
+			return fmt.Errorf("unknown auth type: %q", b.Auth.Type)
+		}
+	}
+
+	if b.TLS != nil && (b.TLS.CertFile == "") != (b.TLS.KeyFile == "") {
+		return fmt.Errorf("tls certFile and keyFile must be set together")
+	}
+
+	if b.Proxy != "" {
+		u, err := url.Parse(b.Proxy)
+		if err != nil || u.Host == "" {
+			return fmt.Errorf("invalid proxy URL: %q", b.Proxy)
+		}
+		switch u.Scheme {
+		case "http", "https", "socks5", "socks5h":
+		default:
+			return fmt.Errorf("unsupported proxy scheme: %q", u.Scheme)
+		}
+	}
+
+	if b.Timeout < 0 {
+		return fmt.Errorf("timeout must not be negative")
+	}
+	return nil
+}
+
+// validate checks the adaptive concurrency settings, if any
+func (a *AdaptiveConcurrency) validate() error {
+	if a == nil {
+		return nil
+	}
+	switch a.Algorithm {
+	case "", "aimd", "gradient":
+	default:
+		return fmt.Errorf("unknown algorithm: %q", a.Algorithm)
+	}
+	if a.MinLimit < 0 || a.MaxLimit < 0 || a.LatencyThreshold < 0 {
+		return fmt.Errorf("limits and latencyThreshold must not be negative")
+	}
+	if a.MaxLimit > 0 && a.MinLimit > a.MaxLimit {
+		return fmt.Errorf("minLimit is larger than maxLimit")
+	}
+	return nil
+}
+
+// validate checks the wake-on-LAN settings, if any
+func (w *WakeOnLAN) validate() error {
+	if w == nil {
+		return nil
+	}
+	if _, err := net.ParseMAC(w.MAC); err != nil {
+		return fmt.Errorf("invalid MAC address: %q", w.MAC)
+	}
+	if _, port, err := net.SplitHostPort(w.BroadcastAddress()); err != nil || port == "" {
+		return fmt.Errorf("invalid broadcast address: %q", w.Broadcast)
+	}
+	if w.WakeTimeout < 0 {
+		return fmt.Errorf("wakeTimeout must not be negative")
+	}
+	return nil
+}
+
+// validate checks the lifecycle settings, if any
+func (l *Lifecycle) validate() error {
+	if l == nil {
+		return nil
+	}
+	if l.Start == nil {
+		return fmt.Errorf("start hook is required")
+	}
+	if err := l.Start.validate(); err != nil {
+		return fmt.Errorf("start: %v", err)
+	}
+	if l.Stop != nil {
+		if err := l.Stop.validate(); err != nil {
+			return fmt.Errorf("stop: %v", err)
+		}
+	}
+	if l.IdleTimeout < 0 || l.StartTimeout < 0 {
+		return fmt.Errorf("idleTimeout and startTimeout must not be negative")
+	}
+	if l.IdleTimeout > 0 && l.Stop == nil {
+		return fmt.Errorf("idleTimeout requires a stop hook")
+	}
+	return nil
+}
+
+// validate checks that the hook is either a command or an HTTP call
+func (h *Hook) validate() error {
+	if (len(h.Command) > 0) == (h.URL != "") {
+		return fmt.Errorf("exactly one of command and url must be set")
+	}
+	if len(h.Command) > 0 && h.Command[0] == "" {
+		return fmt.Errorf("command must start with a program")
+	}
+	if h.URL != "" {
+		u, err := url.Parse(h.URL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("invalid URL: %q", h.URL)
+		}
+	}
+	if h.Timeout < 0 {
+		return fmt.Errorf("timeout must not be negative")
+	}
+	return nil
+}
@@ -0,0 +1,159 @@
+package config
+
+import (
+	"errors"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// testConfig returns a configuration saved to a file in a temporary directory
+func testConfig(t *testing.T, settings Settings) *Config {
+	t.Helper()
+	return &Config{
+		Settings:   settings,
+		Health:     make(map[string]BackendHealth),
+		healthy:    make(map[string]time.Time),
+		taskHealth: make(map[string]map[string]TaskHealth),
+		history:    make(map[string]*HealthHistory),
+		store:      NewFileStore(filepath.Join(t.TempDir(), "config.json")),
+	}
+}
+
+func TestEtagMatches(t *testing.T) {
+	etag := `"abc"`
+	tests := []struct {
+		ifMatch string
+		want    bool
+	}{
+		{"", true},
+		{"*", true},
+		{`"abc"`, true},
+		{`"xyz", "abc"`, true},
+		{`"xyz"`, false},
+		{`W/"abc"`, false},
+		{`abc`, false},
+	}
+	for _, test := range tests {
+		if got := etagMatches(test.ifMatch, etag); got != test.want {
+			t.Errorf("If-Match %s: match %v, want %v", test.ifMatch, got, test.want)
+		}
+	}
+}
+
+func TestMergePatch(t *testing.T) {
+	tests := []struct {
+		name   string
+		target interface{}
+		patch  interface{}
+		want   interface{}
+	}{
+		{
+			"merge nested",
+			map[string]interface{}{"a": map[string]interface{}{"b": "1", "c": "2"}},
+			map[string]interface{}{"a": map[string]interface{}{"c": "3"}},
+			map[string]interface{}{"a": map[string]interface{}{"b": "1", "c": "3"}},
+		},
+		{
+			"null removes",
+			map[string]interface{}{"a": "1", "b": "2"},
+			map[string]interface{}{"a": nil},
+			map[string]interface{}{"b": "2"},
+		},
+		{
+			"arrays are replaced",
+			map[string]interface{}{"a": []interface{}{"1", "2"}},
+			map[string]interface{}{"a": []interface{}{"3"}},
+			map[string]interface{}{"a": []interface{}{"3"}},
+		},
+		{
+			"object replaces a value",
+			map[string]interface{}{"a": "1"},
+			map[string]interface{}{"a": map[string]interface{}{"b": "2"}},
+			map[string]interface{}{"a": map[string]interface{}{"b": "2"}},
+		},
+		{
+			"non-object patch replaces the target",
+			map[string]interface{}{"a": "1"},
+			"x",
+			"x",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := mergePatch(test.target, test.patch); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("got %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestUpdateIfMatch(t *testing.T) {
+	c := testConfig(t, Settings{
+		DefaultBackend:   "gpu",
+		Backends:         []Backend{{Name: "gpu", URL: "http://gpu:3003"}},
+		TaskRouting:      map[string]string{},
+		ModelTypeRouting: map[string]string{},
+	})
+	etag := c.ETag()
+	addCPU := func(settings *Settings) error {
+		settings.Backends = append(settings.Backends, Backend{Name: "cpu", URL: "http://cpu:3003"})
+		return nil
+	}
+
+	if _, err := c.UpdateIfMatch(`"stale"`, addCPU); !errors.Is(err, ErrPreconditionFailed) {
+		t.Fatalf("stale ETag: %v, want ErrPreconditionFailed", err)
+	}
+	if _, err := c.UpdateIfMatch("W/"+etag, addCPU); !errors.Is(err, ErrPreconditionFailed) {
+		t.Fatalf("weak ETag: %v, want ErrPreconditionFailed", err)
+	}
+	if len(c.GetBackends()) != 1 {
+		t.Fatal("settings changed by a failed precondition")
+	}
+
+	newETag, err := c.UpdateIfMatch(etag, addCPU)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if newETag == etag || newETag != c.ETag() {
+		t.Errorf("ETag %s after the update, want the new ETag %s", newETag, c.ETag())
+	}
+
+	// The old ETag no longer matches, so a second writer has to reload
+	if _, err := c.UpdateIfMatch(etag, addCPU); !errors.Is(err, ErrPreconditionFailed) {
+		t.Errorf("old ETag: %v, want ErrPreconditionFailed", err)
+	}
+
+	// Invalid results are rejected and not saved
+	_, err = c.UpdateIfMatch("*", func(settings *Settings) error {
+		settings.DefaultBackend = "missing"
+		return nil
+	})
+	var validationErr *ValidationError
+	if !errors.As(err, &validationErr) {
+		t.Errorf("invalid update: %v, want a ValidationError", err)
+	}
+	if c.Settings.DefaultBackend == "missing" {
+		t.Error("invalid settings were saved")
+	}
+}
+
+func TestPatch(t *testing.T) {
+	c := testConfig(t, Settings{
+		DefaultBackend:   "gpu",
+		Backends:         []Backend{{Name: "gpu", URL: "http://gpu:3003"}, {Name: "cpu", URL: "http://cpu:3003"}},
+		TaskRouting:      map[string]string{"ocr": "cpu", "clip": "gpu"},
+		ModelTypeRouting: map[string]string{},
+	})
+	_, err := c.Patch(c.ETag(), map[string]interface{}{
+		"taskRouting": map[string]interface{}{"ocr": nil, "facial-recognition": "cpu"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{"clip": "gpu", "facial-recognition": "cpu"}
+	if got := c.Settings.TaskRouting; !reflect.DeepEqual(got, want) {
+		t.Errorf("taskRouting %v, want %v", got, want)
+	}
+}
@@ -11,7 +11,7 @@ import (
 type HTTPRecord struct {
 	ID        string              `json:"id"`
 	Timestamp time.Time           `json:"timestamp"`
-	Type      string              `json:"type"` // "incoming" or "outgoing"
+	Type      string              `json:"type"` // "incoming", "outgoing" or "event"
 	Request   RequestInfo         `json:"request"`
 	Response  ResponseInfo        `json:"response"`
 	Error     string              `json:"error,omitempty"`
@@ -208,6 +208,30 @@ func (dm *DebugManager) RecordOutgoingResponse(id string, statusCode int, header
 	dm.records[id] = record
 }
 
+// RecordEvent records a proxy event, e.g. a backend being ejected. The event's
+// kind is stored as the method and its description as the URL.
+func (dm *DebugManager) RecordEvent(id string, kind string, message string) {
+	if !dm.IsEnabled() {
+		return
+	}
+
+	dm.mu.Lock()
+	defer dm.mu.Unlock()
+
+	record := HTTPRecord{
+		ID:        id,
+		Timestamp: time.Now(),
+		Type:      "event",
+		Request: RequestInfo{
+			Method:  kind,
+			URL:     message,
+			Headers: map[string]string{},
+		},
+	}
+
+	dm.addRecord(record)
+}
+
 // RecordError records an error
 func (dm *DebugManager) RecordError(id string, err error) {
 	if !dm.IsEnabled() {
@@ -0,0 +1,242 @@
+package deepcheck
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"immich_ml_proxy/config"
+	"immich_ml_proxy/lifecycle"
+	"immich_ml_proxy/proxy"
+	"io"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	defaultInterval = 5 * time.Minute
+	defaultTimeout  = 2 * time.Minute
+	defaultText     = "a photo of a cat"
+	checkInterval   = 5 * time.Second // how often due checks are looked for
+	maxResponseSize = 1 << 20
+)
+
+// ResponseError is returned by Check when the backend answered, but not with
+// a result for the check
+type ResponseError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *ResponseError) Error() string {
+	return e.Message
+}
+
+// testImage is a small PNG sent to image models
+var testImage = func() []byte {
+	img := image.NewRGBA(image.Rect(0, 0, 64, 64))
+	for y := 0; y < 64; y++ {
+		for x := 0; x < 64; x++ {
+			img.Set(x, y, color.RGBA{R: uint8(x * 4), G: uint8(y * 4), B: 128, A: 255})
+		}
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		panic(err)
+	}
+	return buf.Bytes()
+}()
+
+// Targets returns the backends a deep check runs on
+type Targets func(check config.DeepCheck) []config.Backend
+
+// Checker runs the configured deep checks and records task health
+type Checker struct {
+	mu      sync.Mutex
+	lastRun map[string]time.Time // check key -> when it last started
+	running map[string]bool      // check key -> a round is in progress
+}
+
+var (
+	instance *Checker
+	once     sync.Once
+)
+
+// GetInstance returns the singleton Checker
+func GetInstance() *Checker {
+	once.Do(func() {
+		instance = &Checker{
+			lastRun: make(map[string]time.Time),
+			running: make(map[string]bool),
+		}
+	})
+	return instance
+}
+
+// Run starts each deep check when its interval has passed, on the backends
+// returned by targets, until ctx is done
+func (c *Checker) Run(ctx context.Context, cfg *config.Config, targets Targets) {
+	var rounds sync.WaitGroup
+	defer rounds.Wait()
+
+	ticker := time.NewTicker(checkInterval)
+	defer ticker.Stop()
+	for {
+		cfg.PruneTaskHealth()
+		for _, check := range cfg.GetDeepChecks() {
+			if !c.due(check) {
+				continue
+			}
+			rounds.Add(1)
+			go func(check config.DeepCheck) {
+				defer rounds.Done()
+				c.round(ctx, cfg, check, targets(check))
+			}(check)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// due reports whether a check should start now and marks it running if so
+func (c *Checker) due(check config.DeepCheck) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	key := check.Key()
+	if c.running[key] || time.Since(c.lastRun[key]) < check.Interval.Or(defaultInterval) {
+		return false
+	}
+	c.running[key] = true
+	c.lastRun[key] = time.Now()
+	return true
+}
+
+// round runs a check on all of its backends at once
+func (c *Checker) round(ctx context.Context, cfg *config.Config, check config.DeepCheck, backends []config.Backend) {
+	defer func() {
+		c.mu.Lock()
+		delete(c.running, check.Key())
+		c.mu.Unlock()
+	}()
+
+	var wg sync.WaitGroup
+	for _, backend := range backends {
+		// Task health is only meaningful for backends that are up; backends
+		// started on demand are not woken up for a check
+		status := cfg.GetHealthStatus(backend.Name).Status
+		if status == config.HealthStatusUnhealthy || (lifecycle.Managed(backend) && status != config.HealthStatusHealthy) {
+			continue
+		}
+		wg.Add(1)
+		go func(backend config.Backend) {
+			defer wg.Done()
+			c.checkBackend(ctx, cfg, check, backend)
+		}(backend)
+	}
+	wg.Wait()
+}
+
+// checkBackend runs a check on one backend and records the outcome
+func (c *Checker) checkBackend(ctx context.Context, cfg *config.Config, check config.DeepCheck, backend config.Backend) {
+	ctx, cancel := context.WithTimeout(ctx, check.Timeout.Or(defaultTimeout))
+	defer cancel()
+
+	start := time.Now()
+	err := Check(ctx, check, backend)
+	if ctx.Err() == context.Canceled {
+		return // shutting down
+	}
+
+	health := config.TaskHealth{
+		Status:    config.HealthStatusHealthy,
+		LastCheck: time.Now(),
+		LatencyMs: time.Since(start).Milliseconds(),
+	}
+	if err != nil {
+		health.Status = config.HealthStatusUnhealthy
+		health.Error = err.Error()
+	}
+
+	previous, checked := cfg.GetTaskHealth(backend.Name, check.Key())
+	switch {
+	case err != nil && (!checked || previous.Status != health.Status):
+		log.Printf("Deep check %s failed on backend %s: %v", check.Key(), backend.Name, err)
+	case err == nil && checked && previous.Status != health.Status:
+		log.Printf("Deep check %s passes again on backend %s", check.Key(), backend.Name)
+	}
+	cfg.SetTaskHealth(backend.Name, check.Key(), health)
+}
+
+// Check sends the check's test input through /predict on the backend and
+// validates that the response carries a result for the task
+func Check(ctx context.Context, check config.DeepCheck, backend config.Backend) error {
+	options := check.Options
+	if options == nil {
+		options = map[string]interface{}{}
+	}
+	models := map[string]interface{}{
+		check.ModelType: map[string]interface{}{
+			"modelName": check.ModelName,
+			"options":   options,
+		},
+	}
+	// Immich ML runs recognition on what detection found, so it only accepts
+	// recognition together with detection
+	if check.ModelType == "recognition" {
+		models["detection"] = map[string]interface{}{
+			"modelName": check.ModelName,
+			"options":   map[string]interface{}{},
+		}
+	}
+	entries, err := json.Marshal(map[string]interface{}{check.Task: models})
+	if err != nil {
+		return err
+	}
+
+	var img []byte
+	text := check.Text
+	if check.InputKind() == config.DeepCheckInputImage {
+		img = testImage
+	} else if text == "" {
+		text = defaultText
+	}
+
+	resp, err := proxy.SendPredict(ctx, backend, string(entries), img, text)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxResponseSize))
+	if err != nil {
+		return fmt.Errorf("reading response: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return &ResponseError{
+			StatusCode: resp.StatusCode,
+			Message:    fmt.Sprintf("unexpected response: %s: %s", resp.Status, bytes.TrimSpace(body)),
+		}
+	}
+
+	var result map[string]json.RawMessage
+	if err := json.Unmarshal(body, &result); err != nil {
+		return fmt.Errorf("invalid response: %w", err)
+	}
+	value, ok := result[check.Task]
+	if !ok || string(value) == "null" {
+		return &ResponseError{
+			StatusCode: resp.StatusCode,
+			Message:    fmt.Sprintf("response has no %s result", check.Task),
+		}
+	}
+	return nil
+}
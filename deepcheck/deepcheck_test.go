@@ -0,0 +1,138 @@
+package deepcheck
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"immich_ml_proxy/config"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// predictRequest is what the fake backend received
+type predictRequest struct {
+	entries map[string]map[string]interface{}
+	image   bool
+	text    string
+}
+
+func TestCheck(t *testing.T) {
+	tests := []struct {
+		name     string
+		check    config.DeepCheck
+		status   int
+		response string
+		models   []string // model types sent for the task
+		image    bool
+		text     string
+		err      string // "response" for a ResponseError, "other" for any other error
+	}{
+		{
+			name:     "image model",
+			check:    config.DeepCheck{Task: "clip", ModelType: "visual", ModelName: "ViT-B-32__openai"},
+			status:   http.StatusOK,
+			response: `{"clip": "[0.1, 0.2]"}`,
+			models:   []string{"visual"},
+			image:    true,
+		},
+		{
+			name:     "text model",
+			check:    config.DeepCheck{Task: "clip", ModelType: "textual", ModelName: "ViT-B-32__openai"},
+			status:   http.StatusOK,
+			response: `{"clip": "[0.1, 0.2]"}`,
+			models:   []string{"textual"},
+			text:     defaultText,
+		},
+		{
+			name:     "custom text",
+			check:    config.DeepCheck{Task: "clip", ModelType: "textual", ModelName: "ViT-B-32__openai", Text: "a dog"},
+			status:   http.StatusOK,
+			response: `{"clip": "[0.1, 0.2]"}`,
+			models:   []string{"textual"},
+			text:     "a dog",
+		},
+		{
+			name:     "recognition is sent with detection",
+			check:    config.DeepCheck{Task: "facial-recognition", ModelType: "recognition", ModelName: "buffalo_l"},
+			status:   http.StatusOK,
+			response: `{"facial-recognition": [], "imageHeight": 64, "imageWidth": 64}`,
+			models:   []string{"detection", "recognition"},
+			image:    true,
+		},
+		{
+			name:     "error status",
+			check:    config.DeepCheck{Task: "ocr", ModelType: "detection", ModelName: "PP-OCRv5_mobile"},
+			status:   http.StatusInternalServerError,
+			response: `{"detail": "model failed to load"}`,
+			models:   []string{"detection"},
+			image:    true,
+			err:      "response",
+		},
+		{
+			name:     "no result for the task",
+			check:    config.DeepCheck{Task: "ocr", ModelType: "detection", ModelName: "PP-OCRv5_mobile"},
+			status:   http.StatusOK,
+			response: `{"ocr": null}`,
+			models:   []string{"detection"},
+			image:    true,
+			err:      "response",
+		},
+		{
+			name:     "invalid response",
+			check:    config.DeepCheck{Task: "ocr", ModelType: "detection", ModelName: "PP-OCRv5_mobile"},
+			status:   http.StatusOK,
+			response: `not json`,
+			models:   []string{"detection"},
+			image:    true,
+			err:      "other",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			received := make(chan predictRequest, 1)
+			backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				var req predictRequest
+				json.Unmarshal([]byte(r.FormValue("entries")), &req.entries)
+				_, _, err := r.FormFile("image")
+				req.image = err == nil
+				req.text = r.FormValue("text")
+				received <- req
+				w.WriteHeader(test.status)
+				w.Write([]byte(test.response))
+			}))
+			defer backend.Close()
+
+			err := Check(context.Background(), test.check, config.Backend{Name: "gpu", URL: backend.URL})
+			var responseErr *ResponseError
+			switch test.err {
+			case "":
+				if err != nil {
+					t.Errorf("Check = %v, want no error", err)
+				}
+			case "response":
+				if !errors.As(err, &responseErr) || responseErr.StatusCode != test.status {
+					t.Errorf("Check = %v, want a ResponseError with status %d", err, test.status)
+				}
+			default:
+				if err == nil || errors.As(err, &responseErr) {
+					t.Errorf("Check = %v, want an error other than a ResponseError", err)
+				}
+			}
+
+			req := <-received
+			models := req.entries[test.check.Task]
+			if len(models) != len(test.models) {
+				t.Errorf("sent models %v, want %v", models, test.models)
+			}
+			for _, modelType := range test.models {
+				if _, ok := models[modelType]; !ok {
+					t.Errorf("sent models %v, want %s among them", models, modelType)
+				}
+			}
+			if req.image != test.image || req.text != test.text {
+				t.Errorf("sent image %v text %q, want image %v text %q", req.image, req.text, test.image, test.text)
+			}
+		})
+	}
+}
@@ -0,0 +1,394 @@
+package discovery
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"immich_ml_proxy/config"
+	"immich_ml_proxy/deepcheck"
+	"immich_ml_proxy/lifecycle"
+	"immich_ml_proxy/proxy"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultInterval = 30 * time.Minute
+	defaultTimeout  = 2 * time.Minute
+	retryInterval   = time.Minute     // for backends that could not be probed
+	checkInterval   = 5 * time.Second // how often due backends are looked for
+	maxFailures     = 3               // failed probes in a row after which a model counts as unsupported
+)
+
+// Capability is whether a backend can serve a model. It is only known once
+// the backend answered the probe, or failed it several times in a row;
+// timeouts, connection errors and 5xx responses leave it unknown.
+type Capability struct {
+	Supported bool      `json:"supported"`
+	Known     bool      `json:"known"`
+	Failures  int       `json:"failures,omitempty"` // failed probes in a row without a definite answer
+	Checked   time.Time `json:"checked"`
+	LatencyMs int64     `json:"latencyMs"`
+	Error     string    `json:"error,omitempty"`
+}
+
+// BackendCapabilities is what discovery found out about a backend
+type BackendCapabilities struct {
+	Info    map[string]interface{} `json:"info,omitempty"` // response of the backend's root endpoint
+	Checked time.Time              `json:"checked"`
+	Error   string                 `json:"error,omitempty"` // why the backend was not probed in the last round
+	Models  map[string]Capability  `json:"models"`          // task/modelType/modelName -> capability
+}
+
+// Warning is a route that sends a model to a backend that cannot serve it
+type Warning struct {
+	Rule    string `json:"rule"`
+	Backend string `json:"backend"`
+	Model   string `json:"model"` // task/modelType/modelName
+}
+
+func (w Warning) String() string {
+	return fmt.Sprintf("%s routes %s to backend %s, which cannot serve it", w.Rule, w.Model, w.Backend)
+}
+
+// Routes returns the rule a model is routed by and the backends of its target
+type Routes func(model config.TaskModel) (string, []config.Backend)
+
+// Discoverer probes backends for the models they can serve and keeps the
+// resulting capability matrix
+type Discoverer struct {
+	mu       sync.Mutex
+	backends map[string]*BackendCapabilities // backend name -> capabilities
+	warnings []Warning
+}
+
+var (
+	instance *Discoverer
+	once     sync.Once
+)
+
+// GetInstance returns the singleton Discoverer
+func GetInstance() *Discoverer {
+	once.Do(func() {
+		instance = &Discoverer{
+			backends: make(map[string]*BackendCapabilities),
+		}
+	})
+	return instance
+}
+
+// Supports reports whether the backend can serve the model. known is false if
+// the backend has not been probed for it or the probes failed for a reason
+// that says nothing about the model.
+func (d *Discoverer) Supports(backendName, task, modelType, modelName string) (supported bool, known bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	caps, ok := d.backends[backendName]
+	if !ok {
+		return false, false
+	}
+	model := config.TaskModel{Task: task, ModelType: modelType, ModelName: modelName}
+	capability, ok := caps.Models[model.Key()]
+	if !ok {
+		return false, false
+	}
+	return capability.Supported, capability.Known
+}
+
+// Matrix returns the capabilities of all probed backends
+func (d *Discoverer) Matrix() map[string]BackendCapabilities {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	result := make(map[string]BackendCapabilities, len(d.backends))
+	for name, caps := range d.backends {
+		c := *caps
+		c.Models = make(map[string]Capability, len(caps.Models))
+		for k, v := range caps.Models {
+			c.Models[k] = v
+		}
+		result[name] = c
+	}
+	return result
+}
+
+// Warnings returns the routes that send models to backends that cannot serve them
+func (d *Discoverer) Warnings() []Warning {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return append([]Warning{}, d.warnings...)
+}
+
+// Run probes the backends when the proxy starts, at the configured interval
+// and when models were added, until ctx is done. Backends that could not be
+// probed are retried every minute. While discovery is not configured, the
+// matrix is empty.
+func (d *Discoverer) Run(ctx context.Context, cfg *config.Config, routes Routes) {
+	ticker := time.NewTicker(checkInterval)
+	defer ticker.Stop()
+	for {
+		if settings := cfg.GetDiscovery(); settings == nil {
+			d.reset()
+		} else {
+			models := cfg.DiscoveryModels()
+			probes := probesOf(cfg.GetBackends(), models, routes, settings.AllBackends)
+			d.prune(probes)
+			if d.round(ctx, cfg, *settings, probes) && ctx.Err() == nil {
+				d.updateWarnings(models, routes)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// probesOf returns the models to probe each backend for: those routed to it,
+// or all of them with allBackends
+func probesOf(backends []config.Backend, models []config.TaskModel, routes Routes, allBackends bool) map[string][]config.TaskModel {
+	result := make(map[string][]config.TaskModel, len(backends))
+	for _, backend := range backends {
+		result[backend.Name] = []config.TaskModel{}
+	}
+	for _, model := range models {
+		if allBackends {
+			for name := range result {
+				result[name] = append(result[name], model)
+			}
+			continue
+		}
+		_, routed := routes(model)
+		for _, backend := range routed {
+			if _, ok := result[backend.Name]; ok {
+				result[backend.Name] = append(result[backend.Name], model)
+			}
+		}
+	}
+	return result
+}
+
+// due reports whether a backend should be probed: it never was, the interval
+// has passed, the last attempt or a model's probe failed a while ago, or a
+// model is new
+func (d *Discoverer) due(settings config.Discovery, models []config.TaskModel, backendName string) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	caps, ok := d.backends[backendName]
+	switch {
+	case !ok:
+		return true
+	case caps.Error != "":
+		return time.Since(caps.Checked) >= retryInterval
+	case time.Since(caps.Checked) >= settings.Interval.Or(defaultInterval):
+		return true
+	}
+	for _, model := range models {
+		capability, ok := caps.Models[model.Key()]
+		if !ok || !capability.Known && time.Since(capability.Checked) >= retryInterval {
+			return true
+		}
+	}
+	return false
+}
+
+// round probes the backends that are due in parallel, one model at a time
+// per backend. It reports whether any backend was probed.
+func (d *Discoverer) round(ctx context.Context, cfg *config.Config, settings config.Discovery, probes map[string][]config.TaskModel) bool {
+	var wg sync.WaitGroup
+	probed := false
+	for _, backend := range cfg.GetBackends() {
+		models := probes[backend.Name]
+		if !d.due(settings, models, backend.Name) {
+			continue
+		}
+		probed = true
+		wg.Add(1)
+		go func(backend config.Backend, models []config.TaskModel) {
+			defer wg.Done()
+			d.probe(ctx, cfg, settings, models, backend)
+		}(backend, models)
+	}
+	wg.Wait()
+	return probed
+}
+
+// prune forgets backends that are gone and models a backend is no longer
+// probed for
+func (d *Discoverer) prune(probes map[string][]config.TaskModel) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for name, caps := range d.backends {
+		models, ok := probes[name]
+		if !ok {
+			delete(d.backends, name)
+			continue
+		}
+		keys := make(map[string]bool, len(models))
+		for _, model := range models {
+			keys[model.Key()] = true
+		}
+		for key := range caps.Models {
+			if !keys[key] {
+				delete(caps.Models, key)
+			}
+		}
+	}
+}
+
+// probe reads the root info of a backend and probes it for every model
+func (d *Discoverer) probe(ctx context.Context, cfg *config.Config, settings config.Discovery, models []config.TaskModel, backend config.Backend) {
+	caps := BackendCapabilities{Checked: time.Now(), Models: make(map[string]Capability)}
+	d.mu.Lock()
+	if previous, ok := d.backends[backend.Name]; ok {
+		caps.Info = previous.Info
+		for k, v := range previous.Models {
+			caps.Models[k] = v
+		}
+	}
+	d.mu.Unlock()
+
+	// Backends that are down or asleep keep what was found out before
+	status := cfg.GetHealthStatus(backend.Name).Status
+	switch {
+	case status == config.HealthStatusUnhealthy:
+		caps.Error = "backend is unhealthy"
+	case lifecycle.Managed(backend) && status != config.HealthStatusHealthy:
+		caps.Error = "backend is not running"
+	default:
+		info, err := rootInfo(backend)
+		if err != nil {
+			caps.Error = err.Error()
+			break
+		}
+		caps.Info = info
+		for _, model := range models {
+			capability, err := probeModel(ctx, settings, model, backend, caps.Models[model.Key()])
+			if err != nil {
+				return // shutting down
+			}
+			caps.Models[model.Key()] = capability
+		}
+	}
+
+	d.mu.Lock()
+	d.backends[backend.Name] = &caps
+	d.mu.Unlock()
+}
+
+// probeModel sends a tiny request for the model. A failure only marks the
+// model unsupported if the backend rejected it or it failed maxFailures times
+// in a row; previous is the capability found before. It only returns an error
+// if ctx is done.
+func probeModel(ctx context.Context, settings config.Discovery, model config.TaskModel, backend config.Backend, previous Capability) (Capability, error) {
+	requestCtx, cancel := context.WithTimeout(ctx, settings.Timeout.Or(defaultTimeout))
+	defer cancel()
+
+	start := time.Now()
+	err := deepcheck.Check(requestCtx, config.DeepCheck{
+		Task:      model.Task,
+		ModelType: model.ModelType,
+		ModelName: model.ModelName,
+		Options:   model.Options,
+	}, backend)
+	if ctx.Err() != nil {
+		return Capability{}, ctx.Err()
+	}
+
+	capability := Capability{
+		Supported: err == nil,
+		Known:     true,
+		Checked:   time.Now(),
+		LatencyMs: time.Since(start).Milliseconds(),
+	}
+	if err != nil {
+		capability.Error = err.Error()
+		if !rejected(err) {
+			capability.Failures = previous.Failures + 1
+			capability.Known = capability.Failures >= maxFailures
+		}
+	}
+	return capability, nil
+}
+
+// rejected reports whether a probe failed because the backend cannot serve
+// the model: it answered with a 4xx status, without a result or with a model
+// that was not found. Timeouts, connection errors, 408, 429 and 5xx statuses
+// can be temporary, e.g. from a reverse proxy shedding load.
+func rejected(err error) bool {
+	var response *deepcheck.ResponseError
+	if !errors.As(err, &response) {
+		return false
+	}
+	switch response.StatusCode {
+	case http.StatusRequestTimeout, http.StatusTooManyRequests, http.StatusServiceUnavailable:
+		return false
+	}
+	return response.StatusCode < http.StatusInternalServerError ||
+		strings.Contains(strings.ToLower(response.Message), "not found")
+}
+
+// rootInfo reads the JSON the backend's root endpoint answers with
+func rootInfo(backend config.Backend) (map[string]interface{}, error) {
+	resp, err := proxy.ForwardRequest(backend, "GET", "/", nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected response: %s", resp.Status)
+	}
+	var info map[string]interface{}
+	if err := json.Unmarshal(body, &info); err != nil {
+		return nil, fmt.Errorf("root endpoint did not answer with JSON: %w", err)
+	}
+	return info, nil
+}
+
+// updateWarnings finds the routes that send a model to a backend that cannot
+// serve it and logs the ones that are new
+func (d *Discoverer) updateWarnings(models []config.TaskModel, routes Routes) {
+	var warnings []Warning
+	for _, model := range models {
+		rule, backends := routes(model)
+		for _, backend := range backends {
+			if supported, known := d.Supports(backend.Name, model.Task, model.ModelType, model.ModelName); known && !supported {
+				warnings = append(warnings, Warning{Rule: rule, Backend: backend.Name, Model: model.Key()})
+			}
+		}
+	}
+	sort.Slice(warnings, func(i, j int) bool { return warnings[i].String() < warnings[j].String() })
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	previous := make(map[Warning]bool)
+	for _, warning := range d.warnings {
+		previous[warning] = true
+	}
+	for _, warning := range warnings {
+		if !previous[warning] {
+			log.Printf("Capability warning: %s", warning)
+		}
+	}
+	d.warnings = warnings
+}
+
+// reset forgets all capabilities
+func (d *Discoverer) reset() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.backends = make(map[string]*BackendCapabilities)
+	d.warnings = nil
+}
@@ -0,0 +1,97 @@
+package discovery
+
+import (
+	"context"
+	"immich_ml_proxy/config"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+var clipVisual = config.TaskModel{Task: "clip", ModelType: "visual", ModelName: "ViT-B-32__openai"}
+
+// backendAnswering returns a backend that answers every predict request with
+// the status and body
+func backendAnswering(t *testing.T, status int, body string) config.Backend {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+		w.Write([]byte(body))
+	}))
+	t.Cleanup(server.Close)
+	return config.Backend{Name: "test", URL: server.URL}
+}
+
+func TestProbeModel(t *testing.T) {
+	tests := []struct {
+		name      string
+		status    int
+		body      string
+		supported bool
+		known     bool
+	}{
+		{"result", http.StatusOK, `{"clip":[0.1,0.2]}`, true, true},
+		{"no result", http.StatusOK, `{}`, false, true},
+		{"rejected", http.StatusUnprocessableEntity, `{"detail":"invalid model"}`, false, true},
+		{"model not found", http.StatusInternalServerError, `{"detail":"Model not found"}`, false, true},
+		{"unavailable", http.StatusServiceUnavailable, `{"detail":"loading"}`, false, false},
+		{"unavailable without model", http.StatusServiceUnavailable, `{"detail":"upstream not found"}`, false, false},
+		{"request timeout", http.StatusRequestTimeout, `{"detail":"timeout"}`, false, false},
+		{"rate limited", http.StatusTooManyRequests, `{"detail":"slow down"}`, false, false},
+		{"server error", http.StatusInternalServerError, `{"detail":"out of memory"}`, false, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			backend := backendAnswering(t, test.status, test.body)
+			capability, err := probeModel(context.Background(), config.Discovery{}, clipVisual, backend, Capability{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if capability.Supported != test.supported || capability.Known != test.known {
+				t.Errorf("got supported %v, known %v; want %v, %v", capability.Supported, capability.Known, test.supported, test.known)
+			}
+		})
+	}
+}
+
+func TestProbeModelUnsupportedAfterFailures(t *testing.T) {
+	backend := config.Backend{Name: "down", URL: "http://127.0.0.1:1"}
+	var capability Capability
+	for i := 1; i <= maxFailures; i++ {
+		var err error
+		capability, err = probeModel(context.Background(), config.Discovery{}, clipVisual, backend, capability)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if capability.Known != (i == maxFailures) {
+			t.Fatalf("after %d failures: known %v", i, capability.Known)
+		}
+	}
+	if capability.Supported {
+		t.Error("supported after failing every probe")
+	}
+}
+
+func TestProbesOnlyRoutedBackends(t *testing.T) {
+	backends := []config.Backend{{Name: "gpu"}, {Name: "cpu"}}
+	ocr := config.TaskModel{Task: "ocr", ModelType: "detection", ModelName: "PP-OCRv5_mobile"}
+	routes := func(model config.TaskModel) (string, []config.Backend) {
+		if model.Task == "clip" {
+			return "taskRouting[clip]", backends[:1]
+		}
+		return "defaultBackend", backends[1:]
+	}
+
+	probes := probesOf(backends, []config.TaskModel{clipVisual, ocr}, routes, false)
+	if len(probes["gpu"]) != 1 || probes["gpu"][0].Key() != clipVisual.Key() {
+		t.Errorf("gpu probed for %v, want only %s", probes["gpu"], clipVisual.Key())
+	}
+	if len(probes["cpu"]) != 1 || probes["cpu"][0].Key() != ocr.Key() {
+		t.Errorf("cpu probed for %v, want only %s", probes["cpu"], ocr.Key())
+	}
+
+	probes = probesOf(backends, []config.TaskModel{clipVisual, ocr}, routes, true)
+	if len(probes["gpu"]) != 2 || len(probes["cpu"]) != 2 {
+		t.Errorf("with allBackends got %v", probes)
+	}
+}
@@ -0,0 +1,24 @@
+package handlers
+
+import (
+	"immich_ml_proxy/discovery"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// CapabilitiesAPIGetHandler handles GET /api/backends/capabilities - returns
+// which models each backend can serve, as found by discovery, and the routes
+// that send models to backends that cannot serve them
+func CapabilitiesAPIGetHandler(c *gin.Context) {
+	if cfg.GetDiscovery() == nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "Discovery is not configured",
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"backends": discovery.GetInstance().Matrix(),
+		"warnings": discovery.GetInstance().Warnings(),
+	})
+}
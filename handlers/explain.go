@@ -0,0 +1,163 @@
+package handlers
+
+import (
+	"encoding/json"
+	"immich_ml_proxy/config"
+	"immich_ml_proxy/proxy"
+	"immich_ml_proxy/routing"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// explainRequest describes a sample predict request. Entries may be given as
+// an object or as the JSON string Immich sends in the entries form field.
+type explainRequest struct {
+	Entries   json.RawMessage   `json:"entries" binding:"required"`
+	ImageSize int64             `json:"imageSize"`
+	ClientIP  string            `json:"clientIP"`
+	Headers   map[string]string `json:"headers"`
+
+	// Content for consistent-hash rules: the text input, or the SHA-256 of the image
+	Text        string `json:"text"`
+	ImageSHA256 string `json:"imageSha256"`
+}
+
+type explainCandidate struct {
+	Name   string `json:"name"`
+	Status string `json:"status"`
+}
+
+type explainGroup struct {
+	Task       string             `json:"task"`
+	ModelType  string             `json:"modelType"`
+	ModelName  string             `json:"modelName,omitempty"`
+	Rule       string             `json:"rule,omitempty"`
+	Implicit   bool               `json:"implicit"`
+	Target     string             `json:"target,omitempty"`
+	Tier       int                `json:"tier,omitempty"`
+	Candidates []explainCandidate `json:"candidates"`
+	Backend    string             `json:"backend,omitempty"`
+	Timeout    string             `json:"timeout,omitempty"`
+	Retries    int                `json:"retries"`
+	Priority   string             `json:"priority,omitempty"`
+	Trace      []string           `json:"trace"`
+}
+
+// RouteExplainHandler handles POST /api/route/explain - shows which rule and backend
+// each type group of a sample request would be routed to, and why
+func RouteExplainHandler(c *gin.Context) {
+	var req explainRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Accept the entries form field value as a string as well
+	raw := []byte(req.Entries)
+	var entriesString string
+	if err := json.Unmarshal(raw, &entriesString); err == nil {
+		raw = []byte(entriesString)
+	}
+
+	var entriesMap map[string]interface{}
+	if err := json.Unmarshal(raw, &entriesMap); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid entries: " + err.Error()})
+		return
+	}
+	entries, err := proxy.ParseEntries(entriesMap)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to parse entries: " + err.Error()})
+		return
+	}
+
+	clientIP := req.ClientIP
+	if clientIP == "" {
+		clientIP = c.ClientIP()
+	}
+	header := make(http.Header)
+	for key, value := range req.Headers {
+		header.Set(key, value)
+	}
+
+	contentKey := strings.ToLower(req.ImageSHA256)
+	if contentKey == "" && req.Text != "" {
+		contentKey = proxy.TextHash(req.Text)
+	}
+
+	groups := []explainGroup{}
+	for t, te := range proxy.GroupEntriesByType(entries) {
+		routingReq := routing.Request{
+			Task:      te[0].Task,
+			ModelType: t,
+			ModelName: proxy.ModelName(te[0]),
+			ImageSize: req.ImageSize,
+			ClientIP:  clientIP,
+			Header:    header,
+			ContentKey: func() string {
+				return contentKey
+			},
+		}
+		decision := router.Explain(routingReq)
+
+		group := explainGroup{
+			Task:       routingReq.Task,
+			ModelType:  routingReq.ModelType,
+			ModelName:  routingReq.ModelName,
+			Rule:       decision.Rule,
+			Implicit:   decision.Implicit,
+			Target:     decision.Target,
+			Tier:       decision.Tier,
+			Candidates: []explainCandidate{},
+			Retries:    decision.Retries,
+			Priority:   decision.Priority,
+			Trace:      decision.Trace,
+		}
+		for _, candidate := range decision.Candidates {
+			group.Candidates = append(group.Candidates, explainCandidate{
+				Name:   candidate.Name,
+				Status: string(cfg.GetHealthStatus(candidate.Name).Status),
+			})
+		}
+		if decision.Backend != nil {
+			group.Backend = decision.Backend.Name
+		}
+		if decision.Timeout > 0 {
+			group.Timeout = decision.Timeout.String()
+		}
+		groups = append(groups, group)
+	}
+
+	// Map iteration order is random; keep the output stable
+	sort.Slice(groups, func(i, j int) bool {
+		if groups[i].Task != groups[j].Task {
+			return groups[i].Task < groups[j].Task
+		}
+		return groups[i].ModelType < groups[j].ModelType
+	})
+
+	c.JSON(http.StatusOK, gin.H{"groups": groups})
+}
+
+// RoutedBackends returns the backends a request for the task, model type and
+// model name is routed to, including ones that are currently passed over
+func RoutedBackends(task, modelType, modelName string) []config.Backend {
+	_, backends := RouteFor(task, modelType, modelName)
+	return backends
+}
+
+// RouteFor returns the rule a request for the task, model type and model name
+// matches and all backends of its target
+func RouteFor(task, modelType, modelName string) (string, []config.Backend) {
+	decision := router.Explain(routing.Request{
+		Task:      task,
+		ModelType: modelType,
+		ModelName: modelName,
+	})
+	if decision.Rule == "" {
+		return "", nil
+	}
+	return decision.Rule, cfg.ResolveTarget(decision.Target)
+}
@@ -1,23 +1,64 @@
 package handlers
 
 import (
-	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"immich_ml_proxy/config"
 	"immich_ml_proxy/debug"
+	"immich_ml_proxy/lifecycle"
+	"immich_ml_proxy/outlier"
 	"immich_ml_proxy/proxy"
+	"immich_ml_proxy/routing"
+	"immich_ml_proxy/scheduler"
+	"immich_ml_proxy/stats"
 	"io"
 	"net/http"
+	"strconv"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/gin-gonic/gin"
 )
 
-var cfg *config.Config
+var (
+	cfg    *config.Config
+	router *routing.Router
+	slots  *scheduler.Scheduler
+
+	shuttingDown atomic.Bool // set on shutdown so /ping reports not ready
+)
 
 func Init(c *config.Config) {
 	cfg = c
+	slots = scheduler.New(c)
+	router = routing.NewRouter(c, backendLoad{})
+}
+
+// BeginShutdown makes /ping report not ready, so clients stop sending new
+// requests while in-flight requests finish
+func BeginShutdown() {
+	shuttingDown.Store(true)
+}
+
+// backendLoad reports queue lengths and latency of backends to the router
+type backendLoad struct{}
+
+func (backendLoad) InFlight(backendName string) int {
+	return slots.Stats(backendName).InFlight
+}
+
+func (backendLoad) Queued(backendName string) int {
+	return slots.Stats(backendName).Queued
+}
+
+func (backendLoad) LatencyP95(backendName string) time.Duration {
+	return time.Duration(stats.GetInstance().Get(backendName).LatencyP95Ms * float64(time.Millisecond))
+}
+
+func (backendLoad) EstimateCompletion(backendName string, payloadBytes int64) (time.Duration, bool) {
+	return stats.GetInstance().EstimateCompletion(backendName, payloadBytes, slots.Stats(backendName).InFlight)
 }
 
 // RootHandler handles GET / - returns static service information
@@ -44,39 +85,28 @@ func RootHandler(c *gin.Context) {
 
 // PingHandler handles GET /ping - checks health status of all backends and returns "pong" if each type has at least one healthy backend
 func PingHandler(c *gin.Context) {
-	backendURLs := cfg.GetAllBackendURLs()
-	if len(backendURLs) == 0 {
+	if shuttingDown.Load() {
 		c.Status(http.StatusServiceUnavailable)
 		return
 	}
 
-	var wg sync.WaitGroup
-	statuses := make([]proxy.BackendStatus, len(backendURLs))
-	statusesMu := sync.Mutex{}
-
-	// Check health of all backends in parallel
-	for i, backend := range cfg.Backends {
-		wg.Add(1)
-		go func(idx int, b config.Backend) {
-			defer wg.Done()
-			status := proxy.CheckBackendHealth(b.URL)
-			statusesMu.Lock()
-			statuses[idx] = status
-			statusesMu.Unlock()
-
-			// Update health status in config
-			if status.Status == "healthy" {
-				cfg.SetHealthStatus(b.Name, config.HealthStatusHealthy, "")
-			} else {
-				cfg.SetHealthStatus(b.Name, config.HealthStatusUnhealthy, status.Error)
-			}
-		}(i, backend)
+	if !checkAllBackends() {
+		c.Status(http.StatusServiceUnavailable)
+		return
 	}
 
-	wg.Wait()
+	// Optionally only the tasks marked required count
+	if cfg.GetReadiness().Ping == config.PingRequired {
+		if requiredTasksReady() {
+			c.Data(http.StatusOK, "text/plain", []byte("pong"))
+		} else {
+			c.Status(http.StatusServiceUnavailable)
+		}
+		return
+	}
 
 	// Check if default backend is healthy (it handles all non-routed types)
-	defaultBackend := cfg.GetDefaultBackend()
+	defaultBackend := cfg.GetActiveDefaultBackend()
 	if defaultBackend == nil {
 		c.Status(http.StatusServiceUnavailable)
 		return
@@ -107,6 +137,35 @@ func PingHandler(c *gin.Context) {
 	}
 }
 
+// checkAllBackends pings all backends in parallel and updates their health
+// status. It returns false if no backends are configured.
+func checkAllBackends() bool {
+	backends := cfg.GetBackends()
+	if len(backends) == 0 {
+		return false
+	}
+
+	var wg sync.WaitGroup
+	for _, backend := range backends {
+		wg.Add(1)
+		go func(b config.Backend) {
+			defer wg.Done()
+			start := time.Now()
+			status := proxy.CheckBackendHealth(b)
+
+			// Update health status in config and record the check
+			if status.Status == "healthy" {
+				cfg.RecordHealthCheck(b.Name, config.HealthStatusHealthy, time.Since(start), "")
+			} else {
+				cfg.RecordHealthCheck(b.Name, config.HealthStatusUnhealthy, time.Since(start), status.Error)
+			}
+		}(backend)
+	}
+
+	wg.Wait()
+	return true
+}
+
 // PredictHandler handles POST /predict - routes requests by type, merges same-type entries, and preserves order
 func PredictHandler(c *gin.Context) {
 	// Parse entries to determine task type
@@ -143,170 +202,88 @@ func PredictHandler(c *gin.Context) {
 	var resultMutex sync.Mutex
 	var wg sync.WaitGroup
 
+	imageSize := uploadedImageSize(c.Request)
+	contentKey := sync.OnceValue(func() string {
+		return proxy.ContentHash(c.Request)
+	})
+
 	for typeName, typeEntries := range groupedByType {
 		wg.Add(1)
 		go func(t string, te []proxy.Entry) {
 			defer wg.Done()
 
-			// Build entries for this type
-			entriesForType, err := proxy.BuildEntriesForType(te)
-			if err != nil {
+			setError := func(err error) {
 				resultMutex.Lock()
 				typeErrors[t] = err
 				resultMutex.Unlock()
+			}
+
+			// Build entries for this type
+			entriesForType, err := proxy.BuildEntriesForType(te)
+			if err != nil {
+				setError(err)
 				return
 			}
 
-			// Step 1: Try modelType routing first (for clip: textual/visual)
-			var selectedBackend *config.Backend
-			for _, entry := range te {
-				// Try to route by modelType (e.g., textual, visual)
-				backend := cfg.GetBackendByModelType(entry.Type)
-				if backend != nil {
-					selectedBackend = backend
-					break
-				}
+			// Route by rules, then taskRouting/modelTypeRouting, then default backend.
+			// All entries in this group have the same type but may have different tasks;
+			// we use the first entry's task for routing
+			decision := router.Route(routing.Request{
+				Task:       te[0].Task,
+				ModelType:  t,
+				ModelName:  proxy.ModelName(te[0]),
+				ImageSize:  imageSize,
+				ClientIP:   c.ClientIP(),
+				Header:     c.Request.Header,
+				ContentKey: contentKey,
+			})
+			if decision.Backend == nil {
+				setError(fmt.Errorf("no backend available for task: %s, type: %s", te[0].Task, t))
+				return
 			}
 
-			// Step 2: If no modelType-specific backend found, try task-based routing
-			if selectedBackend == nil {
-				// Get the task name from entries (all entries in this group have the same type but may have different tasks)
-				// We use the first entry's task for routing
-				if len(te) > 0 {
-					taskName := te[0].Task
-					// Check if this task has specific routing in taskRouting
-					healthyBackends := cfg.GetHealthyBackendsByType(taskName)
-					allBackends := cfg.GetBackendsByType(taskName)
-
-					if len(allBackends) > 0 {
-						// Use round-robin to select backend
-						var backendList []string
-						if len(healthyBackends) > 0 {
-							// Prefer healthy backends
-							for _, b := range healthyBackends {
-								backendList = append(backendList, b.URL)
-							}
-						} else {
-							// No healthy backends, use all backends
-							for _, b := range allBackends {
-								backendList = append(backendList, b.URL)
-							}
-						}
-
-						// Use round-robin to select backend
-						selectedURL := proxy.GetNextBackend(taskName, backendList)
-						if selectedURL != "" {
-							// Find backend by URL
-							for _, b := range allBackends {
-								if b.URL == selectedURL {
-									selectedBackend = &b
-									break
-								}
-							}
-						}
-					}
-				}
+			// Create request with entries for this type
+			entriesJSON, err := json.Marshal(entriesForType)
+			if err != nil {
+				setError(err)
+				return
 			}
 
-			// Step 3: If still no backend found, fallback to default backend
-			if selectedBackend == nil {
-				if cfg.DefaultBackend != "" {
-					for _, b := range cfg.Backends {
-						if b.Name == cfg.DefaultBackend {
-							selectedBackend = &b
-							break
-						}
+			// Forward request, retrying on other backends of the pool if the rule allows it
+			tried := make(map[string]bool)
+			backend := decision.Backend
+			for attempt := 0; ; attempt++ {
+				tried[backend.Name] = true
+				release, err := startBackend(c, *backend)
+				if err != nil {
+					// Falling back from a backend that did not come up does not use up a retry
+					if next := startFallback(*backend, decision, tried); next != nil {
+						backend = next
+						attempt--
+						continue
 					}
+					setError(err)
+					return
 				}
-				if selectedBackend == nil {
-					taskName := ""
-					if len(te) > 0 {
-						taskName = te[0].Task
-					}
+				result, err := scheduledForward(c, *backend, decision, config.TaskKey(te[0].Task, t), string(entriesJSON))
+				release()
+				if err == nil {
 					resultMutex.Lock()
-					typeErrors[t] = fmt.Errorf("no backend available for task: %s, type: %s", taskName, t)
+					typeResults[t] = result
 					resultMutex.Unlock()
 					return
 				}
-			}
-
-			// Create request with entries for this type
-			entriesJSON, err := json.Marshal(entriesForType)
-			if err != nil {
-				resultMutex.Lock()
-				typeErrors[t] = err
-				resultMutex.Unlock()
-				return
-			}
-
-			// Forward request to backend
-			resp, bodyBytes, err := proxy.ForwardPredictRequestWithType(selectedBackend.URL, c.Request, string(entriesJSON))
-			if err != nil {
-				// Record error for debug
-				if debug.GetInstance().IsEnabled() {
-					recordID := debug.GenerateID()
-					debug.GetInstance().RecordOutgoingRequest(recordID, "POST", selectedBackend.URL+"/predict", c.Request.Header, bodyBytes)
-					debug.GetInstance().RecordError(recordID, err)
+				if attempt >= decision.Retries {
+					setError(err)
+					return
 				}
-
-				// Mark backend as unhealthy
-				cfg.SetHealthStatus(selectedBackend.Name, config.HealthStatusUnhealthy, err.Error())
-
-				resultMutex.Lock()
-				typeErrors[t] = err
-				resultMutex.Unlock()
-				return
-			}
-			defer resp.Body.Close()
-
-			// Update health status based on response
-			if resp.StatusCode == http.StatusOK {
-				cfg.SetHealthStatus(selectedBackend.Name, config.HealthStatusHealthy, "")
-			} else {
-				body, _ := io.ReadAll(resp.Body)
-				cfg.SetHealthStatus(selectedBackend.Name, config.HealthStatusUnhealthy, fmt.Sprintf("status %d: %s", resp.StatusCode, string(body)))
-				resp.Body = io.NopCloser(bytes.NewReader(body))
-			}
-
-			// Record outgoing request and response for debug
-			if debug.GetInstance().IsEnabled() {
-				recordID := debug.GenerateID()
-				debug.GetInstance().RecordOutgoingRequest(recordID, "POST", selectedBackend.URL+"/predict", c.Request.Header, bodyBytes)
-				body, _ := io.ReadAll(resp.Body)
-				resp.Body = io.NopCloser(bytes.NewReader(body))
-				debug.GetInstance().RecordOutgoingResponse(recordID, resp.StatusCode, resp.Header, body)
-				// Restore body for subsequent reads
-				resp.Body = io.NopCloser(bytes.NewReader(body))
-			}
-
-			// Read response
-			body, err := io.ReadAll(resp.Body)
-			if err != nil {
-				resultMutex.Lock()
-				typeErrors[t] = err
-				resultMutex.Unlock()
-				return
-			}
-
-			if resp.StatusCode != http.StatusOK {
-				resultMutex.Lock()
-				typeErrors[t] = fmt.Errorf("backend returned status %d: %s", resp.StatusCode, string(body))
-				resultMutex.Unlock()
-				return
-			}
-
-			// Parse response
-			var result map[string]interface{}
-			if err := json.Unmarshal(body, &result); err != nil {
-				resultMutex.Lock()
-				typeErrors[t] = err
-				resultMutex.Unlock()
-				return
+				next := router.Next(decision, tried)
+				if next == nil {
+					setError(err)
+					return
+				}
+				backend = next
 			}
-
-			resultMutex.Lock()
-			typeResults[t] = result
-			resultMutex.Unlock()
 		}(typeName, typeEntries)
 	}
 
@@ -315,9 +292,25 @@ func PredictHandler(c *gin.Context) {
 	// Check for errors
 	if len(typeErrors) > 0 {
 		var errMsgs []string
+		retryAfter := 0
 		for t, err := range typeErrors {
 			errMsgs = append(errMsgs, fmt.Sprintf("type %s: %v", t, err))
+			var overload *scheduler.OverloadError
+			if errors.As(err, &overload) && overload.RetryAfterSeconds() > retryAfter {
+				retryAfter = overload.RetryAfterSeconds()
+			}
 		}
+
+		// Shed load: tell Immich to come back later instead of failing the job
+		if retryAfter > 0 {
+			c.Header("Retry-After", strconv.Itoa(retryAfter))
+			c.JSON(http.StatusServiceUnavailable, gin.H{
+				"error":  "Backend overloaded",
+				"errors": errMsgs,
+			})
+			return
+		}
+
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"error": "Failed to process some types",
 			"errors": errMsgs,
@@ -342,6 +335,84 @@ func PredictHandler(c *gin.Context) {
 	c.JSON(http.StatusOK, finalResult)
 }
 
+// scheduledForward waits for a slot on the backend in the decision's priority
+// class, then forwards the entries and records the latency and outcome for the task
+func scheduledForward(c *gin.Context, backend config.Backend, decision routing.Decision, task, entriesJSON string) (map[string]interface{}, error) {
+	release, err := slots.Acquire(c.Request.Context(), backend, decision.Priority)
+	if err != nil {
+		return nil, fmt.Errorf("waiting for a slot: %w", err)
+	}
+	defer release()
+
+	start := time.Now()
+	result, err := forwardPredict(c, backend, entriesJSON, decision.Timeout)
+	latency := time.Since(start)
+	stats.GetInstance().Record(backend.Name, task, latency, err != nil)
+	slots.Observe(backend.Name, latency, err != nil)
+	return result, err
+}
+
+// forwardPredict sends the entries of one type group to a backend, updates the
+// backend's health from the outcome and returns the parsed response
+func forwardPredict(c *gin.Context, backend config.Backend, entriesJSON string, timeout time.Duration) (map[string]interface{}, error) {
+	resp, bodyBytes, err := proxy.ForwardPredictRequestWithType(backend, c.Request, entriesJSON, timeout)
+	if err != nil {
+		// Record error for debug
+		if debug.GetInstance().IsEnabled() {
+			recordID := debug.GenerateID()
+			debug.GetInstance().RecordOutgoingRequest(recordID, "POST", backend.URL+"/predict", c.Request.Header, bodyBytes)
+			debug.GetInstance().RecordError(recordID, err)
+		}
+
+		// Mark backend as unhealthy
+		cfg.SetHealthStatus(backend.Name, config.HealthStatusUnhealthy, err.Error())
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	// Read response
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	// Update health status based on response
+	if resp.StatusCode == http.StatusOK {
+		cfg.SetHealthStatus(backend.Name, config.HealthStatusHealthy, "")
+	} else {
+		cfg.SetHealthStatus(backend.Name, config.HealthStatusUnhealthy, fmt.Sprintf("status %d: %s", resp.StatusCode, string(body)))
+	}
+
+	// Record outgoing request and response for debug
+	if debug.GetInstance().IsEnabled() {
+		recordID := debug.GenerateID()
+		debug.GetInstance().RecordOutgoingRequest(recordID, "POST", backend.URL+"/predict", c.Request.Header, bodyBytes)
+		debug.GetInstance().RecordOutgoingResponse(recordID, resp.StatusCode, resp.Header, body)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("backend returned status %d: %s", resp.StatusCode, string(body))
+	}
+
+	// Parse response
+	var result map[string]interface{}
+	if err := json.Unmarshal(body, &result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// uploadedImageSize returns the size of the uploaded image in bytes, or 0
+func uploadedImageSize(r *http.Request) int64 {
+	if r.MultipartForm == nil {
+		return 0
+	}
+	if files := r.MultipartForm.File["image"]; len(files) > 0 {
+		return files[0].Size
+	}
+	return 0
+}
+
 // ConfigGetHandler handles GET /config - returns web configuration UI
 func ConfigGetHandler(c *gin.Context) {
 	c.File("static/config.html")
@@ -349,32 +420,91 @@ func ConfigGetHandler(c *gin.Context) {
 
 // ConfigAPIGetHandler handles GET /api/config - returns current configuration as JSON
 func ConfigAPIGetHandler(c *gin.Context) {
-	data, err := cfg.ToJSON()
+	data, etag, err := cfg.ToJSONWithETag()
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"error": err.Error(),
 		})
 		return
 	}
+	c.Header("ETag", etag)
+	if profile := cfg.GetScheduleState().Profile; profile != "" {
+		c.Header("X-Active-Profile", profile)
+	}
+	if c.GetHeader("If-None-Match") == etag {
+		c.Status(http.StatusNotModified)
+		return
+	}
 	c.Data(http.StatusOK, "application/json", data)
 }
 
-// HealthAPIGetHandler handles GET /api/health - returns health status of all backends
+// ConfigSchemaHandler handles GET /api/config/schema - returns the JSON Schema of the config file
+func ConfigSchemaHandler(c *gin.Context) {
+	c.JSON(http.StatusOK, config.JSONSchema())
+}
+
+// backendHealthView is the health of a backend together with its current load
+type backendHealthView struct {
+	config.BackendHealth
+	scheduler.QueueStats
+	AdminState string                       `json:"adminState,omitempty"`      // draining or disabled; omitted when active
+	Weight     float64                      `json:"slowStartWeight,omitempty"` // below 1 while the backend ramps up after turning healthy
+	DisabledBy string                       `json:"disabledBy,omitempty"`      // schedule that currently disables the backend
+	Ejection   *outlier.Ejection            `json:"ejection,omitempty"`        // set while outlier detection ejects the backend
+	Lifecycle  lifecycle.State              `json:"lifecycle,omitempty"`       // for backends that are woken up or started on demand
+	Tasks      map[string]config.TaskHealth `json:"tasks,omitempty"`           // deep check outcomes by task/modelType
+}
+
+// HealthAPIGetHandler handles GET /api/health - returns health status and load of all backends.
+// The active routing profile is reported as activeProfile next to the backends
+// map and in the X-Active-Profile header.
 func HealthAPIGetHandler(c *gin.Context) {
+	schedules := cfg.GetScheduleState()
+
 	healthStatus := cfg.GetAllHealthStatus()
-	c.JSON(http.StatusOK, healthStatus)
-}
+	for _, backend := range cfg.GetBackends() {
+		if _, ok := healthStatus[backend.Name]; !ok {
+			healthStatus[backend.Name] = cfg.GetHealthStatus(backend.Name)
+		}
+	}
 
-// ConfigPostHandler handles POST /api/config - saves configuration
-type ConfigRequest struct {
-	DefaultBackend   string            `json:"defaultBackend"`
-	Backends         []config.Backend  `json:"backends"`
-	TaskRouting      map[string]string `json:"taskRouting"`
-	ModelTypeRouting map[string]string `json:"modelTypeRouting"`
+	backends := make(map[string]backendHealthView, len(healthStatus))
+	for name, health := range healthStatus {
+		view := backendHealthView{
+			BackendHealth: health,
+			QueueStats:    slots.Stats(name),
+			DisabledBy:    schedules.Disabled[name],
+		}
+		if tasks := cfg.GetAllTaskHealth(name); len(tasks) > 0 {
+			view.Tasks = tasks
+		}
+		if ejection, ok := outlier.GetInstance().Ejected(name); ok {
+			view.Ejection = &ejection
+		}
+		if backend := cfg.GetBackend(name); backend != nil {
+			if weight := cfg.SlowStartWeight(*backend); weight < 1 && health.Status == config.HealthStatusHealthy {
+				view.Weight = weight
+			}
+			if !backend.Routable() {
+				view.AdminState = backend.Admin()
+			}
+			if lifecycle.Managed(*backend) {
+				view.Lifecycle = lifecycle.GetInstance().Get(name).State
+			}
+		}
+		backends[name] = view
+	}
+	result := gin.H{"backends": backends}
+	if schedules.Profile != "" {
+		result["activeProfile"] = schedules.Profile
+		c.Header("X-Active-Profile", schedules.Profile)
+	}
+	c.JSON(http.StatusOK, result)
 }
 
+// ConfigPostHandler handles POST /api/config - saves configuration
 func ConfigPostHandler(c *gin.Context) {
-	var req ConfigRequest
+	var req config.Settings
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{
 			"error": err.Error(),
@@ -413,21 +543,46 @@ func ConfigPostHandler(c *gin.Context) {
 		return
 	}
 
-	// Update config
-	cfg.DefaultBackend = req.DefaultBackend
-	cfg.Backends = req.Backends
-	cfg.TaskRouting = req.TaskRouting
-	cfg.ModelTypeRouting = req.ModelTypeRouting
-
-	// Save to file
-	if err := cfg.Save(); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": err.Error(),
-		})
+	// Update config and save it to the store
+	etag, err := cfg.Replace(c.GetHeader("If-Match"), req)
+	if err != nil {
+		writeConfigError(c, err)
 		return
 	}
 
+	c.Header("ETag", etag)
 	c.JSON(http.StatusOK, gin.H{
 		"message": "Configuration saved successfully",
 	})
-}
\ No newline at end of file
+}
+
+// ConfigHistoryHandler handles GET /api/config/history - returns previous config revisions
+func ConfigHistoryHandler(c *gin.Context) {
+	limit := 20
+	if value := c.Query("limit"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 1 || parsed > 200 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be between 1 and 200"})
+			return
+		}
+		limit = parsed
+	}
+
+	revisions, supported, err := cfg.History(limit)
+	if !supported {
+		c.JSON(http.StatusNotImplemented, gin.H{
+			"error": "Config store does not keep history",
+		})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+	if revisions == nil {
+		revisions = []config.Revision{}
+	}
+	c.JSON(http.StatusOK, revisions)
+}
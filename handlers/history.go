@@ -0,0 +1,78 @@
+package handlers
+
+import (
+	"immich_ml_proxy/config"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// uptimeWindows are the windows uptime is reported for
+var uptimeWindows = []struct {
+	name     string
+	duration time.Duration
+}{
+	{"24h", 24 * time.Hour},
+	{"7d", 7 * 24 * time.Hour},
+}
+
+// backendHistoryView is the health history of a backend in GET /api/health/history
+type backendHistoryView struct {
+	Status config.HealthStatus      `json:"status"`
+	Uptime map[string]config.Uptime `json:"uptime"` // window -> uptime
+	config.HealthHistory
+}
+
+// HealthHistoryAPIGetHandler handles GET /api/health/history - returns the
+// health transitions and check results of the backends since a time, with
+// their uptime over the last 24 hours and 7 days. since is an RFC 3339 time
+// or a duration before now and defaults to 24h; backend limits the result to
+// one backend.
+func HealthHistoryAPIGetHandler(c *gin.Context) {
+	since := time.Now().Add(-24 * time.Hour)
+	if value := c.Query("since"); value != "" {
+		if d, err := time.ParseDuration(value); err == nil && d >= 0 {
+			since = time.Now().Add(-d)
+		} else if t, err := time.Parse(time.RFC3339, value); err == nil {
+			since = t
+		} else {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": "since must be an RFC 3339 time or a duration like 24h",
+			})
+			return
+		}
+	}
+
+	var names []string
+	if name := c.Query("backend"); name != "" {
+		if cfg.GetBackend(name) == nil {
+			c.JSON(http.StatusNotFound, gin.H{
+				"error": "Backend not found",
+			})
+			return
+		}
+		names = []string{name}
+	} else {
+		for _, backend := range cfg.GetBackends() {
+			names = append(names, backend.Name)
+		}
+	}
+
+	result := make(map[string]backendHistoryView, len(names))
+	for _, name := range names {
+		view := backendHistoryView{
+			Status:        cfg.GetHealthStatus(name).Status,
+			Uptime:        make(map[string]config.Uptime, len(uptimeWindows)),
+			HealthHistory: cfg.GetHealthHistory(name, since),
+		}
+		for _, window := range uptimeWindows {
+			view.Uptime[window.name] = cfg.GetUptime(name, window.duration)
+		}
+		result[name] = view
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"since":    since,
+		"backends": result,
+	})
+}
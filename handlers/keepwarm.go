@@ -0,0 +1,17 @@
+package handlers
+
+import (
+	"immich_ml_proxy/keepwarm"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// KeepWarmAPIGetHandler handles GET /api/keepwarm - returns whether keep-warm
+// requests are being sent and the last one of every model on every backend
+func KeepWarmAPIGetHandler(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"active":  cfg.KeepWarmActive(),
+		"warmups": keepwarm.GetInstance().Warmups(),
+	})
+}
@@ -0,0 +1,72 @@
+package handlers
+
+import (
+	"immich_ml_proxy/config"
+	"immich_ml_proxy/lifecycle"
+	"immich_ml_proxy/routing"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// startBackend marks a request to the backend as in flight and wakes or
+// starts the backend if it has wake-on-LAN or lifecycle settings and is down,
+// holding the request until it answers /ping. The request counts before the
+// backend is checked, so it cannot be stopped for idleness in between. Call
+// release when the request is done; on error it was already called.
+func startBackend(c *gin.Context, backend config.Backend) (release func(), err error) {
+	manager := lifecycle.GetInstance()
+	release = manager.Begin(backend.Name)
+	if !lifecycle.Managed(backend) {
+		return release, nil
+	}
+	if cfg.GetHealthStatus(backend.Name).Status == config.HealthStatusHealthy && manager.Get(backend.Name).State != lifecycle.StateStopped {
+		return release, nil
+	}
+	if err := manager.Start(c.Request.Context(), backend); err != nil {
+		release()
+		return nil, err
+	}
+	cfg.SetHealthStatus(backend.Name, config.HealthStatusHealthy, "")
+	return release, nil
+}
+
+// startFallback returns the backend to use after backend could not be woken
+// up or started: another backend of the decision's pool, else one of the
+// backend's fallback target, preferring healthy backends. It returns nil if
+// there is none.
+func startFallback(backend config.Backend, decision routing.Decision, tried map[string]bool) *config.Backend {
+	if next := router.Next(decision, tried); next != nil {
+		return next
+	}
+	target := backend.StartFallback()
+	if target == "" {
+		return nil
+	}
+
+	candidates := cfg.ResolveTarget(target)
+	for _, pool := range [][]config.Backend{cfg.FilterHealthy(candidates), candidates} {
+		for _, candidate := range pool {
+			if !tried[candidate.Name] {
+				return &candidate
+			}
+		}
+	}
+	return nil
+}
+
+// LifecycleAPIGetHandler handles GET /api/lifecycle - returns the lifecycle state of
+// backends that are woken up or started on demand, and their start and stop events
+func LifecycleAPIGetHandler(c *gin.Context) {
+	manager := lifecycle.GetInstance()
+	backends := make(map[string]lifecycle.BackendState)
+	for _, backend := range cfg.GetBackends() {
+		if lifecycle.Managed(backend) {
+			backends[backend.Name] = manager.Get(backend.Name)
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"backends": backends,
+		"events":   manager.Events(),
+	})
+}
@@ -0,0 +1,191 @@
+package handlers
+
+import (
+	"context"
+	"immich_ml_proxy/config"
+	"immich_ml_proxy/lifecycle"
+	"immich_ml_proxy/routing"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestMain(m *testing.M) {
+	dir, err := os.MkdirTemp("", "handlers")
+	if err != nil {
+		panic(err)
+	}
+	config.UseStore(config.NewFileStore(filepath.Join(dir, "config.json")))
+	Init(config.Load())
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+func TestStartFallsBackAfterWakeTimeout(t *testing.T) {
+	// The sleeping backend never wakes up, its fallback is always up
+	asleep := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer asleep.Close()
+	awake := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("pong"))
+	}))
+	defer awake.Close()
+
+	udp, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer udp.Close()
+	var packets atomic.Int32
+	go func() {
+		buf := make([]byte, 1024)
+		for {
+			if _, _, err := udp.ReadFrom(buf); err != nil {
+				return
+			}
+			packets.Add(1)
+		}
+	}()
+
+	_, err = cfg.Replace("", config.Settings{
+		DefaultBackend: "sleepy",
+		Backends: []config.Backend{
+			{
+				Name: "sleepy",
+				URL:  asleep.URL,
+				WakeOnLAN: &config.WakeOnLAN{
+					MAC:         "aa:bb:cc:dd:ee:ff",
+					Broadcast:   udp.LocalAddr().String(),
+					WakeTimeout: config.Duration(300 * time.Millisecond),
+					Fallback:    "fallback",
+				},
+			},
+			{Name: "fallback", URL: awake.URL},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sleepy := *cfg.GetBackend("sleepy")
+	startsBefore := lifecycle.GetInstance().Get("sleepy").Starts
+
+	// Requests arriving together wait for one wake-up attempt and all fail
+	errs := make([]error, 5)
+	var wg sync.WaitGroup
+	for i := range errs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			c, _ := gin.CreateTestContext(httptest.NewRecorder())
+			c.Request = httptest.NewRequest(http.MethodPost, "/predict", nil)
+			_, errs[i] = startBackend(c, sleepy)
+		}(i)
+	}
+	wg.Wait()
+
+	for i, err := range errs {
+		if err == nil {
+			t.Errorf("request %d: backend started, want the wake timeout", i)
+		}
+	}
+	if n := packets.Load(); n != 1 {
+		t.Errorf("%d magic packets sent, want 1", n)
+	}
+	if starts := lifecycle.GetInstance().Get("sleepy").Starts - startsBefore; starts != 1 {
+		t.Errorf("%d start attempts, want 1", starts)
+	}
+
+	// The requests then go to the fallback
+	decision := routing.Decision{Candidates: []config.Backend{sleepy}}
+	next := startFallback(sleepy, decision, map[string]bool{"sleepy": true})
+	if next == nil || next.Name != "fallback" {
+		t.Fatalf("fell back to %v, want backend fallback", next)
+	}
+}
+
+func TestIdleStopWaitsForRequests(t *testing.T) {
+	// The backend answers /ping while running; the hooks start and stop it
+	var running atomic.Bool
+	running.Store(true)
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !running.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte("pong"))
+	}))
+	defer backend.Close()
+	hooks := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		running.Store(r.URL.Path == "/start")
+	}))
+	defer hooks.Close()
+
+	_, err := cfg.Replace("", config.Settings{
+		DefaultBackend: "ondemand",
+		Backends: []config.Backend{{
+			Name: "ondemand",
+			URL:  backend.URL,
+			Lifecycle: &config.Lifecycle{
+				Start:       &config.Hook{URL: hooks.URL + "/start"},
+				Stop:        &config.Hook{URL: hooks.URL + "/stop"},
+				IdleTimeout: config.Duration(time.Millisecond),
+			},
+		}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ondemand := *cfg.GetBackend("ondemand")
+	cfg.SetHealthStatus("ondemand", config.HealthStatusHealthy, "")
+
+	// One round of the idle check; a done context makes Run return after it
+	done, cancel := context.WithCancel(context.Background())
+	cancel()
+	manager := lifecycle.GetInstance()
+
+	// Requests and idle stops race, each taking the lead in some rounds; a
+	// request must never find its backend stopped once startBackend let it
+	// through
+	for i := 0; i < 50; i++ {
+		var wg sync.WaitGroup
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			time.Sleep(time.Duration(i%5) * 500 * time.Microsecond)
+			manager.Run(done, cfg)
+		}()
+		go func() {
+			defer wg.Done()
+			c, _ := gin.CreateTestContext(httptest.NewRecorder())
+			c.Request = httptest.NewRequest(http.MethodPost, "/predict", nil)
+			time.Sleep(time.Millisecond)
+			release, err := startBackend(c, ondemand)
+			if err != nil {
+				t.Errorf("round %d: %v", i, err)
+				return
+			}
+			defer release()
+			time.Sleep(10 * time.Millisecond) // longer than a stop hook takes
+			if !running.Load() {
+				t.Errorf("round %d: backend stopped while a request was in flight", i)
+			}
+			if state := manager.Get("ondemand").State; state != lifecycle.StateReady {
+				t.Errorf("round %d: state %s while a request was in flight, want ready", i, state)
+			}
+		}()
+		wg.Wait()
+	}
+	if manager.Get("ondemand").Stops == 0 {
+		t.Error("the idle backend was never stopped")
+	}
+}
@@ -0,0 +1,43 @@
+package handlers
+
+import (
+	"immich_ml_proxy/notify"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// NotificationsAPIGetHandler handles GET /api/notifications - returns the most
+// recent notification deliveries and their errors
+func NotificationsAPIGetHandler(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"deliveries": notify.GetInstance().Deliveries(),
+	})
+}
+
+// NotificationsTestHandler handles POST /api/notifications/test - sends a test
+// notification to all targets and returns the outcome per target
+func NotificationsTestHandler(c *gin.Context) {
+	settings := cfg.GetNotifications()
+	if settings == nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "Notifications are not configured",
+		})
+		return
+	}
+
+	now := time.Now()
+	deliveries := notify.GetInstance().Send(c.Request.Context(), *settings, notify.Event{
+		Kind:    "test",
+		Subject: "test",
+		Status:  "test",
+		Title:   "Test notification",
+		Message: "This is a test notification from Immich ML Proxy.",
+		Since:   now,
+		Time:    now,
+	})
+	c.JSON(http.StatusOK, gin.H{
+		"deliveries": deliveries,
+	})
+}
@@ -0,0 +1,252 @@
+package handlers
+
+import (
+	"immich_ml_proxy/config"
+	"immich_ml_proxy/routing"
+	"net/http"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// readinessMaxAge is how old the cached health of a backend may get before a
+// readiness probe refreshes it in the background
+const readinessMaxAge = 10 * time.Second
+
+// refreshingHealth is set while a background health refresh is running
+var refreshingHealth atomic.Bool
+
+// readinessBackend is one backend a task routes to and whether it can serve it
+type readinessBackend struct {
+	Name    string              `json:"name"`
+	Status  config.HealthStatus `json:"status"`
+	Serving bool                `json:"serving"`
+	Error   string              `json:"error,omitempty"` // why the backend cannot serve the task
+}
+
+// taskReadiness is whether a task can be served and by which backends
+type taskReadiness struct {
+	Task     string             `json:"task"` // task or task.modelType
+	Required bool               `json:"required"`
+	Ready    bool               `json:"ready"`
+	Rule     string             `json:"rule,omitempty"`
+	Target   string             `json:"target,omitempty"`
+	Backends []readinessBackend `json:"backends"`
+	Error    string             `json:"error,omitempty"`
+}
+
+// readinessView is the response of GET /api/readiness
+type readinessView struct {
+	Ready        bool            `json:"ready"` // all required tasks can be served
+	ShuttingDown bool            `json:"shuttingDown,omitempty"`
+	Tasks        []taskReadiness `json:"tasks"`
+}
+
+// LivezHandler handles GET /livez - reports that the proxy process is up
+func LivezHandler(c *gin.Context) {
+	c.String(http.StatusOK, "ok")
+}
+
+// ReadyzHandler handles GET /readyz - returns 200 if all required tasks can be
+// served, 503 with the tasks that cannot otherwise
+func ReadyzHandler(c *gin.Context) {
+	if shuttingDown.Load() {
+		c.String(http.StatusServiceUnavailable, "shutting down")
+		return
+	}
+
+	refreshStaleHealth()
+	readiness := readinessOf(cfg.GetReadiness())
+	if readiness.Ready {
+		c.String(http.StatusOK, "ok")
+		return
+	}
+
+	var notReady []string
+	for _, task := range readiness.Tasks {
+		if task.Required && !task.Ready {
+			notReady = append(notReady, task.Task)
+		}
+	}
+	if len(notReady) == 0 {
+		c.String(http.StatusServiceUnavailable, "no backends configured")
+		return
+	}
+	c.String(http.StatusServiceUnavailable, "not ready: "+strings.Join(notReady, ", "))
+}
+
+// ReadinessAPIGetHandler handles GET /api/readiness - returns for every routed
+// and required task whether it can be served and by which backends
+func ReadinessAPIGetHandler(c *gin.Context) {
+	refreshStaleHealth()
+	readiness := readinessOf(cfg.GetReadiness())
+	if shuttingDown.Load() {
+		readiness.Ready = false
+		readiness.ShuttingDown = true
+	}
+	c.JSON(http.StatusOK, readiness)
+}
+
+// requiredTasksReady reports whether all required tasks can be served
+func requiredTasksReady() bool {
+	return readinessOf(cfg.GetReadiness()).Ready
+}
+
+// refreshStaleHealth pings the backends in the background if the cached health
+// of one of them is older than readinessMaxAge. Readiness is always answered
+// from the cached health, so probes never wait for slow or sleeping backends.
+func refreshStaleHealth() {
+	cutoff := time.Now().Add(-readinessMaxAge).Unix()
+	stale := false
+	for _, backend := range cfg.GetBackends() {
+		if cfg.GetHealthStatus(backend.Name).LastCheck < cutoff {
+			stale = true
+			break
+		}
+	}
+	if !stale || !refreshingHealth.CompareAndSwap(false, true) {
+		return
+	}
+	go func() {
+		defer refreshingHealth.Store(false)
+		checkAllBackends()
+	}()
+}
+
+// readinessOf evaluates the routed and required tasks against the cached
+// backend health. Without requiredTasks, all routed tasks are required, and
+// without routed tasks the default backend, which serves every task, is.
+func readinessOf(settings config.Readiness) readinessView {
+	routed := cfg.GetAllTypes()
+	required := make(map[string]bool)
+	for _, task := range settings.RequiredTasks {
+		required[task] = true
+	}
+	if len(required) == 0 {
+		for _, task := range routed {
+			required[task] = true
+		}
+	}
+
+	tasks := make(map[string]bool)
+	for _, task := range routed {
+		tasks[task] = true
+	}
+	for task := range required {
+		tasks[task] = true
+	}
+	var keys []string
+	for task := range tasks {
+		keys = append(keys, task)
+	}
+	sort.Strings(keys)
+
+	result := readinessView{
+		Ready: len(cfg.GetBackends()) > 0,
+		Tasks: []taskReadiness{},
+	}
+	for _, key := range keys {
+		task := readinessOfTask(key)
+		task.Required = required[key]
+		if task.Required && !task.Ready {
+			result.Ready = false
+		}
+		result.Tasks = append(result.Tasks, task)
+	}
+	if len(keys) == 0 {
+		task := readinessOfDefault()
+		task.Required = true
+		if !task.Ready {
+			result.Ready = false
+		}
+		result.Tasks = append(result.Tasks, task)
+	}
+	return result
+}
+
+// readinessOfDefault checks whether the active default backend can serve the
+// tasks that have no route
+func readinessOfDefault() taskReadiness {
+	result := taskReadiness{
+		Task:     "default",
+		Backends: []readinessBackend{},
+	}
+	backend := cfg.GetActiveDefaultBackend()
+	if backend == nil {
+		result.Error = "no default backend"
+		return result
+	}
+
+	health := cfg.GetHealthStatus(backend.Name)
+	result.Target = backend.Name
+	result.Ready = health.Status == config.HealthStatusHealthy
+	result.Backends = append(result.Backends, readinessBackend{
+		Name:    backend.Name,
+		Status:  health.Status,
+		Serving: result.Ready,
+		Error:   health.Error,
+	})
+	if !result.Ready {
+		result.Error = "no backend can serve the task"
+	}
+	return result
+}
+
+// readinessOfTask routes a task like a request for it would be routed and
+// checks whether one of the backends can serve it
+func readinessOfTask(key string) taskReadiness {
+	task, modelType := config.SplitTask(key)
+	decision := router.Explain(routing.Request{Task: task, ModelType: modelType})
+
+	result := taskReadiness{
+		Task:     key,
+		Rule:     decision.Rule,
+		Target:   decision.Target,
+		Backends: []readinessBackend{},
+	}
+	if decision.Rule == "" {
+		result.Error = "no route"
+		return result
+	}
+
+	for _, backend := range decision.Candidates {
+		health := cfg.GetHealthStatus(backend.Name)
+		view := readinessBackend{
+			Name:   backend.Name,
+			Status: health.Status,
+			Error:  health.Error,
+		}
+		if view.Status == config.HealthStatusHealthy {
+			if failing := failingDeepCheck(backend.Name, task, modelType); failing != "" {
+				view.Error = "deep check " + failing + " fails"
+			} else {
+				view.Serving = true
+				result.Ready = true
+			}
+		}
+		result.Backends = append(result.Backends, view)
+	}
+	if !result.Ready {
+		result.Error = "no backend can serve the task"
+	}
+	return result
+}
+
+// failingDeepCheck returns the key of a failing deep check of the task on the
+// backend, covering all model types of the task if modelType is empty
+func failingDeepCheck(backendName, task, modelType string) string {
+	var failing []string
+	for key, health := range cfg.GetAllTaskHealth(backendName) {
+		if health.Status == config.HealthStatusHealthy {
+			continue
+		}
+		if checkTask, checkModelType, _ := strings.Cut(key, "/"); checkTask == task && (modelType == "" || checkModelType == modelType) {
+			failing = append(failing, key)
+		}
+	}
+	sort.Strings(failing)
+	return strings.Join(failing, ", ")
+}
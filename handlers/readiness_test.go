@@ -0,0 +1,136 @@
+package handlers
+
+import (
+	"immich_ml_proxy/config"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestShutdownReportsNotReady(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("pong"))
+	}))
+	defer backend.Close()
+	_, err := cfg.Replace("", config.Settings{
+		DefaultBackend: "gpu",
+		Backends:       []config.Backend{{Name: "gpu", URL: backend.URL}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.SetHealthStatus("gpu", config.HealthStatusHealthy, "")
+
+	r := gin.New()
+	r.GET("/ping", PingHandler)
+	r.GET("/readyz", ReadyzHandler)
+	r.GET("/livez", LivezHandler)
+	get := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		return w
+	}
+
+	for _, path := range []string{"/ping", "/readyz", "/livez"} {
+		if w := get(path); w.Code != http.StatusOK {
+			t.Fatalf("GET %s before shutdown: %d %s", path, w.Code, w.Body)
+		}
+	}
+
+	BeginShutdown()
+	defer shuttingDown.Store(false)
+	for _, path := range []string{"/ping", "/readyz"} {
+		if w := get(path); w.Code != http.StatusServiceUnavailable {
+			t.Errorf("GET %s during shutdown: %d, want 503", path, w.Code)
+		}
+	}
+	if w := get("/readyz"); !strings.Contains(w.Body.String(), "shutting down") {
+		t.Errorf("GET /readyz during shutdown: %q, want it to say shutting down", w.Body)
+	}
+	if w := get("/livez"); w.Code != http.StatusOK {
+		t.Errorf("GET /livez during shutdown: %d, want 200", w.Code)
+	}
+}
+
+func TestReadinessOf(t *testing.T) {
+	type task struct{ required, ready bool }
+	tests := []struct {
+		name        string
+		taskRouting map[string]string
+		required    []string
+		unhealthy   []string
+		ready       bool
+		tasks       map[string]task
+	}{
+		{"nothing routed", nil, nil, nil, true, map[string]task{"default": {true, true}}},
+		{"nothing routed, default down", nil, nil, []string{"cpu"}, false, map[string]task{"default": {true, false}}},
+		{
+			"all routed tasks required", map[string]string{"clip": "gpu", "ocr": "cpu"}, nil, []string{"gpu"}, false,
+			map[string]task{"clip": {true, false}, "ocr": {true, true}},
+		},
+		{
+			"only required tasks count", map[string]string{"clip": "gpu", "ocr": "cpu"}, []string{"ocr"}, []string{"gpu"}, true,
+			map[string]task{"clip": {false, false}, "ocr": {true, true}},
+		},
+		{
+			"required task served by the default backend", map[string]string{"clip": "gpu"}, []string{"facial-recognition.detection"}, []string{"gpu"}, true,
+			map[string]task{"clip": {false, false}, "facial-recognition.detection": {true, true}},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := cfg.Replace("", config.Settings{
+				DefaultBackend: "cpu",
+				Backends:       []config.Backend{{Name: "cpu", URL: "http://cpu:3003"}, {Name: "gpu", URL: "http://gpu:3003"}},
+				TaskRouting:    test.taskRouting,
+				Readiness:      &config.Readiness{RequiredTasks: test.required},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			cfg.SetHealthStatus("cpu", config.HealthStatusHealthy, "")
+			cfg.SetHealthStatus("gpu", config.HealthStatusHealthy, "")
+			for _, name := range test.unhealthy {
+				cfg.SetHealthStatus(name, config.HealthStatusUnhealthy, "down")
+			}
+
+			readiness := readinessOf(cfg.GetReadiness())
+			if readiness.Ready != test.ready {
+				t.Errorf("ready %v, want %v: %+v", readiness.Ready, test.ready, readiness.Tasks)
+			}
+			if len(readiness.Tasks) != len(test.tasks) {
+				t.Fatalf("tasks %+v, want %v", readiness.Tasks, test.tasks)
+			}
+			for _, got := range readiness.Tasks {
+				if want, ok := test.tasks[got.Task]; !ok || got.Required != want.required || got.Ready != want.ready {
+					t.Errorf("task %s required %v ready %v, want %+v", got.Task, got.Required, got.Ready, want)
+				}
+			}
+		})
+	}
+}
+
+func TestReadinessOfFailingDeepCheck(t *testing.T) {
+	_, err := cfg.Replace("", config.Settings{
+		DefaultBackend: "checked",
+		Backends:       []config.Backend{{Name: "checked", URL: "http://checked:3003"}},
+		TaskRouting:    map[string]string{"ocr": "checked"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.SetHealthStatus("checked", config.HealthStatusHealthy, "")
+	cfg.SetTaskHealth("checked", config.TaskKey("ocr", "recognition"), config.TaskHealth{Status: config.HealthStatusUnhealthy, Error: "bad output"})
+
+	readiness := readinessOf(cfg.GetReadiness())
+	if readiness.Ready || len(readiness.Tasks) != 1 {
+		t.Fatalf("readiness %+v, want ocr not ready", readiness)
+	}
+	backends := readiness.Tasks[0].Backends
+	if len(backends) != 1 || backends[0].Serving || !strings.Contains(backends[0].Error, "ocr/recognition") {
+		t.Errorf("backends %+v, want checked not serving because of its ocr/recognition deep check", backends)
+	}
+}
@@ -0,0 +1,343 @@
+package handlers
+
+import (
+	"errors"
+	"immich_ml_proxy/config"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// All write endpoints below require If-Match with the ETag of the whole config
+// (as returned by GET /api/config and every resource endpoint), so concurrent
+// edits by two admins fail with 412 instead of silently overwriting each other.
+// Writes without If-Match fail with 428; "*" explicitly skips the check.
+
+type routeRequest struct {
+	Backend string `json:"backend" binding:"required"`
+}
+
+// writeConfigError maps config update errors to HTTP status codes
+func writeConfigError(c *gin.Context, err error) {
+	status := http.StatusInternalServerError
+	var validationErr *config.ValidationError
+	switch {
+	case errors.Is(err, config.ErrPreconditionFailed):
+		status = http.StatusPreconditionFailed
+	case errors.Is(err, config.ErrPreconditionRequired):
+		status = http.StatusPreconditionRequired
+	case errors.Is(err, config.ErrExecHookForbidden):
+		status = http.StatusForbidden
+	case errors.Is(err, config.ErrBackendNotFound), errors.Is(err, config.ErrRouteNotFound):
+		status = http.StatusNotFound
+	case errors.Is(err, config.ErrReadOnly):
+		status = http.StatusConflict
+	case errors.As(err, &validationErr):
+		status = http.StatusBadRequest
+	}
+	c.JSON(status, gin.H{"error": err.Error()})
+}
+
+// requireIfMatch returns the If-Match header of a write, or responds with 428
+// and returns false if there is none
+func requireIfMatch(c *gin.Context) (string, bool) {
+	ifMatch := c.GetHeader("If-Match")
+	if ifMatch == "" {
+		writeConfigError(c, config.ErrPreconditionRequired)
+		return "", false
+	}
+	return ifMatch, true
+}
+
+// writeUpdated responds to a successful update with the new ETag
+func writeUpdated(c *gin.Context, etag string, body interface{}) {
+	c.Header("ETag", etag)
+	c.JSON(http.StatusOK, body)
+}
+
+// BackendsListHandler handles GET /api/backends - lists all backends
+func BackendsListHandler(c *gin.Context) {
+	c.Header("ETag", cfg.ETag())
+	c.JSON(http.StatusOK, cfg.GetBackends())
+}
+
+// BackendGetHandler handles GET /api/backends/:name - returns a single backend
+func BackendGetHandler(c *gin.Context) {
+	backend := cfg.GetBackend(c.Param("name"))
+	if backend == nil {
+		writeConfigError(c, config.ErrBackendNotFound)
+		return
+	}
+	c.Header("ETag", cfg.ETag())
+	c.JSON(http.StatusOK, backend)
+}
+
+// BackendPutHandler handles PUT /api/backends/:name - creates or replaces a backend
+func BackendPutHandler(c *gin.Context) {
+	ifMatch, ok := requireIfMatch(c)
+	if !ok {
+		return
+	}
+
+	var backend config.Backend
+	if err := c.ShouldBindJSON(&backend); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	name := c.Param("name")
+	if backend.Name != "" && backend.Name != name {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Backend name in body does not match the URL"})
+		return
+	}
+	backend.Name = name
+
+	etag, err := cfg.AddBackend(ifMatch, backend)
+	if err != nil {
+		writeConfigError(c, err)
+		return
+	}
+	writeUpdated(c, etag, backend)
+}
+
+// BackendDeleteHandler handles DELETE /api/backends/:name - removes a backend and its routes
+func BackendDeleteHandler(c *gin.Context) {
+	ifMatch, ok := requireIfMatch(c)
+	if !ok {
+		return
+	}
+
+	etag, err := cfg.RemoveBackend(ifMatch, c.Param("name"))
+	if err != nil {
+		writeConfigError(c, err)
+		return
+	}
+	writeUpdated(c, etag, gin.H{"message": "Backend removed"})
+}
+
+// backendStateView is the admin state of a backend with the requests it still has
+type backendStateView struct {
+	Name     string `json:"name"`
+	State    string `json:"state"`
+	InFlight int    `json:"inFlight"`
+	Queued   int    `json:"queued"`
+	Idle     bool   `json:"idle"` // no requests in flight or queued, safe to restart
+}
+
+func backendState(backend config.Backend) backendStateView {
+	load := slots.Stats(backend.Name)
+	return backendStateView{
+		Name:     backend.Name,
+		State:    backend.Admin(),
+		InFlight: load.InFlight,
+		Queued:   load.Queued,
+		Idle:     load.InFlight == 0 && load.Queued == 0,
+	}
+}
+
+// BackendStateGetHandler handles GET /api/backends/:name/state - returns the admin state and in-flight requests
+func BackendStateGetHandler(c *gin.Context) {
+	backend := cfg.GetBackend(c.Param("name"))
+	if backend == nil {
+		writeConfigError(c, config.ErrBackendNotFound)
+		return
+	}
+	c.Header("ETag", cfg.ETag())
+	c.JSON(http.StatusOK, backendState(*backend))
+}
+
+// BackendStatePutHandler handles PUT /api/backends/:name/state - sets the admin state
+// (active, draining or disabled) without touching the backend's routes
+func BackendStatePutHandler(c *gin.Context) {
+	ifMatch, ok := requireIfMatch(c)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		State string `json:"state" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	name := c.Param("name")
+	etag, err := cfg.SetAdminState(ifMatch, name, req.State)
+	if err != nil {
+		writeConfigError(c, err)
+		return
+	}
+	backend := cfg.GetBackend(name)
+	if backend == nil {
+		writeConfigError(c, config.ErrBackendNotFound)
+		return
+	}
+	writeUpdated(c, etag, backendState(*backend))
+}
+
+// TaskRoutesListHandler handles GET /api/routes/tasks - lists task routing
+func TaskRoutesListHandler(c *gin.Context) {
+	c.Header("ETag", cfg.ETag())
+	c.JSON(http.StatusOK, cfg.GetTaskRouting())
+}
+
+// TaskRouteGetHandler handles GET /api/routes/tasks/:task - returns the backend of a task
+func TaskRouteGetHandler(c *gin.Context) {
+	backendName, ok := cfg.GetTaskRouting()[c.Param("task")]
+	if !ok {
+		writeConfigError(c, config.ErrRouteNotFound)
+		return
+	}
+	c.Header("ETag", cfg.ETag())
+	c.JSON(http.StatusOK, routeRequest{Backend: backendName})
+}
+
+// TaskRoutePutHandler handles PUT /api/routes/tasks/:task - routes a task to a backend
+func TaskRoutePutHandler(c *gin.Context) {
+	ifMatch, ok := requireIfMatch(c)
+	if !ok {
+		return
+	}
+
+	var req routeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	etag, err := cfg.SetTaskRouting(ifMatch, c.Param("task"), req.Backend)
+	if err != nil {
+		writeConfigError(c, err)
+		return
+	}
+	writeUpdated(c, etag, req)
+}
+
+// TaskRouteDeleteHandler handles DELETE /api/routes/tasks/:task - removes a task route
+func TaskRouteDeleteHandler(c *gin.Context) {
+	ifMatch, ok := requireIfMatch(c)
+	if !ok {
+		return
+	}
+
+	etag, err := cfg.RemoveTaskRouting(ifMatch, c.Param("task"))
+	if err != nil {
+		writeConfigError(c, err)
+		return
+	}
+	writeUpdated(c, etag, gin.H{"message": "Route removed"})
+}
+
+// ModelTypeRoutesListHandler handles GET /api/routes/model-types - lists modelType routing
+func ModelTypeRoutesListHandler(c *gin.Context) {
+	c.Header("ETag", cfg.ETag())
+	c.JSON(http.StatusOK, cfg.GetModelTypeRouting())
+}
+
+// ModelTypeRouteGetHandler handles GET /api/routes/model-types/:type - returns the backend of a modelType
+func ModelTypeRouteGetHandler(c *gin.Context) {
+	backendName, ok := cfg.GetModelTypeRouting()[c.Param("type")]
+	if !ok {
+		writeConfigError(c, config.ErrRouteNotFound)
+		return
+	}
+	c.Header("ETag", cfg.ETag())
+	c.JSON(http.StatusOK, routeRequest{Backend: backendName})
+}
+
+// ModelTypeRoutePutHandler handles PUT /api/routes/model-types/:type - routes a modelType to a backend
+func ModelTypeRoutePutHandler(c *gin.Context) {
+	ifMatch, ok := requireIfMatch(c)
+	if !ok {
+		return
+	}
+
+	var req routeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	etag, err := cfg.SetModelTypeRouting(ifMatch, c.Param("type"), req.Backend)
+	if err != nil {
+		writeConfigError(c, err)
+		return
+	}
+	writeUpdated(c, etag, req)
+}
+
+// ModelTypeRouteDeleteHandler handles DELETE /api/routes/model-types/:type - removes a modelType route
+func ModelTypeRouteDeleteHandler(c *gin.Context) {
+	ifMatch, ok := requireIfMatch(c)
+	if !ok {
+		return
+	}
+
+	etag, err := cfg.RemoveModelTypeRouting(ifMatch, c.Param("type"))
+	if err != nil {
+		writeConfigError(c, err)
+		return
+	}
+	writeUpdated(c, etag, gin.H{"message": "Route removed"})
+}
+
+// DefaultRouteGetHandler handles GET /api/routes/default - returns the default backend
+func DefaultRouteGetHandler(c *gin.Context) {
+	backend := cfg.GetDefaultBackend()
+	if backend == nil {
+		writeConfigError(c, config.ErrRouteNotFound)
+		return
+	}
+	c.Header("ETag", cfg.ETag())
+	c.JSON(http.StatusOK, routeRequest{Backend: backend.Name})
+}
+
+// DefaultRoutePutHandler handles PUT /api/routes/default - sets the default backend
+func DefaultRoutePutHandler(c *gin.Context) {
+	ifMatch, ok := requireIfMatch(c)
+	if !ok {
+		return
+	}
+
+	var req routeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	etag, err := cfg.SetDefaultBackend(ifMatch, req.Backend)
+	if err != nil {
+		writeConfigError(c, err)
+		return
+	}
+	writeUpdated(c, etag, req)
+}
+
+// ConfigPatchHandler handles PATCH /api/config - applies a JSON merge patch (RFC 7396)
+func ConfigPatchHandler(c *gin.Context) {
+	ifMatch, ok := requireIfMatch(c)
+	if !ok {
+		return
+	}
+
+	var patch map[string]interface{}
+	if err := c.ShouldBindJSON(&patch); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	etag, err := cfg.Patch(ifMatch, patch)
+	if err != nil {
+		writeConfigError(c, err)
+		return
+	}
+
+	data, err := cfg.ToJSON()
+	if err != nil {
+		writeConfigError(c, err)
+		return
+	}
+	c.Header("ETag", etag)
+	c.Data(http.StatusOK, "application/json", data)
+}
@@ -0,0 +1,63 @@
+package handlers
+
+import (
+	"immich_ml_proxy/config"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestResourceWritesRequireIfMatch(t *testing.T) {
+	_, err := cfg.Replace("", config.Settings{
+		DefaultBackend: "gpu",
+		Backends:       []config.Backend{{Name: "gpu", URL: "http://gpu:3003"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := gin.New()
+	r.PUT("/api/backends/:name", BackendPutHandler)
+	r.DELETE("/api/backends/:name", BackendDeleteHandler)
+	r.PUT("/api/routes/tasks/:task", TaskRoutePutHandler)
+	r.PATCH("/api/config", ConfigPatchHandler)
+
+	send := func(method, path, ifMatch, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		if ifMatch != "" {
+			req.Header.Set("If-Match", ifMatch)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	writes := []struct {
+		method, path, body string
+	}{
+		{http.MethodPut, "/api/backends/cpu", `{"url": "http://cpu:3003"}`},
+		{http.MethodDelete, "/api/backends/gpu", ""},
+		{http.MethodPut, "/api/routes/tasks/ocr", `{"backend": "gpu"}`},
+		{http.MethodPatch, "/api/config", `{"taskRouting": {"ocr": "gpu"}}`},
+	}
+	for _, write := range writes {
+		if w := send(write.method, write.path, "", write.body); w.Code != http.StatusPreconditionRequired {
+			t.Errorf("%s %s without If-Match: %d, want 428", write.method, write.path, w.Code)
+		}
+		if w := send(write.method, write.path, `"stale"`, write.body); w.Code != http.StatusPreconditionFailed {
+			t.Errorf("%s %s with a stale ETag: %d, want 412", write.method, write.path, w.Code)
+		}
+	}
+
+	w := send(http.MethodPut, "/api/backends/cpu", cfg.ETag(), `{"url": "http://cpu:3003"}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("PUT with the current ETag: %d %s", w.Code, w.Body)
+	}
+	if w.Header().Get("ETag") != cfg.ETag() {
+		t.Errorf("ETag %s in the response, want the new ETag %s", w.Header().Get("ETag"), cfg.ETag())
+	}
+}
@@ -0,0 +1,27 @@
+package handlers
+
+import (
+	"immich_ml_proxy/scheduler"
+	"immich_ml_proxy/stats"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// backendStatsView combines the request stats and current load of a backend
+type backendStatsView struct {
+	stats.BackendStats
+	scheduler.QueueStats
+}
+
+// StatsAPIGetHandler handles GET /api/stats - returns request stats, load and concurrency limits of all backends
+func StatsAPIGetHandler(c *gin.Context) {
+	result := make(map[string]backendStatsView)
+	for _, backend := range cfg.GetBackends() {
+		result[backend.Name] = backendStatsView{
+			BackendStats: stats.GetInstance().Get(backend.Name),
+			QueueStats:   slots.Stats(backend.Name),
+		}
+	}
+	c.JSON(http.StatusOK, result)
+}
@@ -0,0 +1,210 @@
+package keepwarm
+
+import (
+	"context"
+	"immich_ml_proxy/config"
+	"immich_ml_proxy/deepcheck"
+	"immich_ml_proxy/lifecycle"
+	"log"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	defaultInterval = 4 * time.Minute
+	defaultTimeout  = 2 * time.Minute
+	checkInterval   = 5 * time.Second // how often due warm-ups are looked for
+)
+
+// Targets returns the backends a model is kept warm on
+type Targets func(model config.TaskModel) []config.Backend
+
+// Warmup is the last keep-warm request of a model on a backend
+type Warmup struct {
+	Backend   string    `json:"backend"`
+	Model     string    `json:"model"`  // task/modelType/modelName
+	Reason    string    `json:"reason"` // startup, interval or healthy
+	Time      time.Time `json:"time"`
+	LatencyMs int64     `json:"latencyMs"`
+	Error     string    `json:"error,omitempty"`
+}
+
+// Warmer keeps models loaded on the backends by sending them minimal predict
+// requests. The requests bypass stats, queues and lifecycle tracking, so they
+// neither count as traffic nor keep backends with an idle timeout running.
+type Warmer struct {
+	mu      sync.Mutex
+	last    map[string]*Warmup     // backend + model -> last warm-up
+	running map[string]bool        // backend + model -> a warm-up is in flight
+	seen    map[string]backendSeen // backend name -> health when last looked at
+}
+
+// backendSeen is the health of a backend when the warmer last looked at it
+type backendSeen struct {
+	status       config.HealthStatus
+	healthySince time.Time
+}
+
+var (
+	instance *Warmer
+	once     sync.Once
+)
+
+// GetInstance returns the singleton Warmer
+func GetInstance() *Warmer {
+	once.Do(func() {
+		instance = &Warmer{
+			last:    make(map[string]*Warmup),
+			running: make(map[string]bool),
+			seen:    make(map[string]backendSeen),
+		}
+	})
+	return instance
+}
+
+// Warmups returns the last warm-up of every model on every backend
+func (w *Warmer) Warmups() []Warmup {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	result := []Warmup{}
+	for _, warmup := range w.last {
+		result = append(result, *warmup)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Backend != result[j].Backend {
+			return result[i].Backend < result[j].Backend
+		}
+		return result[i].Model < result[j].Model
+	})
+	return result
+}
+
+// Run warms the configured models on their backends when the proxy starts,
+// when a backend turns healthy and at the configured interval, while a
+// keep-warm schedule is active, until ctx is done
+func (w *Warmer) Run(ctx context.Context, cfg *config.Config, targets Targets) {
+	var warmups sync.WaitGroup
+	defer warmups.Wait()
+
+	ticker := time.NewTicker(checkInterval)
+	defer ticker.Stop()
+	for {
+		if settings := cfg.GetKeepWarm(); settings != nil && cfg.KeepWarmActive() {
+			turnedHealthy := w.turnedHealthy(cfg)
+			current := make(map[string]bool)
+			for _, model := range settings.Models {
+				for _, backend := range targets(model) {
+					current[backend.Name+"/"+model.Key()] = true
+					reason, due := w.due(cfg, *settings, model, backend, turnedHealthy[backend.Name])
+					if !due {
+						continue
+					}
+					warmups.Add(1)
+					go func(model config.TaskModel, backend config.Backend, reason string) {
+						defer warmups.Done()
+						w.warm(ctx, *settings, model, backend, reason)
+					}(model, backend, reason)
+				}
+			}
+			w.prune(current)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// turnedHealthy returns the backends that turned healthy since the last look.
+// The first health check after the proxy starts does not count, the startup
+// warm-up covers it.
+func (w *Warmer) turnedHealthy(cfg *config.Config) map[string]bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	result := make(map[string]bool)
+	for _, backend := range cfg.GetBackends() {
+		now := backendSeen{status: cfg.GetHealthStatus(backend.Name).Status}
+		now.healthySince, _ = cfg.HealthySince(backend.Name)
+		previous, ok := w.seen[backend.Name]
+		if ok && previous.status != config.HealthStatusUnknown && now.healthySince.After(previous.healthySince) {
+			result[backend.Name] = true
+		}
+		w.seen[backend.Name] = now
+	}
+	return result
+}
+
+// due reports whether a model should be warmed on a backend now and why, and
+// marks the warm-up as running if so
+func (w *Warmer) due(cfg *config.Config, settings config.KeepWarm, model config.TaskModel, backend config.Backend, turnedHealthy bool) (string, bool) {
+	// Backends that are down or asleep are not woken up just to keep them warm
+	status := cfg.GetHealthStatus(backend.Name).Status
+	if status == config.HealthStatusUnhealthy || (lifecycle.Managed(backend) && status != config.HealthStatusHealthy) {
+		return "", false
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	key := backend.Name + "/" + model.Key()
+	if w.running[key] {
+		return "", false
+	}
+
+	var reason string
+	last, warmed := w.last[key]
+	switch {
+	case !warmed:
+		reason = "startup"
+	case turnedHealthy:
+		reason = "healthy"
+	case time.Since(last.Time) >= settings.Interval.Or(defaultInterval):
+		reason = "interval"
+	default:
+		return "", false
+	}
+	w.running[key] = true
+	return reason, true
+}
+
+// prune forgets warm-ups of models and backends that are no longer kept warm
+func (w *Warmer) prune(current map[string]bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for key := range w.last {
+		if !current[key] {
+			delete(w.last, key)
+		}
+	}
+}
+
+// warm sends one keep-warm request and records the outcome
+func (w *Warmer) warm(ctx context.Context, settings config.KeepWarm, model config.TaskModel, backend config.Backend, reason string) {
+	key := backend.Name + "/" + model.Key()
+	warmup := &Warmup{Backend: backend.Name, Model: model.Key(), Reason: reason, Time: time.Now()}
+
+	requestCtx, cancel := context.WithTimeout(ctx, settings.Timeout.Or(defaultTimeout))
+	defer cancel()
+	err := deepcheck.Check(requestCtx, config.DeepCheck{
+		Task:      model.Task,
+		ModelType: model.ModelType,
+		ModelName: model.ModelName,
+		Options:   model.Options,
+	}, backend)
+	warmup.LatencyMs = time.Since(warmup.Time).Milliseconds()
+	if err != nil {
+		warmup.Error = err.Error()
+		if ctx.Err() == nil {
+			log.Printf("Keep-warm of %s on backend %s failed: %v", model.Key(), backend.Name, err)
+		}
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	delete(w.running, key)
+	if ctx.Err() == nil {
+		w.last[key] = warmup
+	}
+}
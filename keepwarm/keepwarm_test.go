@@ -0,0 +1,143 @@
+package keepwarm
+
+import (
+	"context"
+	"immich_ml_proxy/config"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestMain(m *testing.M) {
+	dir, err := os.MkdirTemp("", "keepwarm")
+	if err != nil {
+		panic(err)
+	}
+	config.UseStore(config.NewFileStore(filepath.Join(dir, "config.json")))
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+func newWarmer() *Warmer {
+	return &Warmer{
+		last:    make(map[string]*Warmup),
+		running: make(map[string]bool),
+		seen:    make(map[string]backendSeen),
+	}
+}
+
+var clipVisual = config.TaskModel{Task: "clip", ModelType: "visual", ModelName: "ViT-B-32__openai"}
+
+func TestDue(t *testing.T) {
+	cfg := config.Load()
+	settings := config.KeepWarm{Interval: config.Duration(time.Minute), Models: []config.TaskModel{clipVisual}}
+	managed := &config.Lifecycle{Start: &config.Hook{Command: []string{"true"}}}
+
+	tests := []struct {
+		name          string
+		backend       config.Backend
+		status        config.HealthStatus
+		lastWarmed    time.Duration // how long ago, 0 if never
+		running       bool
+		turnedHealthy bool
+		reason        string // empty if not due
+	}{
+		{"never warmed", config.Backend{Name: "startup"}, config.HealthStatusHealthy, 0, false, false, "startup"},
+		{"health unknown", config.Backend{Name: "unknown"}, config.HealthStatusUnknown, 0, false, false, "startup"},
+		{"warmed recently", config.Backend{Name: "recent"}, config.HealthStatusHealthy, 10 * time.Second, false, false, ""},
+		{"interval passed", config.Backend{Name: "interval"}, config.HealthStatusHealthy, 2 * time.Minute, false, false, "interval"},
+		{"turned healthy", config.Backend{Name: "recovered"}, config.HealthStatusHealthy, 10 * time.Second, false, true, "healthy"},
+		{"already running", config.Backend{Name: "running"}, config.HealthStatusHealthy, 0, true, false, ""},
+		{"unhealthy", config.Backend{Name: "down"}, config.HealthStatusUnhealthy, 0, false, false, ""},
+		{"managed and asleep", config.Backend{Name: "asleep", Lifecycle: managed}, config.HealthStatusUnknown, 0, false, false, ""},
+		{"managed and awake", config.Backend{Name: "awake", Lifecycle: managed}, config.HealthStatusHealthy, 0, false, false, "startup"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if test.status != config.HealthStatusUnknown {
+				cfg.SetHealthStatus(test.backend.Name, test.status, "")
+			}
+			w := newWarmer()
+			key := test.backend.Name + "/" + clipVisual.Key()
+			if test.lastWarmed > 0 {
+				w.last[key] = &Warmup{Time: time.Now().Add(-test.lastWarmed)}
+			}
+			w.running[key] = test.running
+
+			reason, due := w.due(cfg, settings, clipVisual, test.backend, test.turnedHealthy)
+			if reason != test.reason || due != (test.reason != "") {
+				t.Fatalf("due %v with reason %q, want %q", due, reason, test.reason)
+			}
+			if due && !w.running[key] {
+				t.Error("due warm-up not marked as running")
+			}
+		})
+	}
+}
+
+func TestTurnedHealthy(t *testing.T) {
+	cfg := config.Load()
+	_, err := cfg.Replace("", config.Settings{
+		DefaultBackend: "gpu",
+		Backends:       []config.Backend{{Name: "gpu", URL: "http://gpu:3003"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := newWarmer()
+
+	// The first health check after startup is covered by the startup warm-up
+	cfg.SetHealthStatus("gpu", config.HealthStatusHealthy, "")
+	if w.turnedHealthy(cfg)["gpu"] {
+		t.Error("first look counted as turning healthy")
+	}
+	if w.turnedHealthy(cfg)["gpu"] {
+		t.Error("staying healthy counted as turning healthy")
+	}
+
+	cfg.SetHealthStatus("gpu", config.HealthStatusUnhealthy, "down")
+	w.turnedHealthy(cfg)
+	time.Sleep(time.Millisecond)
+	cfg.SetHealthStatus("gpu", config.HealthStatusHealthy, "")
+	if !w.turnedHealthy(cfg)["gpu"] {
+		t.Error("recovery not counted as turning healthy")
+	}
+}
+
+func TestWarmRecordsOutcome(t *testing.T) {
+	status := http.StatusOK
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+		w.Write([]byte(`{"clip": "[0.1]"}`))
+	}))
+	defer backend.Close()
+	gpu := config.Backend{Name: "gpu", URL: backend.URL}
+	key := gpu.Name + "/" + clipVisual.Key()
+
+	w := newWarmer()
+	w.running[key] = true
+	w.warm(context.Background(), config.KeepWarm{}, clipVisual, gpu, "startup")
+	warmups := w.Warmups()
+	if len(warmups) != 1 || warmups[0].Reason != "startup" || warmups[0].Error != "" {
+		t.Fatalf("warm-ups %+v, want one successful startup warm-up", warmups)
+	}
+	if w.running[key] {
+		t.Error("warm-up still marked as running")
+	}
+
+	status = http.StatusInternalServerError
+	w.warm(context.Background(), config.KeepWarm{}, clipVisual, gpu, "interval")
+	if warmups := w.Warmups(); warmups[0].Reason != "interval" || warmups[0].Error == "" {
+		t.Errorf("warm-ups %+v, want the failed interval warm-up", warmups)
+	}
+
+	// Warm-ups of models that are no longer kept warm are forgotten
+	w.prune(map[string]bool{})
+	if warmups := w.Warmups(); len(warmups) != 0 {
+		t.Errorf("warm-ups %+v after pruning, want none", warmups)
+	}
+}
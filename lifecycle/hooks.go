@@ -0,0 +1,68 @@
+package lifecycle
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"immich_ml_proxy/config"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+const defaultHookTimeout = 30 * time.Second
+
+// runHook runs a command hook or makes an HTTP hook call for the backend
+func runHook(ctx context.Context, hook *config.Hook, backend config.Backend) error {
+	ctx, cancel := context.WithTimeout(ctx, hook.Timeout.Or(defaultHookTimeout))
+	defer cancel()
+
+	if len(hook.Command) > 0 {
+		return runCommand(ctx, hook.Command, backend)
+	}
+	return callURL(ctx, hook, backend)
+}
+
+func runCommand(ctx context.Context, command []string, backend config.Backend) error {
+	cmd := exec.CommandContext(ctx, command[0], command[1:]...)
+	cmd.Env = append(os.Environ(), "BACKEND_NAME="+backend.Name, "BACKEND_URL="+backend.URL)
+	var output bytes.Buffer
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+	if err := cmd.Run(); err != nil {
+		if out := strings.TrimSpace(output.String()); out != "" {
+			return fmt.Errorf("%s: %w: %s", command[0], err, out)
+		}
+		return fmt.Errorf("%s: %w", command[0], err)
+	}
+	return nil
+}
+
+func callURL(ctx context.Context, hook *config.Hook, backend config.Backend) error {
+	method := hook.Method
+	if method == "" {
+		method = http.MethodPost
+	}
+	req, err := http.NewRequestWithContext(ctx, method, hook.URL, nil)
+	if err != nil {
+		return err
+	}
+	for key, value := range hook.Headers {
+		req.Header.Set(key, value)
+	}
+	req.Header.Set("X-Backend-Name", backend.Name)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("%s %s: unexpected response: %s %s", method, hook.URL, resp.Status, strings.TrimSpace(string(body)))
+	}
+	return nil
+}
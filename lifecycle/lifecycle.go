@@ -0,0 +1,389 @@
+package lifecycle
+
+import (
+	"context"
+	"fmt"
+	"immich_ml_proxy/config"
+	"immich_ml_proxy/proxy"
+	"log"
+	"sync"
+	"time"
+)
+
+const (
+	defaultWakeTimeout  = 60 * time.Second
+	defaultStartTimeout = 2 * time.Minute
+	startPollInterval   = 2 * time.Second
+	wakeResendInterval  = 15 * time.Second // magic packets are not acknowledged and may get lost
+	idleCheckInterval   = 5 * time.Second
+	maxEvents           = 200
+)
+
+// State is the lifecycle state of a backend
+type State string
+
+const (
+	StateStopped  State = "stopped"  // not running (or asleep), started on the next request
+	StateStarting State = "starting" // start hook or wake-on-LAN sent, waiting for /ping
+	StateReady    State = "ready"    // serving requests
+	StateIdle     State = "idle"     // running without requests in flight
+)
+
+// Event is a start or stop of a backend
+type Event struct {
+	Backend    string    `json:"backend"`
+	Type       string    `json:"type"` // start, started, start-failed, stop, stop-failed
+	Time       time.Time `json:"time"`
+	DurationMs int64     `json:"durationMs,omitempty"` // started: time to come up; stop: time the backend sat idle
+	Error      string    `json:"error,omitempty"`
+}
+
+// BackendState is the lifecycle of one backend as reported by the API
+type BackendState struct {
+	State       State     `json:"state"`
+	Since       time.Time `json:"since"`
+	InFlight    int       `json:"inFlight"`
+	LastRequest time.Time `json:"lastRequest,omitempty"`
+	Starts      int       `json:"starts"`
+	Stops       int       `json:"stops"`
+	IdleMs      int64     `json:"idleMs"` // total time spent idle
+}
+
+// startup is a start attempt that requests for the same backend wait on together
+type startup struct {
+	done chan struct{}
+	err  error
+}
+
+type backendState struct {
+	BackendState
+	starting *startup
+	stopping chan struct{} // closed when a running stop hook is done
+}
+
+// Manager starts backends on demand, stops idle backends and keeps track of
+// their lifecycle state
+type Manager struct {
+	mu       sync.Mutex
+	backends map[string]*backendState // backend name -> state
+	events   []Event                  // most recent last
+}
+
+var (
+	instance *Manager
+	once     sync.Once
+)
+
+// GetInstance returns the singleton Manager
+func GetInstance() *Manager {
+	once.Do(func() {
+		instance = &Manager{
+			backends: make(map[string]*backendState),
+		}
+	})
+	return instance
+}
+
+// Managed reports whether the backend is woken up or started on demand
+func Managed(backend config.Backend) bool {
+	return backend.WakeOnLAN != nil || backend.Lifecycle != nil
+}
+
+// Start starts the backend with its start hook, or wakes it with wake-on-LAN,
+// and waits until it answers /ping or the start timeout passes. Requests for a
+// backend that is already starting wait for the same attempt. The attempt
+// continues if ctx is done, only the wait ends.
+func (m *Manager) Start(ctx context.Context, backend config.Backend) error {
+	if !Managed(backend) {
+		return fmt.Errorf("backend %s has no wake-on-LAN or lifecycle settings", backend.Name)
+	}
+
+	m.mu.Lock()
+	bs := m.backend(backend.Name)
+	s := bs.starting
+	if s == nil {
+		s = &startup{done: make(chan struct{})}
+		bs.starting = s
+		m.setState(bs, StateStarting)
+		bs.Starts++
+		m.record(Event{Backend: backend.Name, Type: "start", Time: time.Now()})
+		go m.start(backend, s, bs.stopping)
+	}
+	m.mu.Unlock()
+
+	select {
+	case <-s.done:
+		return s.err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Begin marks a request to the backend as in flight, which keeps an idle
+// backend from being stopped; call the returned function when it is done. A
+// stopped or starting backend keeps its state until it is up.
+func (m *Manager) Begin(backendName string) func() {
+	m.mu.Lock()
+	bs := m.backend(backendName)
+	bs.InFlight++
+	bs.LastRequest = time.Now()
+	if bs.State == StateIdle {
+		m.setState(bs, StateReady)
+	}
+	m.mu.Unlock()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			m.mu.Lock()
+			defer m.mu.Unlock()
+			bs.InFlight--
+			bs.LastRequest = time.Now()
+			if bs.InFlight == 0 && bs.State == StateReady {
+				m.setState(bs, StateIdle)
+			}
+		})
+	}
+}
+
+// Get returns the lifecycle state of a backend
+func (m *Manager) Get(backendName string) BackendState {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	bs, ok := m.backends[backendName]
+	if !ok {
+		return BackendState{}
+	}
+	return bs.snapshot()
+}
+
+// Events returns the recorded start and stop events, oldest first
+func (m *Manager) Events() []Event {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]Event{}, m.events...)
+}
+
+// Run stops backends that have been idle for longer than their idle timeout
+// and follows backends that are started or stopped outside the proxy, until
+// ctx is done
+func (m *Manager) Run(ctx context.Context, cfg *config.Config) {
+	ticker := time.NewTicker(idleCheckInterval)
+	defer ticker.Stop()
+	for {
+		for _, backend := range cfg.GetBackends() {
+			if Managed(backend) {
+				m.check(cfg, backend)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// check updates the state of one backend from its health and stops it if it is idle
+func (m *Manager) check(cfg *config.Config, backend config.Backend) {
+	healthy := cfg.GetHealthStatus(backend.Name).Status == config.HealthStatusHealthy
+
+	m.mu.Lock()
+	bs, known := m.backends[backend.Name]
+	if !known {
+		bs = m.backend(backend.Name)
+		bs.LastRequest = time.Now() // the idle timeout starts with the proxy
+	}
+	switch {
+	case bs.State == StateStopped && healthy:
+		// Started outside the proxy
+		m.setState(bs, bs.runningState())
+		m.mu.Unlock()
+		return
+	case bs.State != StateIdle || bs.InFlight > 0:
+		m.mu.Unlock()
+		return
+	}
+
+	lc := backend.Lifecycle
+	idle := time.Since(bs.LastRequest)
+	if lc == nil || lc.Stop == nil || lc.IdleTimeout <= 0 || idle < lc.IdleTimeout.Std() {
+		m.mu.Unlock()
+		return
+	}
+
+	// Requests arriving from now on start the backend again once the stop hook is done
+	stopping := make(chan struct{})
+	bs.stopping = stopping
+	m.setState(bs, StateStopped)
+	bs.Stops++
+	m.mu.Unlock()
+
+	log.Printf("Stopping backend %s after %s idle", backend.Name, idle.Round(time.Second))
+	cfg.SetHealthStatus(backend.Name, config.HealthStatusUnhealthy, "stopped after idle timeout")
+	err := runHook(context.Background(), lc.Stop, backend)
+
+	m.mu.Lock()
+	event := Event{Backend: backend.Name, Type: "stop", Time: time.Now(), DurationMs: idle.Milliseconds()}
+	if err != nil {
+		event.Type = "stop-failed"
+		event.Error = err.Error()
+		log.Printf("Failed to stop backend %s: %v", backend.Name, err)
+	}
+	m.record(event)
+	if bs.stopping == stopping {
+		bs.stopping = nil
+	}
+	m.mu.Unlock()
+	close(stopping)
+}
+
+// start runs one start attempt
+func (m *Manager) start(backend config.Backend, s *startup, stopping chan struct{}) {
+	begin := time.Now()
+	if stopping != nil {
+		<-stopping
+	}
+
+	var err error
+	if backend.Lifecycle != nil {
+		err = m.startWithHook(backend)
+	} else {
+		err = m.wake(backend)
+	}
+
+	m.mu.Lock()
+	bs := m.backend(backend.Name)
+	event := Event{Backend: backend.Name, Type: "started", Time: time.Now(), DurationMs: time.Since(begin).Milliseconds()}
+	if err != nil {
+		event.Type = "start-failed"
+		event.Error = err.Error()
+		m.setState(bs, StateStopped)
+		log.Printf("Start failed: %v", err)
+	} else {
+		bs.LastRequest = time.Now()
+		m.setState(bs, bs.runningState())
+		log.Printf("Backend %s is up after %s", backend.Name, time.Since(begin).Round(time.Millisecond))
+	}
+	m.record(event)
+	bs.starting = nil
+	s.err = err
+	m.mu.Unlock()
+	close(s.done)
+}
+
+// startWithHook runs the start hook and waits for the backend to answer /ping
+func (m *Manager) startWithHook(backend config.Backend) error {
+	lc := backend.Lifecycle
+	timeout := lc.StartTimeout.Or(defaultStartTimeout)
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	log.Printf("Starting backend %s", backend.Name)
+	if err := runHook(ctx, lc.Start, backend); err != nil {
+		return fmt.Errorf("starting backend %s: %w", backend.Name, err)
+	}
+	if !waitHealthy(ctx, backend, nil) {
+		return fmt.Errorf("backend %s did not start within %s", backend.Name, timeout)
+	}
+	return nil
+}
+
+// wake sends wake-on-LAN packets until the backend answers /ping
+func (m *Manager) wake(backend config.Backend) error {
+	settings := backend.WakeOnLAN
+	timeout := settings.WakeTimeout.Or(defaultWakeTimeout)
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	var lastSent time.Time
+	var sendErr error
+	send := func() bool {
+		if time.Since(lastSent) < wakeResendInterval {
+			return true
+		}
+		if sendErr = SendMagicPacket(settings.MAC, settings.BroadcastAddress()); sendErr != nil {
+			return false
+		}
+		if lastSent.IsZero() {
+			log.Printf("Sent wake-on-LAN packet for backend %s to %s", backend.Name, settings.BroadcastAddress())
+		}
+		lastSent = time.Now()
+		return true
+	}
+
+	if !waitHealthy(ctx, backend, send) {
+		if sendErr != nil {
+			return fmt.Errorf("sending wake-on-LAN packet to backend %s: %w", backend.Name, sendErr)
+		}
+		return fmt.Errorf("backend %s did not wake up within %s", backend.Name, timeout)
+	}
+	return nil
+}
+
+// waitHealthy polls the backend's /ping until it is healthy or ctx is done.
+// before is called ahead of every poll and aborts the wait by returning false.
+func waitHealthy(ctx context.Context, backend config.Backend, before func() bool) bool {
+	for {
+		if before != nil && !before() {
+			return false
+		}
+		if proxy.CheckBackendHealth(backend).Status == "healthy" {
+			return true
+		}
+		select {
+		case <-ctx.Done():
+			return false
+		case <-time.After(startPollInterval):
+		}
+	}
+}
+
+// backend returns the state of a backend, creating it as stopped. Callers must hold mu.
+func (m *Manager) backend(backendName string) *backendState {
+	bs, ok := m.backends[backendName]
+	if !ok {
+		bs = &backendState{BackendState: BackendState{State: StateStopped, Since: time.Now()}}
+		m.backends[backendName] = bs
+	}
+	return bs
+}
+
+// setState moves a backend to a new state, adding up idle time. Callers must hold mu.
+func (m *Manager) setState(bs *backendState, state State) {
+	if bs.State == state {
+		return
+	}
+	if bs.State == StateIdle {
+		bs.IdleMs += time.Since(bs.Since).Milliseconds()
+	}
+	bs.State = state
+	bs.Since = time.Now()
+}
+
+// record appends an event, dropping the oldest beyond maxEvents. Callers must hold mu.
+func (m *Manager) record(event Event) {
+	m.events = append(m.events, event)
+	if len(m.events) > maxEvents {
+		m.events = m.events[len(m.events)-maxEvents:]
+	}
+}
+
+// runningState is the state of a running backend: ready while requests are
+// in flight, else idle. Callers must hold mu.
+func (bs *backendState) runningState() State {
+	if bs.InFlight > 0 {
+		return StateReady
+	}
+	return StateIdle
+}
+
+// snapshot returns the state with idle time up to now. Callers must hold mu.
+func (bs *backendState) snapshot() BackendState {
+	result := bs.BackendState
+	if bs.State == StateIdle {
+		result.IdleMs += time.Since(bs.Since).Milliseconds()
+	}
+	return result
+}
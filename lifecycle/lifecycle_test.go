@@ -0,0 +1,153 @@
+package lifecycle
+
+import (
+	"context"
+	"immich_ml_proxy/config"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// sleepingBackend is a fake backend that answers /ping only after it received
+// a wake-on-LAN packet, if wakes is set
+type sleepingBackend struct {
+	server  *httptest.Server
+	udp     net.PacketConn
+	packets atomic.Int32
+	awake   atomic.Bool
+}
+
+func newSleepingBackend(t *testing.T, wakes bool) *sleepingBackend {
+	t.Helper()
+	b := &sleepingBackend{}
+	b.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/ping" || !b.awake.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte("pong"))
+	}))
+	t.Cleanup(b.server.Close)
+
+	udp, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { udp.Close() })
+	b.udp = udp
+	go func() {
+		buf := make([]byte, 1024)
+		for {
+			if _, _, err := udp.ReadFrom(buf); err != nil {
+				return
+			}
+			b.packets.Add(1)
+			if wakes {
+				b.awake.Store(true)
+			}
+		}
+	}()
+	return b
+}
+
+func (b *sleepingBackend) config(name string, wakeTimeout time.Duration) config.Backend {
+	return config.Backend{
+		Name: name,
+		URL:  b.server.URL,
+		WakeOnLAN: &config.WakeOnLAN{
+			MAC:         "aa:bb:cc:dd:ee:ff",
+			Broadcast:   b.udp.LocalAddr().String(),
+			WakeTimeout: config.Duration(wakeTimeout),
+		},
+	}
+}
+
+// startConcurrently calls Start n times at once and returns the errors
+func startConcurrently(m *Manager, backend config.Backend, n int) []error {
+	errs := make([]error, n)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs[i] = m.Start(context.Background(), backend)
+		}(i)
+	}
+	wg.Wait()
+	return errs
+}
+
+func TestStartSharesOneAttempt(t *testing.T) {
+	fake := newSleepingBackend(t, true)
+	backend := fake.config("sleepy", 10*time.Second)
+	m := &Manager{backends: make(map[string]*backendState)}
+
+	for i, err := range startConcurrently(m, backend, 5) {
+		if err != nil {
+			t.Errorf("start %d: %v", i, err)
+		}
+	}
+	if n := fake.packets.Load(); n != 1 {
+		t.Errorf("%d magic packets sent, want 1", n)
+	}
+	state := m.Get("sleepy")
+	if state.Starts != 1 {
+		t.Errorf("%d start attempts, want 1", state.Starts)
+	}
+	if state.State != StateIdle {
+		t.Errorf("state is %s, want %s", state.State, StateIdle)
+	}
+}
+
+func TestStartFailsAfterWakeTimeout(t *testing.T) {
+	fake := newSleepingBackend(t, false)
+	backend := fake.config("sleepy", 300*time.Millisecond)
+	m := &Manager{backends: make(map[string]*backendState)}
+
+	begin := time.Now()
+	errs := startConcurrently(m, backend, 5)
+	if elapsed := time.Since(begin); elapsed < 300*time.Millisecond || elapsed > 5*time.Second {
+		t.Errorf("start failed after %s, want after the 300ms wake timeout", elapsed)
+	}
+	for i, err := range errs {
+		if err == nil || err.Error() != errs[0].Error() {
+			t.Errorf("start %d: got %v, want the shared wake timeout error", i, err)
+		}
+	}
+	if n := fake.packets.Load(); n != 1 {
+		t.Errorf("%d magic packets sent, want 1", n)
+	}
+
+	state := m.Get("sleepy")
+	if state.Starts != 1 || state.State != StateStopped {
+		t.Errorf("got %d starts in state %s, want 1 start in state %s", state.Starts, state.State, StateStopped)
+	}
+	events := m.Events()
+	if len(events) != 2 || events[0].Type != "start" || events[1].Type != "start-failed" {
+		t.Errorf("events %+v, want start and start-failed", events)
+	}
+}
+
+func TestBeginKeepsStoppedBackendStopped(t *testing.T) {
+	m := &Manager{backends: make(map[string]*backendState)}
+	release := m.Begin("ondemand")
+	if state := m.Get("ondemand").State; state != StateStopped {
+		t.Errorf("state %s after Begin on a stopped backend, want stopped", state)
+	}
+	release()
+
+	// A running backend turns ready while requests are in flight
+	m.backends["ondemand"].State = StateIdle
+	release = m.Begin("ondemand")
+	if state := m.Get("ondemand").State; state != StateReady {
+		t.Errorf("state %s with a request in flight, want ready", state)
+	}
+	release()
+	if state := m.Get("ondemand").State; state != StateIdle {
+		t.Errorf("state %s after the request, want idle", state)
+	}
+}
@@ -0,0 +1,37 @@
+package lifecycle
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+)
+
+// MagicPacket returns the wake-on-LAN payload for a MAC address: six 0xFF
+// bytes followed by the address repeated sixteen times
+func MagicPacket(mac string) ([]byte, error) {
+	hw, err := net.ParseMAC(mac)
+	if err != nil {
+		return nil, err
+	}
+	if len(hw) != 6 {
+		return nil, fmt.Errorf("MAC address %s is not 6 bytes long", mac)
+	}
+	packet := bytes.Repeat([]byte{0xFF}, 6)
+	packet = append(packet, bytes.Repeat(hw, 16)...)
+	return packet, nil
+}
+
+// SendMagicPacket sends a wake-on-LAN packet for mac to a UDP broadcast address (host:port)
+func SendMagicPacket(mac string, broadcast string) error {
+	packet, err := MagicPacket(mac)
+	if err != nil {
+		return err
+	}
+	conn, err := net.Dial("udp", broadcast)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	_, err = conn.Write(packet)
+	return err
+}
@@ -0,0 +1,54 @@
+package lifecycle
+
+import (
+	"bytes"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestMagicPacket(t *testing.T) {
+	packet, err := MagicPacket("aa:bb:cc:dd:ee:ff")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(packet) != 102 {
+		t.Fatalf("packet is %d bytes long, want 102", len(packet))
+	}
+	if !bytes.Equal(packet[:6], bytes.Repeat([]byte{0xFF}, 6)) {
+		t.Errorf("packet starts with %x, want six 0xFF bytes", packet[:6])
+	}
+	mac := []byte{0xaa, 0xbb, 0xcc, 0xdd, 0xee, 0xff}
+	for i := 0; i < 16; i++ {
+		if got := packet[6+i*6 : 12+i*6]; !bytes.Equal(got, mac) {
+			t.Errorf("repetition %d is %x, want %x", i, got, mac)
+		}
+	}
+
+	if _, err := MagicPacket("aa:bb:cc"); err == nil {
+		t.Error("invalid MAC address accepted")
+	}
+}
+
+func TestSendMagicPacket(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	if err := SendMagicPacket("aa:bb:cc:dd:ee:ff", conn.LocalAddr().String()); err != nil {
+		t.Fatal(err)
+	}
+
+	buf := make([]byte, 1024)
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	n, _, err := conn.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, _ := MagicPacket("aa:bb:cc:dd:ee:ff")
+	if !bytes.Equal(buf[:n], want) {
+		t.Errorf("received %d bytes %x, want the 102-byte magic packet", n, buf[:n])
+	}
+}
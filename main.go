@@ -1,10 +1,25 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"immich_ml_proxy/config"
+	"immich_ml_proxy/deepcheck"
+	"immich_ml_proxy/discovery"
 	"immich_ml_proxy/handlers"
+	"immich_ml_proxy/keepwarm"
+	"immich_ml_proxy/lifecycle"
+	"immich_ml_proxy/notify"
+	"immich_ml_proxy/outlier"
+	"immich_ml_proxy/proxy"
+	"io"
 	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
 
 	"github.com/gin-gonic/gin"
 )
@@ -12,6 +27,12 @@ import (
 func main() {
 	// Parse command line flags
 	debugMode := flag.Bool("debug", false, "Enable debug mode")
+	configLocation := flag.String("config", "", "Config file (.json, .yaml, .yml, .toml), SQLite database (sqlite://path or .db) or http(s) URL")
+	configPoll := flag.Duration("config-poll", 30*time.Second, "How often shared config stores (SQLite, http) are polled for changes")
+	healthHistory := flag.String("health-history", "health_history.json", "File the backend health history is kept in (empty to keep it in memory only)")
+	allowExecHooks := flag.Bool("allow-exec-hooks", false, "Allow the API to add and change lifecycle command hooks, which run programs on this host")
+	readinessGrace := flag.Duration("readiness-grace", 5*time.Second, "How long to keep serving after a shutdown signal, so clients see /ping and /readyz report not ready")
+	drainTimeout := flag.Duration("drain-timeout", 30*time.Second, "How long to wait for in-flight requests on shutdown")
 	flag.Parse()
 
 	// Set Gin mode: Release by default, Debug only if --debug flag is provided
@@ -22,8 +43,72 @@ func main() {
 	}
 
 	// Load configuration
+	store, err := config.OpenStore(*configLocation, *configPoll)
+	if err != nil {
+		log.Fatal("Failed to open config store:", err)
+	}
+	config.UseStore(store)
+	config.AllowExecHooks(*allowExecHooks)
 	cfg := config.Load()
 	handlers.Init(cfg)
+	if *healthHistory != "" {
+		if err := cfg.LoadHealthHistory(*healthHistory); err != nil {
+			log.Printf("Failed to load health history: %v", err)
+		}
+	}
+	log.Printf("Using config from %s", store)
+
+	// Background workers run until the server has drained
+	workerCtx, stopWorkers := context.WithCancel(context.Background())
+	var workers sync.WaitGroup
+	runWorker := func(run func(ctx context.Context)) {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			run(workerCtx)
+		}()
+	}
+
+	// Pick up changes made by other instances sharing the store
+	runWorker(cfg.Watch)
+
+	// Switch routing profiles and backends at schedule boundaries
+	runWorker(cfg.RunSchedules)
+
+	// Stop idle backends that have lifecycle hooks
+	runWorker(func(ctx context.Context) { lifecycle.GetInstance().Run(ctx, cfg) })
+
+	// Eject backends that are much slower or fail more than their peers
+	runWorker(func(ctx context.Context) { outlier.GetInstance().Run(ctx, cfg) })
+
+	// Send test inputs through each routed task to catch broken models
+	runWorker(func(ctx context.Context) {
+		deepcheck.GetInstance().Run(ctx, cfg, func(check config.DeepCheck) []config.Backend {
+			return handlers.RoutedBackends(check.Task, check.ModelType, check.ModelName)
+		})
+	})
+
+	// Keep models loaded on the backends while a keep-warm schedule is active
+	runWorker(func(ctx context.Context) {
+		keepwarm.GetInstance().Run(ctx, cfg, func(model config.TaskModel) []config.Backend {
+			return handlers.RoutedBackends(model.Task, model.ModelType, model.ModelName)
+		})
+	})
+
+	// Find out which models each backend can serve
+	runWorker(func(ctx context.Context) {
+		discovery.GetInstance().Run(ctx, cfg, func(model config.TaskModel) (string, []config.Backend) {
+			return handlers.RouteFor(model.Task, model.ModelType, model.ModelName)
+		})
+	})
+
+	// Persist the health history of the backends
+	if *healthHistory != "" {
+		runWorker(func(ctx context.Context) { cfg.RunHealthHistory(ctx, *healthHistory) })
+	}
+
+	// Notify about health transitions, ejections and configuration changes
+	runWorker(func(ctx context.Context) { notify.GetInstance().Run(ctx, cfg) })
 
 	// Create Gin router
 	r := gin.Default()
@@ -34,13 +119,45 @@ func main() {
 	// API routes
 	r.GET("/", handlers.RootHandler)
 	r.GET("/ping", handlers.PingHandler)
+	r.GET("/livez", handlers.LivezHandler)
+	r.GET("/readyz", handlers.ReadyzHandler)
 	r.POST("/predict", handlers.PredictHandler)
 
 	// Configuration routes
 	r.GET("/config", handlers.ConfigGetHandler)
 	r.GET("/api/config", handlers.ConfigAPIGetHandler)
 	r.POST("/api/config", handlers.ConfigPostHandler)
+	r.PATCH("/api/config", handlers.ConfigPatchHandler)
+	r.GET("/api/config/schema", handlers.ConfigSchemaHandler)
+	r.GET("/api/config/history", handlers.ConfigHistoryHandler)
 	r.GET("/api/health", handlers.HealthAPIGetHandler)
+	r.GET("/api/health/history", handlers.HealthHistoryAPIGetHandler)
+	r.GET("/api/readiness", handlers.ReadinessAPIGetHandler)
+	r.GET("/api/stats", handlers.StatsAPIGetHandler)
+	r.GET("/api/lifecycle", handlers.LifecycleAPIGetHandler)
+	r.GET("/api/keepwarm", handlers.KeepWarmAPIGetHandler)
+	r.GET("/api/notifications", handlers.NotificationsAPIGetHandler)
+	r.POST("/api/notifications/test", handlers.NotificationsTestHandler)
+
+	// Resource routes
+	r.GET("/api/backends", handlers.BackendsListHandler)
+	r.GET("/api/backends/capabilities", handlers.CapabilitiesAPIGetHandler)
+	r.GET("/api/backends/:name", handlers.BackendGetHandler)
+	r.PUT("/api/backends/:name", handlers.BackendPutHandler)
+	r.DELETE("/api/backends/:name", handlers.BackendDeleteHandler)
+	r.GET("/api/backends/:name/state", handlers.BackendStateGetHandler)
+	r.PUT("/api/backends/:name/state", handlers.BackendStatePutHandler)
+	r.GET("/api/routes/default", handlers.DefaultRouteGetHandler)
+	r.PUT("/api/routes/default", handlers.DefaultRoutePutHandler)
+	r.GET("/api/routes/tasks", handlers.TaskRoutesListHandler)
+	r.GET("/api/routes/tasks/:task", handlers.TaskRouteGetHandler)
+	r.PUT("/api/routes/tasks/:task", handlers.TaskRoutePutHandler)
+	r.DELETE("/api/routes/tasks/:task", handlers.TaskRouteDeleteHandler)
+	r.GET("/api/routes/model-types", handlers.ModelTypeRoutesListHandler)
+	r.GET("/api/routes/model-types/:type", handlers.ModelTypeRouteGetHandler)
+	r.PUT("/api/routes/model-types/:type", handlers.ModelTypeRoutePutHandler)
+	r.DELETE("/api/routes/model-types/:type", handlers.ModelTypeRouteDeleteHandler)
+	r.POST("/api/route/explain", handlers.RouteExplainHandler)
 
 	// Debug routes
 	r.GET("/debug", handlers.DebugPageHandler)
@@ -51,8 +168,44 @@ func main() {
 	r.DELETE("/api/debug/records", handlers.DebugClearRecordsHandler)
 
 	// Start server
-	log.Println("Starting Immich ML Proxy on :3004")
-	if err := r.Run(":3004"); err != nil {
+	srv := &http.Server{Addr: ":3004", Handler: r}
+	serverErr := make(chan error, 1)
+	go func() {
+		log.Println("Starting Immich ML Proxy on :3004")
+		serverErr <- srv.ListenAndServe()
+	}()
+
+	// Wait for SIGINT/SIGTERM; a second signal kills the process
+	signals, stopSignals := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	select {
+	case err := <-serverErr:
 		log.Fatal("Failed to start server:", err)
+	case <-signals.Done():
+	}
+	stopSignals()
+
+	// Report not-ready while still serving, so clients polling /ping or /readyz
+	// notice and stop sending requests before the listener closes
+	log.Printf("Shutting down, reporting not ready for %s", *readinessGrace)
+	handlers.BeginShutdown()
+	time.Sleep(*readinessGrace)
+
+	// Stop accepting connections and let in-flight requests finish
+	log.Printf("Waiting up to %s for in-flight requests", *drainTimeout)
+	drainCtx, cancelDrain := context.WithTimeout(context.Background(), *drainTimeout)
+	defer cancelDrain()
+	if err := srv.Shutdown(drainCtx); err != nil {
+		log.Printf("Drain period expired, closing remaining connections: %v", err)
+		srv.Close()
+	}
+
+	proxy.CloseIdleConnections()
+	stopWorkers()
+	workers.Wait()
+	if closer, ok := store.(io.Closer); ok {
+		if err := closer.Close(); err != nil {
+			log.Printf("Failed to close config store %s: %v", store, err)
+		}
 	}
-}
\ No newline at end of file
+	log.Println("Shutdown complete")
+}
@@ -0,0 +1,307 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"immich_ml_proxy/config"
+	"immich_ml_proxy/outlier"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultMinDuration = time.Minute
+	defaultDebounce    = 5 * time.Minute
+	pollInterval       = 5 * time.Second
+	maxDeliveries      = 100
+)
+
+// Event is a notification about a state change
+type Event struct {
+	Kind     string    `json:"kind"`              // backend, task, ejection, config or test
+	Subject  string    `json:"subject"`           // what changed, e.g. the backend name
+	Backend  string    `json:"backend,omitempty"` // backend of backend, task and ejection events
+	Task     string    `json:"task,omitempty"`    // task/modelType of task events
+	Status   string    `json:"status"`            // healthy, unhealthy, ejected or changed
+	Previous string    `json:"previous,omitempty"`
+	Title    string    `json:"title"`
+	Message  string    `json:"message"`
+	Since    time.Time `json:"since"` // when the state began
+	Time     time.Time `json:"time"`
+}
+
+// Delivery is the outcome of sending an event to a target
+type Delivery struct {
+	Target string    `json:"target"`
+	Event  Event     `json:"event"`
+	Time   time.Time `json:"time"`
+	Error  string    `json:"error,omitempty"`
+}
+
+// subject is the notification state of a backend, a task on a backend, or
+// the configuration
+type subject struct {
+	current    string    // latest observed state
+	since      time.Time // when the current state was first observed
+	notified   string    // state last notified about, or the state it started in
+	notifiedAt time.Time
+}
+
+// Notifier watches backend and task health, ejections and the configuration
+// and notifies the configured targets about changes. A new state has to last
+// for the minimum duration before it is notified, and notifications about the
+// same subject are at least the debounce time apart, so a flapping backend
+// does not flood the targets.
+type Notifier struct {
+	mu         sync.Mutex
+	subjects   map[string]*subject        // subject key -> state
+	sections   map[string]json.RawMessage // configuration as last notified, by top-level key
+	deliveries []Delivery                 // most recent last
+}
+
+var (
+	instance *Notifier
+	once     sync.Once
+)
+
+// GetInstance returns the singleton Notifier
+func GetInstance() *Notifier {
+	once.Do(func() {
+		instance = &Notifier{
+			subjects: make(map[string]*subject),
+		}
+	})
+	return instance
+}
+
+// Deliveries returns the most recent deliveries, oldest first
+func (n *Notifier) Deliveries() []Delivery {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return append([]Delivery{}, n.deliveries...)
+}
+
+// Run looks for changes to notify about until ctx is done
+func (n *Notifier) Run(ctx context.Context, cfg *config.Config) {
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+	for {
+		if settings := cfg.GetNotifications(); settings != nil {
+			for _, event := range n.poll(cfg, *settings, time.Now()) {
+				n.Send(ctx, *settings, event)
+			}
+		} else {
+			n.reset()
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Send sends an event to every target that wants it and waits for the deliveries
+func (n *Notifier) Send(ctx context.Context, settings config.Notifications, event Event) []Delivery {
+	var wg sync.WaitGroup
+	deliveries := make([]Delivery, len(settings.Targets))
+	for i, target := range settings.Targets {
+		if event.Kind != "test" && !target.Wants(event.Kind) {
+			continue
+		}
+		wg.Add(1)
+		go func(i int, target config.NotificationTarget) {
+			defer wg.Done()
+			delivery := Delivery{Target: target.Name, Event: event, Time: time.Now()}
+			if err := send(ctx, target, event); err != nil {
+				delivery.Error = err.Error()
+				log.Printf("Failed to send notification to %s: %v", target.Name, err)
+			}
+			deliveries[i] = delivery
+		}(i, target)
+	}
+	wg.Wait()
+
+	result := []Delivery{}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	for _, delivery := range deliveries {
+		if delivery.Target == "" {
+			continue
+		}
+		result = append(result, delivery)
+		n.deliveries = append(n.deliveries, delivery)
+	}
+	if len(n.deliveries) > maxDeliveries {
+		n.deliveries = n.deliveries[len(n.deliveries)-maxDeliveries:]
+	}
+	return result
+}
+
+// poll observes the current state of all subjects and returns the events
+// that are due
+func (n *Notifier) poll(cfg *config.Config, settings config.Notifications, now time.Time) []Event {
+	minDuration := settings.MinDuration.Or(defaultMinDuration)
+	debounce := settings.Debounce.Or(defaultDebounce)
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	var events []Event
+	seen := make(map[string]bool)
+	healthy := string(config.HealthStatusHealthy)
+
+	for name, health := range cfg.GetAllHealthStatus() {
+		if health.Status == config.HealthStatusUnknown {
+			continue
+		}
+		key := "backend:" + name
+		seen[key] = true
+		if previous, since, ok := n.observe(key, string(health.Status), healthy, minDuration, debounce, now); ok {
+			event := Event{Kind: config.EventBackend, Subject: name, Backend: name, Status: string(health.Status), Previous: previous, Since: since, Time: now}
+			if health.Status == config.HealthStatusHealthy {
+				event.Title = fmt.Sprintf("Backend %s is healthy again", name)
+				event.Message = fmt.Sprintf("Backend %s has been healthy since %s.", name, since.Format(time.DateTime))
+			} else {
+				event.Title = fmt.Sprintf("Backend %s is unhealthy", name)
+				event.Message = fmt.Sprintf("Backend %s has been unhealthy since %s: %s", name, since.Format(time.DateTime), health.Error)
+			}
+			events = append(events, event)
+		}
+	}
+
+	for _, backend := range cfg.GetBackends() {
+		for task, health := range cfg.GetAllTaskHealth(backend.Name) {
+			key := "task:" + backend.Name + "/" + task
+			seen[key] = true
+			if previous, since, ok := n.observe(key, string(health.Status), healthy, minDuration, debounce, now); ok {
+				event := Event{Kind: config.EventTask, Subject: backend.Name + " " + task, Backend: backend.Name, Task: task, Status: string(health.Status), Previous: previous, Since: since, Time: now}
+				if health.Status == config.HealthStatusHealthy {
+					event.Title = fmt.Sprintf("%s works again on backend %s", task, backend.Name)
+					event.Message = fmt.Sprintf("The deep check of %s has passed on backend %s since %s.", task, backend.Name, since.Format(time.DateTime))
+				} else {
+					event.Title = fmt.Sprintf("%s fails on backend %s", task, backend.Name)
+					event.Message = fmt.Sprintf("The deep check of %s has failed on backend %s since %s: %s", task, backend.Name, since.Format(time.DateTime), health.Error)
+				}
+				events = append(events, event)
+			}
+		}
+
+		// Ejections are notified right away, returns are not notified
+		key := "ejection:" + backend.Name
+		seen[key] = true
+		state, reason := "active", ""
+		if ejection, ok := outlier.GetInstance().Ejected(backend.Name); ok {
+			state = "ejected"
+			reason = fmt.Sprintf("%s, until %s", ejection.Reason, ejection.Until.Format(time.DateTime))
+		}
+		if previous, since, ok := n.observe(key, state, "active", 0, debounce, now); ok {
+			if state == "ejected" {
+				events = append(events, Event{
+					Kind: config.EventEjection, Subject: backend.Name, Backend: backend.Name, Status: state, Previous: previous, Since: since, Time: now,
+					Title:   fmt.Sprintf("Backend %s was ejected", backend.Name),
+					Message: fmt.Sprintf("Outlier detection ejected backend %s: %s", backend.Name, reason),
+				})
+			}
+		}
+	}
+
+	// Configuration changes are notified right away; changes within the
+	// debounce time are combined into one notification
+	seen["config"] = true
+	_, changedSince, changed := n.observe("config", cfg.ETag(), cfg.ETag(), 0, debounce, now)
+	if sections, err := configSections(cfg); err == nil {
+		if n.sections == nil {
+			n.sections = sections
+		}
+		if changed {
+			event := Event{Kind: config.EventConfig, Subject: "config", Status: "changed", Since: changedSince, Time: now, Title: "Configuration changed"}
+			event.Message = fmt.Sprintf("The configuration was changed at %s: %s.", changedSince.Format(time.DateTime), strings.Join(changedSections(n.sections, sections), ", "))
+			n.sections = sections
+			events = append(events, event)
+		}
+	}
+
+	for key := range n.subjects {
+		if !seen[key] {
+			delete(n.subjects, key)
+		}
+	}
+	return events
+}
+
+// observe records the state of a subject and reports whether a change is to
+// be notified, with the state notified before and since when the new state
+// holds. A subject seen for the first time starts out in the initial state,
+// so only a different state is notified. Callers must hold mu.
+func (n *Notifier) observe(key, state, initial string, minDuration, debounce time.Duration, now time.Time) (string, time.Time, bool) {
+	s, ok := n.subjects[key]
+	if !ok {
+		s = &subject{current: state, since: now, notified: initial}
+		n.subjects[key] = s
+	} else if s.current != state {
+		s.current = state
+		s.since = now
+	}
+
+	if s.current == s.notified || now.Sub(s.since) < minDuration || now.Sub(s.notifiedAt) < debounce {
+		return "", time.Time{}, false
+	}
+	previous := s.notified
+	s.notified = s.current
+	s.notifiedAt = now
+	return previous, s.since, true
+}
+
+// reset forgets all subjects
+func (n *Notifier) reset() {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.subjects = make(map[string]*subject)
+	n.sections = nil
+}
+
+// configSections returns the configuration by top-level key, without the
+// fields reporting the active schedules
+func configSections(cfg *config.Config) (map[string]json.RawMessage, error) {
+	data, _, err := cfg.ToJSONWithETag()
+	if err != nil {
+		return nil, err
+	}
+	var sections map[string]json.RawMessage
+	if err := json.Unmarshal(data, &sections); err != nil {
+		return nil, err
+	}
+	delete(sections, "activeProfile")
+	delete(sections, "activeSchedules")
+	return sections, nil
+}
+
+// changedSections returns the top-level keys that differ between two
+// versions of the configuration
+func changedSections(before, after map[string]json.RawMessage) []string {
+	keys := make(map[string]bool)
+	for key := range before {
+		keys[key] = true
+	}
+	for key := range after {
+		keys[key] = true
+	}
+
+	var changed []string
+	for key := range keys {
+		if string(before[key]) != string(after[key]) {
+			changed = append(changed, key)
+		}
+	}
+	sort.Strings(changed)
+	if len(changed) == 0 {
+		return []string{"no settings differ"}
+	}
+	return changed
+}
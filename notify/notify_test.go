@@ -0,0 +1,86 @@
+package notify
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestObserve(t *testing.T) {
+	type step struct {
+		at       time.Duration // since the first observation
+		state    string
+		previous string // state notified before, empty if nothing is notified
+		since    time.Duration
+	}
+	tests := []struct {
+		name  string
+		steps []step
+	}{
+		{"initial state is not notified", []step{
+			{0, "healthy", "", 0},
+			{10 * time.Minute, "healthy", "", 0},
+		}},
+		{"new state is notified after the minimum duration", []step{
+			{0, "unhealthy", "", 0},
+			{30 * time.Second, "unhealthy", "", 0},
+			{time.Minute, "unhealthy", "healthy", 0},
+			{2 * time.Minute, "unhealthy", "", 0},
+		}},
+		{"short blip is not notified", []step{
+			{0, "healthy", "", 0},
+			{10 * time.Second, "unhealthy", "", 0},
+			{30 * time.Second, "healthy", "", 0},
+			{5 * time.Minute, "healthy", "", 0},
+		}},
+		{"changes are debounced", []step{
+			{0, "healthy", "", 0},
+			{time.Minute, "unhealthy", "", 0},
+			{2 * time.Minute, "unhealthy", "healthy", time.Minute},
+			{3 * time.Minute, "healthy", "", 0},
+			{5 * time.Minute, "healthy", "", 0},
+			{7 * time.Minute, "healthy", "unhealthy", 3 * time.Minute},
+		}},
+		{"return to the notified state within the debounce time", []step{
+			{0, "healthy", "", 0},
+			{time.Minute, "unhealthy", "", 0},
+			{2 * time.Minute, "unhealthy", "healthy", time.Minute},
+			{3 * time.Minute, "healthy", "", 0},
+			{4 * time.Minute, "unhealthy", "", 0},
+			{10 * time.Minute, "unhealthy", "", 0},
+		}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			n := &Notifier{subjects: make(map[string]*subject)}
+			start := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+			for _, step := range test.steps {
+				previous, since, ok := n.observe("gpu", step.state, "healthy", time.Minute, 5*time.Minute, start.Add(step.at))
+				if ok != (step.previous != "") || previous != step.previous {
+					t.Fatalf("at %s %s: notified %v from %q, want %q", step.at, step.state, ok, previous, step.previous)
+				}
+				if ok && !since.Equal(start.Add(step.since)) {
+					t.Errorf("at %s: since %s, want %s", step.at, since.Sub(start), step.since)
+				}
+			}
+		})
+	}
+}
+
+func TestChangedSections(t *testing.T) {
+	before := map[string]json.RawMessage{"backends": json.RawMessage(`[]`), "taskRouting": json.RawMessage(`{}`)}
+	tests := []struct {
+		after map[string]json.RawMessage
+		want  []string
+	}{
+		{before, []string{"no settings differ"}},
+		{map[string]json.RawMessage{"backends": json.RawMessage(`[{}]`), "taskRouting": json.RawMessage(`{}`)}, []string{"backends"}},
+		{map[string]json.RawMessage{"backends": json.RawMessage(`[]`), "rules": json.RawMessage(`[]`)}, []string{"rules", "taskRouting"}},
+	}
+	for _, test := range tests {
+		if got := changedSections(before, test.after); fmt.Sprint(got) != fmt.Sprint(test.want) {
+			t.Errorf("changed %v, want %v", got, test.want)
+		}
+	}
+}
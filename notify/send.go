@@ -0,0 +1,130 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"immich_ml_proxy/config"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+)
+
+const sendTimeout = 10 * time.Second
+
+var client = &http.Client{Timeout: sendTimeout}
+
+// send delivers an event to one target
+func send(ctx context.Context, target config.NotificationTarget, event Event) error {
+	var req *http.Request
+	var err error
+	switch target.Type {
+	case config.NotifyNtfy:
+		req, err = ntfyRequest(ctx, target, event)
+	case config.NotifyGotify:
+		req, err = gotifyRequest(ctx, target, event)
+	default:
+		req, err = webhookRequest(ctx, target, event)
+	}
+	if err != nil {
+		return err
+	}
+	for key, value := range target.Headers {
+		req.Header.Set(key, value)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("unexpected response: %s: %s", resp.Status, bytes.TrimSpace(body))
+	}
+	return nil
+}
+
+// webhookRequest posts the event as JSON, or the target's template rendered
+// over the event
+func webhookRequest(ctx context.Context, target config.NotificationTarget, event Event) (*http.Request, error) {
+	var body []byte
+	if target.Template == "" {
+		data, err := json.Marshal(event)
+		if err != nil {
+			return nil, err
+		}
+		body = data
+	} else {
+		tmpl, err := template.New(target.Name).Funcs(config.TemplateFuncs).Parse(target.Template)
+		if err != nil {
+			return nil, err
+		}
+		var buf bytes.Buffer
+		if err := tmpl.Execute(&buf, event); err != nil {
+			return nil, fmt.Errorf("rendering template: %w", err)
+		}
+		body = buf.Bytes()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", target.URL, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	return req, nil
+}
+
+// ntfyRequest publishes the event to an ntfy topic URL
+func ntfyRequest(ctx context.Context, target config.NotificationTarget, event Event) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, "POST", target.URL, strings.NewReader(event.Message))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Title", event.Title)
+	req.Header.Set("Tags", tag(event))
+	if target.Priority > 0 {
+		req.Header.Set("Priority", strconv.Itoa(target.Priority))
+	}
+	if target.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+target.Token)
+	}
+	return req, nil
+}
+
+// gotifyRequest posts the event as a message to a Gotify server
+func gotifyRequest(ctx context.Context, target config.NotificationTarget, event Event) (*http.Request, error) {
+	body, err := json.Marshal(map[string]interface{}{
+		"title":    event.Title,
+		"message":  event.Message,
+		"priority": target.Priority,
+	})
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", strings.TrimRight(target.URL, "/")+"/message", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if target.Token != "" {
+		req.Header.Set("X-Gotify-Key", target.Token)
+	}
+	return req, nil
+}
+
+// tag returns the ntfy tag (shown as an emoji) for an event
+func tag(event Event) string {
+	switch event.Status {
+	case string(config.HealthStatusHealthy):
+		return "white_check_mark"
+	case string(config.HealthStatusUnhealthy), "ejected":
+		return "warning"
+	default:
+		return "information_source"
+	}
+}
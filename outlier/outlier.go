@@ -0,0 +1,329 @@
+package outlier
+
+import (
+	"context"
+	"fmt"
+	"immich_ml_proxy/config"
+	"immich_ml_proxy/debug"
+	"immich_ml_proxy/stats"
+	"log"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultInterval           = 10 * time.Second
+	defaultMinRequests        = 10
+	defaultErrorRateMargin    = 0.3
+	defaultLatencyFactor      = 3
+	defaultBaseEjectionTime   = 30 * time.Second
+	defaultMaxEjectionTime    = 5 * time.Minute
+	defaultMaxEjectionPercent = 50
+)
+
+// Ejection describes a backend that is currently ejected
+type Ejection struct {
+	Reason string    `json:"reason"`
+	Since  time.Time `json:"since"`
+	Until  time.Time `json:"until"`
+	Count  int       `json:"count"` // ejections in a row, the ejection time grows with it
+}
+
+type backendState struct {
+	ejection *Ejection
+	count    int       // ejection multiplier, decays while the backend behaves
+	returned time.Time // when the last ejection ended; only later requests are judged
+}
+
+// Detector compares the error rate and latency of backends within their
+// pools and ejects outliers from routing for a while
+type Detector struct {
+	mu       sync.Mutex
+	backends map[string]*backendState // backend name -> state
+}
+
+var (
+	instance *Detector
+	once     sync.Once
+)
+
+// GetInstance returns the singleton Detector
+func GetInstance() *Detector {
+	once.Do(func() {
+		instance = &Detector{
+			backends: make(map[string]*backendState),
+		}
+	})
+	return instance
+}
+
+// Ejected returns the ejection of a backend, if it is ejected
+func (d *Detector) Ejected(backendName string) (Ejection, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	bs, ok := d.backends[backendName]
+	if !ok || bs.ejection == nil {
+		return Ejection{}, false
+	}
+	return *bs.ejection, true
+}
+
+// Run compares backends at the configured interval until ctx is done. While
+// outlier detection is not configured, ejections are lifted.
+func (d *Detector) Run(ctx context.Context, cfg *config.Config) {
+	for {
+		settings := cfg.GetOutlierDetection()
+		interval := defaultInterval
+		if settings != nil {
+			interval = settings.Interval.Or(defaultInterval)
+			d.detect(cfg, *settings, time.Now())
+		} else {
+			d.reset()
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(interval):
+		}
+	}
+}
+
+// outlierCandidate is a backend that is worse than its peers
+type outlierCandidate struct {
+	name     string
+	reason   string
+	severity float64 // how many times worse than the peers, the worst is ejected first
+}
+
+// detect runs one round of outlier detection
+func (d *Detector) detect(cfg *config.Config, settings config.OutlierDetection, now time.Time) {
+	minRequests := settings.MinRequests
+	if minRequests <= 0 {
+		minRequests = defaultMinRequests
+	}
+	margin := settings.ErrorRateMargin
+	if margin <= 0 {
+		margin = defaultErrorRateMargin
+	}
+	factor := settings.LatencyFactor
+	if factor <= 0 {
+		factor = defaultLatencyFactor
+	}
+	maxPercent := settings.MaxEjectionPercent
+	if maxPercent <= 0 {
+		maxPercent = defaultMaxEjectionPercent
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	// Return backends whose ejection is over
+	for name, bs := range d.backends {
+		if bs.ejection != nil && !now.Before(bs.ejection.Until) {
+			d.event("returned", name, fmt.Sprintf("backend %s returned after %s", name, now.Sub(bs.ejection.Since).Round(time.Second)))
+			bs.ejection = nil
+			bs.returned = now
+		}
+	}
+
+	// Compare backends only with their pool peers on the same task, so a
+	// backend that also serves slower tasks is not mistaken for a slow one
+	poolList := pools(cfg)
+	worst := make(map[string]outlierCandidate) // backend name -> worst finding
+	for _, pool := range poolList {
+		if len(pool) < 2 {
+			continue
+		}
+
+		var active []string
+		tasks := make(map[string]bool)
+		for _, name := range pool {
+			if bs, ok := d.backends[name]; ok && bs.ejection != nil {
+				continue
+			}
+			active = append(active, name)
+			for _, task := range stats.GetInstance().Tasks(name, d.state(name).returned) {
+				tasks[task] = true
+			}
+		}
+
+		for task := range tasks {
+			// Judge backends by the requests since their last ejection ended
+			measured := make(map[string]stats.BackendStats)
+			for _, name := range active {
+				s := stats.GetInstance().GetTaskSince(name, task, d.state(name).returned)
+				if s.Window >= minRequests {
+					measured[name] = s
+				}
+			}
+
+			for name, s := range measured {
+				var peerErrors, peerLatencies []float64
+				for peer, ps := range measured {
+					if peer != name {
+						peerErrors = append(peerErrors, ps.ErrorRate)
+						peerLatencies = append(peerLatencies, ps.LatencyP50Ms)
+					}
+				}
+				if len(peerErrors) == 0 {
+					continue
+				}
+				peerError, peerLatency := median(peerErrors), median(peerLatencies)
+				var c outlierCandidate
+				switch {
+				case s.ErrorRate > peerError+margin:
+					c = outlierCandidate{name, fmt.Sprintf("%s error rate %.0f%% vs %.0f%% in pool %s", task, s.ErrorRate*100, peerError*100, strings.Join(pool, ", ")), (s.ErrorRate + 0.01) / (peerError + 0.01)}
+				case peerLatency > 0 && s.LatencyP50Ms > factor*peerLatency:
+					c = outlierCandidate{name, fmt.Sprintf("%s median latency %.0fms vs %.0fms in pool %s", task, s.LatencyP50Ms, peerLatency, strings.Join(pool, ", ")), s.LatencyP50Ms / peerLatency}
+				default:
+					continue
+				}
+				if c.severity > worst[name].severity {
+					worst[name] = c
+				}
+			}
+		}
+	}
+
+	var candidates []outlierCandidate
+	for _, c := range worst {
+		candidates = append(candidates, c)
+	}
+	sort.Slice(candidates, func(i, j int) bool { return candidates[i].severity > candidates[j].severity })
+
+	// Ejections apply to every pool of a backend, so each of them caps the
+	// share of its backends that is ejected
+	outliers := make(map[string]bool)
+	for _, c := range candidates {
+		outliers[c.name] = true
+		if pool, full := d.fullPool(poolList, c.name, maxPercent); full {
+			log.Printf("Backend %s is an outlier (%s) but %d%% of its pool %s is already ejected", c.name, c.reason, maxPercent, strings.Join(pool, ", "))
+			continue
+		}
+		d.eject(c.name, c.reason, settings, now)
+	}
+
+	// The ejection time decays while a backend behaves
+	base := settings.BaseEjectionTime.Or(defaultBaseEjectionTime)
+	for name, bs := range d.backends {
+		if bs.ejection == nil && !outliers[name] && bs.count > 0 && now.Sub(bs.returned) >= base*time.Duration(bs.count) {
+			bs.count--
+			bs.returned = now
+		}
+	}
+}
+
+// fullPool returns a pool of the backend in which ejecting it would exceed
+// maxPercent. Callers must hold mu.
+func (d *Detector) fullPool(poolList [][]string, name string, maxPercent int) ([]string, bool) {
+	for _, pool := range poolList {
+		member, ejected := false, 0
+		for _, peer := range pool {
+			if peer == name {
+				member = true
+			}
+			if bs, ok := d.backends[peer]; ok && bs.ejection != nil {
+				ejected++
+			}
+		}
+		limit := int(math.Floor(float64(len(pool)) * float64(maxPercent) / 100))
+		if member && ejected >= limit {
+			return pool, true
+		}
+	}
+	return nil, false
+}
+
+// eject ejects a backend unless it already is. Callers must hold mu.
+func (d *Detector) eject(name, reason string, settings config.OutlierDetection, now time.Time) bool {
+	bs := d.state(name)
+	if bs.ejection != nil {
+		return false
+	}
+	bs.count++
+	duration := settings.BaseEjectionTime.Or(defaultBaseEjectionTime) * time.Duration(bs.count)
+	if max := settings.MaxEjectionTime.Or(defaultMaxEjectionTime); duration > max {
+		duration = max
+	}
+	bs.ejection = &Ejection{Reason: reason, Since: now, Until: now.Add(duration), Count: bs.count}
+	d.event("ejected", name, fmt.Sprintf("backend %s ejected for %s: %s", name, duration, reason))
+	return true
+}
+
+// reset lifts all ejections
+func (d *Detector) reset() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for name, bs := range d.backends {
+		if bs.ejection != nil {
+			d.event("returned", name, fmt.Sprintf("backend %s returned, outlier detection is off", name))
+		}
+	}
+	d.backends = make(map[string]*backendState)
+}
+
+// state returns the state of a backend, creating it if needed. Callers must hold mu.
+func (d *Detector) state(name string) *backendState {
+	bs, ok := d.backends[name]
+	if !ok {
+		bs = &backendState{}
+		d.backends[name] = bs
+	}
+	return bs
+}
+
+// event logs an ejection event and records it for the debug page
+func (d *Detector) event(kind, backendName, message string) {
+	log.Printf("Outlier detection: %s", message)
+	debug.GetInstance().RecordEvent(debug.GenerateID(), "OUTLIER "+strings.ToUpper(kind), message)
+}
+
+// pools returns the backend names of every routing target in use, without duplicates
+func pools(cfg *config.Config) [][]string {
+	active := cfg.ActiveRouting()
+	var targets []string
+	for _, rule := range active.Rules {
+		if rule.Target != "" {
+			targets = append(targets, rule.Target)
+		}
+		for _, tier := range rule.Tiers {
+			targets = append(targets, tier.Target)
+		}
+	}
+	for _, target := range active.TaskRouting {
+		targets = append(targets, target)
+	}
+	for _, target := range active.ModelTypeRouting {
+		targets = append(targets, target)
+	}
+
+	seen := make(map[string]bool)
+	var result [][]string
+	for _, target := range targets {
+		var names []string
+		for _, backend := range cfg.ResolveTarget(target) {
+			names = append(names, backend.Name)
+		}
+		sort.Strings(names)
+		key := strings.Join(names, ",")
+		if !seen[key] {
+			seen[key] = true
+			result = append(result, names)
+		}
+	}
+	return result
+}
+
+func median(values []float64) float64 {
+	sorted := append([]float64{}, values...)
+	sort.Float64s(sorted)
+	n := len(sorted)
+	if n%2 == 1 {
+		return sorted[n/2]
+	}
+	return (sorted[n/2-1] + sorted[n/2]) / 2
+}
@@ -0,0 +1,88 @@
+package outlier
+
+import (
+	"immich_ml_proxy/config"
+	"immich_ml_proxy/stats"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestMain(m *testing.M) {
+	dir, err := os.MkdirTemp("", "outlier")
+	if err != nil {
+		panic(err)
+	}
+	config.UseStore(config.NewFileStore(filepath.Join(dir, "config.json")))
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+// loadConfig replaces the settings of the shared configuration
+func loadConfig(t *testing.T, settings config.Settings) *config.Config {
+	t.Helper()
+	cfg := config.Load()
+	if _, err := cfg.Replace("", settings); err != nil {
+		t.Fatal(err)
+	}
+	return cfg
+}
+
+func record(backend, task string, latency time.Duration, n int) {
+	for i := 0; i < n; i++ {
+		stats.GetInstance().Record(backend, task, latency, false)
+	}
+}
+
+func TestDetectComparesPerTask(t *testing.T) {
+	cfg := loadConfig(t, config.Settings{
+		DefaultBackend: "a1",
+		Backends: []config.Backend{
+			{Name: "a1", URL: "http://a1:3003", Labels: map[string]string{"clip": "a"}},
+			{Name: "a2", URL: "http://a2:3003", Labels: map[string]string{"clip": "a"}},
+			{Name: "a3", URL: "http://a3:3003", Labels: map[string]string{"clip": "a"}},
+		},
+		TaskRouting: map[string]string{"clip": "clip=a", "ocr": "a3"},
+	})
+
+	// a3 also serves slow OCR but is as fast as its peers on CLIP
+	record("a1", "clip/visual", 100*time.Millisecond, 20)
+	record("a2", "clip/visual", 110*time.Millisecond, 20)
+	record("a3", "clip/visual", 105*time.Millisecond, 20)
+	record("a3", "ocr/detection", 2*time.Second, 40)
+
+	d := &Detector{backends: make(map[string]*backendState)}
+	d.detect(cfg, config.OutlierDetection{}, time.Now())
+	if ejection, ok := d.Ejected("a3"); ok {
+		t.Errorf("a3 ejected: %s", ejection.Reason)
+	}
+}
+
+func TestDetectCapsEveryPool(t *testing.T) {
+	cfg := loadConfig(t, config.Settings{
+		DefaultBackend: "b1",
+		Backends: []config.Backend{
+			{Name: "b1", URL: "http://b1:3003", Labels: map[string]string{"clip": "b", "face": "b"}},
+			{Name: "b2", URL: "http://b2:3003", Labels: map[string]string{"clip": "b", "face": "b"}},
+			{Name: "b3", URL: "http://b3:3003", Labels: map[string]string{"clip": "b"}},
+			{Name: "b4", URL: "http://b4:3003", Labels: map[string]string{"clip": "b"}},
+		},
+		TaskRouting: map[string]string{"clip": "clip=b", "facial-recognition": "face=b"},
+	})
+
+	// b1 and b2 are both slow on CLIP; ejecting both would empty the face pool
+	record("b1", "clip/visual", time.Second, 20)
+	record("b2", "clip/visual", 900*time.Millisecond, 20)
+	record("b3", "clip/visual", 100*time.Millisecond, 20)
+	record("b4", "clip/visual", 100*time.Millisecond, 20)
+
+	d := &Detector{backends: make(map[string]*backendState)}
+	d.detect(cfg, config.OutlierDetection{MaxEjectionPercent: 50}, time.Now())
+	_, b1 := d.Ejected("b1")
+	_, b2 := d.Ejected("b2")
+	if !b1 || b2 {
+		t.Errorf("ejected b1 %v and b2 %v, want only b1, the worst outlier", b1, b2)
+	}
+}
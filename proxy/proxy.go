@@ -2,8 +2,12 @@ package proxy
 
 import (
 	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	"immich_ml_proxy/config"
 	"io"
 	"mime/multipart"
 	"net/http"
@@ -45,7 +49,8 @@ func (b *RoundRobinBalancer) GetNextBackend(typeName string, backends []string)
 		index = 0
 	}
 
-	// Select backend
+	// Select backend (the list may have shrunk since the last call)
+	index %= len(backends)
 	backend := backends[index]
 
 	// Update index for next time
@@ -54,6 +59,18 @@ func (b *RoundRobinBalancer) GetNextBackend(typeName string, backends []string)
 	return backend
 }
 
+// PeekNextBackend returns the backend GetNextBackend would return, without advancing the index
+func (b *RoundRobinBalancer) PeekNextBackend(typeName string, backends []string) string {
+	if len(backends) == 0 {
+		return ""
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return backends[b.roundRobinIndex[typeName]%len(backends)]
+}
+
 // GetNextBackend returns the next backend using global round-robin balancer
 func GetNextBackend(typeName string, backends []string) string {
 	return globalBalancer.GetNextBackend(typeName, backends)
@@ -63,12 +80,13 @@ func GetNextBackend(typeName string, backends []string) string {
 var globalBalancer = NewRoundRobinBalancer()
 
 // ForwardRequest forwards the HTTP request to the specified backend server
-func ForwardRequest(backendURL string, method string, path string, header http.Header, body io.Reader) (*http.Response, error) {
-	client := &http.Client{
-		Timeout: 30 * time.Second,
+func ForwardRequest(backend config.Backend, method string, path string, header http.Header, body io.Reader) (*http.Response, error) {
+	client, err := ClientFor(backend, 30*time.Second)
+	if err != nil {
+		return nil, err
 	}
 
-	targetURL := backendURL + path
+	targetURL := backend.URL + path
 
 	// Read body for debug recording
 	var bodyBytes []byte
@@ -91,17 +109,34 @@ func ForwardRequest(backendURL string, method string, path string, header http.H
 			req.Header.Add(key, value)
 		}
 	}
+	applyBackendHeaders(req, backend)
 
 	return client.Do(req)
 }
 
 // CheckBackendHealth checks if a backend server is healthy by calling its /ping endpoint
-func CheckBackendHealth(backendURL string) BackendStatus {
-	client := &http.Client{
-		Timeout: 5 * time.Second,
+func CheckBackendHealth(backend config.Backend) BackendStatus {
+	backendURL := backend.URL
+	client, err := ClientFor(backend, 5*time.Second)
+	if err != nil {
+		return BackendStatus{
+			URL:    backendURL,
+			Status: "unhealthy",
+			Error:  err.Error(),
+		}
+	}
+
+	req, err := http.NewRequestWithContext(withPingTrace(context.Background(), backend.Name), "GET", backendURL+"/ping", nil)
+	if err != nil {
+		return BackendStatus{
+			URL:    backendURL,
+			Status: "unhealthy",
+			Error:  err.Error(),
+		}
 	}
+	applyBackendHeaders(req, backend)
 
-	resp, err := client.Get(backendURL + "/ping")
+	resp, err := client.Do(req)
 	if err != nil {
 		return BackendStatus{
 			URL:    backendURL,
@@ -200,6 +235,45 @@ func GroupEntriesByType(entries []Entry) map[string][]Entry {
 	return grouped
 }
 
+// ModelName returns the modelName of an entry, or "" if it has none
+func ModelName(entry Entry) string {
+	if data, ok := entry.EntryData.(map[string]interface{}); ok {
+		if name, ok := data["modelName"].(string); ok {
+			return name
+		}
+	}
+	return ""
+}
+
+// ContentHash returns the hex SHA-256 of the uploaded image, or of the text
+// field if there is no image, or "" if the request has neither
+func ContentHash(r *http.Request) string {
+	if r.MultipartForm != nil {
+		if files := r.MultipartForm.File["image"]; len(files) > 0 {
+			file, err := files[0].Open()
+			if err != nil {
+				return ""
+			}
+			defer file.Close()
+			h := sha256.New()
+			if _, err := io.Copy(h, file); err != nil {
+				return ""
+			}
+			return hex.EncodeToString(h.Sum(nil))
+		}
+	}
+	if text := r.FormValue("text"); text != "" {
+		return TextHash(text)
+	}
+	return ""
+}
+
+// TextHash returns the hex SHA-256 of a text input
+func TextHash(text string) string {
+	sum := sha256.Sum256([]byte(text))
+	return hex.EncodeToString(sum[:])
+}
+
 // BuildEntriesForType builds the entries JSON structure for a specific type
 func BuildEntriesForType(entries []Entry) (map[string]interface{}, error) {
 	result := make(map[string]interface{})
@@ -248,12 +322,13 @@ func GetBackendURLForType(entries []Entry, getBackendURL func(task string) strin
 }
 
 // ForwardPredictRequest forwards the predict request to the appropriate backend based on task type
-func ForwardPredictRequest(backendURL string, r *http.Request) (*http.Response, error) {
-	client := &http.Client{
-		Timeout: 60 * time.Second,
+func ForwardPredictRequest(backend config.Backend, r *http.Request) (*http.Response, error) {
+	client, err := ClientFor(backend, 60*time.Second)
+	if err != nil {
+		return nil, err
 	}
 
-	targetURL := backendURL + "/predict"
+	targetURL := backend.URL + "/predict"
 
 	// Parse multipart form to access form data
 	if err := r.ParseMultipartForm(32 << 20); err != nil {
@@ -304,18 +379,24 @@ func ForwardPredictRequest(backendURL string, r *http.Request) (*http.Response,
 	// Copy headers
 	req.Header = r.Header.Clone()
 	req.Header.Set("Content-Type", writer.FormDataContentType())
+	applyBackendHeaders(req, backend)
 
 	return client.Do(req)
 }
 
-// ForwardPredictRequestWithType forwards the predict request with custom entries JSON
+// ForwardPredictRequestWithType forwards the predict request with custom entries JSON.
+// A non-zero timeout overrides the backend's request timeout.
 // Returns response and the actual request body bytes for debug purposes
-func ForwardPredictRequestWithType(backendURL string, r *http.Request, entriesJSON string) (*http.Response, []byte, error) {
-	client := &http.Client{
-		Timeout: 60 * time.Second,
+func ForwardPredictRequestWithType(backend config.Backend, r *http.Request, entriesJSON string, timeout time.Duration) (*http.Response, []byte, error) {
+	client, err := ClientFor(backend, 60*time.Second)
+	if err != nil {
+		return nil, nil, err
+	}
+	if timeout > 0 {
+		client.Timeout = timeout
 	}
 
-	targetURL := backendURL + "/predict"
+	targetURL := backend.URL + "/predict"
 
 	// Reconstruct multipart form with custom entries
 	body := &bytes.Buffer{}
@@ -369,7 +450,8 @@ func ForwardPredictRequestWithType(backendURL string, r *http.Request, entriesJS
 	// Get body bytes for debug before sending
 	bodyBytes := body.Bytes()
 
-	req, err := http.NewRequest("POST", targetURL, bytes.NewReader(bodyBytes))
+	ctx, recordTransfer := withTransferTrace(context.Background(), backend.Name, int64(len(bodyBytes)))
+	req, err := http.NewRequestWithContext(ctx, "POST", targetURL, bytes.NewReader(bodyBytes))
 	if err != nil {
 		return nil, nil, err
 	}
@@ -377,11 +459,54 @@ func ForwardPredictRequestWithType(backendURL string, r *http.Request, entriesJS
 	// Copy headers
 	req.Header = r.Header.Clone()
 	req.Header.Set("Content-Type", writer.FormDataContentType())
+	applyBackendHeaders(req, backend)
 
 	resp, err := client.Do(req)
 	if err != nil {
 		return nil, bodyBytes, err
 	}
+	recordTransfer()
 
 	return resp, bodyBytes, nil
-}
\ No newline at end of file
+}
+
+// SendPredict sends a predict request built by the proxy itself, such as a
+// deep health check, with the given entries and either an image or a text.
+// Transfers are not recorded since the request does not come from a client.
+// ctx bounds the request instead of the backend's request timeout.
+func SendPredict(ctx context.Context, backend config.Backend, entriesJSON string, image []byte, text string) (*http.Response, error) {
+	client, err := ClientFor(backend, 0)
+	if err != nil {
+		return nil, err
+	}
+	client.Timeout = 0
+
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	if err := writer.WriteField("entries", entriesJSON); err != nil {
+		return nil, err
+	}
+	if image != nil {
+		part, err := writer.CreateFormFile("image", "image.png")
+		if err != nil {
+			return nil, err
+		}
+		if _, err := part.Write(image); err != nil {
+			return nil, err
+		}
+	} else if err := writer.WriteField("text", text); err != nil {
+		return nil, err
+	}
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", backend.URL+"/predict", body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	applyBackendHeaders(req, backend)
+
+	return client.Do(req)
+}
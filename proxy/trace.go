@@ -0,0 +1,84 @@
+package proxy
+
+import (
+	"context"
+	"immich_ml_proxy/stats"
+	"net/http/httptrace"
+	"sync"
+	"time"
+)
+
+// withTransferTrace measures a predict request for bandwidth-aware routing:
+// TCP connect time as round-trip time, and the time to send the body and to
+// receive the response headers for upload throughput and server time. The
+// returned function records the transfer and must be called once the response
+// has arrived.
+func withTransferTrace(ctx context.Context, backendName string, bodyBytes int64) (context.Context, func()) {
+	var mu sync.Mutex
+	var gotConn, wroteRequest time.Time
+	connectStarts := make(map[string]time.Time) // dials may race, e.g. IPv4 and IPv6
+
+	ctx = httptrace.WithClientTrace(ctx, &httptrace.ClientTrace{
+		ConnectStart: func(network, addr string) {
+			mu.Lock()
+			defer mu.Unlock()
+			connectStarts[addr] = time.Now()
+		},
+		ConnectDone: func(network, addr string, err error) {
+			mu.Lock()
+			start, ok := connectStarts[addr]
+			mu.Unlock()
+			if err == nil && ok {
+				stats.GetInstance().RecordRTT(backendName, time.Since(start))
+			}
+		},
+		GotConn: func(httptrace.GotConnInfo) {
+			mu.Lock()
+			defer mu.Unlock()
+			gotConn = time.Now()
+		},
+		WroteRequest: func(info httptrace.WroteRequestInfo) {
+			mu.Lock()
+			defer mu.Unlock()
+			if info.Err == nil {
+				wroteRequest = time.Now()
+			}
+		},
+	})
+
+	// Not GotFirstResponseByte: with Expect: 100-continue the first byte
+	// arrives before the body is sent
+	done := func() {
+		mu.Lock()
+		defer mu.Unlock()
+		if gotConn.IsZero() || wroteRequest.IsZero() {
+			return
+		}
+		stats.GetInstance().RecordTransfer(backendName, bodyBytes, wroteRequest.Sub(gotConn), time.Since(gotConn))
+	}
+	return ctx, done
+}
+
+// withPingTrace measures the round-trip time of a /ping request, from the
+// request being sent to the first response byte
+func withPingTrace(ctx context.Context, backendName string) context.Context {
+	var mu sync.Mutex
+	var wroteRequest time.Time
+
+	return httptrace.WithClientTrace(ctx, &httptrace.ClientTrace{
+		WroteRequest: func(info httptrace.WroteRequestInfo) {
+			mu.Lock()
+			defer mu.Unlock()
+			if info.Err == nil {
+				wroteRequest = time.Now()
+			}
+		},
+		GotFirstResponseByte: func() {
+			mu.Lock()
+			defer mu.Unlock()
+			if !wroteRequest.IsZero() {
+				stats.GetInstance().RecordRTT(backendName, time.Since(wroteRequest))
+			}
+		},
+	})
+}
@@ -0,0 +1,141 @@
+package proxy
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"immich_ml_proxy/config"
+	"net/http"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+)
+
+// transportCache keeps one http.Transport per backend so connections are
+// reused. A transport is rebuilt when the backend's transport profile changes.
+type transportCache struct {
+	mu         sync.Mutex
+	transports map[string]*cachedTransport // backend name -> transport
+}
+
+type cachedTransport struct {
+	profile   string // JSON of the settings the transport was built from
+	transport *http.Transport
+}
+
+var transports = &transportCache{
+	transports: make(map[string]*cachedTransport),
+}
+
+// ClientFor returns an HTTP client for the backend. The backend's timeout
+// override replaces defaultTimeout when set.
+func ClientFor(backend config.Backend, defaultTimeout time.Duration) (*http.Client, error) {
+	transport, err := transports.get(backend)
+	if err != nil {
+		return nil, err
+	}
+	return &http.Client{
+		Transport: transport,
+		Timeout:   backend.Timeout.Or(defaultTimeout),
+	}, nil
+}
+
+// CloseIdleConnections closes idle connections to all backends
+func CloseIdleConnections() {
+	transports.mu.Lock()
+	defer transports.mu.Unlock()
+	for _, cached := range transports.transports {
+		cached.transport.CloseIdleConnections()
+	}
+}
+
+func (c *transportCache) get(backend config.Backend) (*http.Transport, error) {
+	profile, err := json.Marshal(struct {
+		TLS   *config.BackendTLS
+		Proxy string
+	}{backend.TLS, backend.Proxy})
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if cached, ok := c.transports[backend.Name]; ok {
+		if cached.profile == string(profile) {
+			return cached.transport, nil
+		}
+		cached.transport.CloseIdleConnections()
+	}
+
+	transport, err := newTransport(backend)
+	if err != nil {
+		return nil, fmt.Errorf("backend %s: %w", backend.Name, err)
+	}
+	c.transports[backend.Name] = &cachedTransport{profile: string(profile), transport: transport}
+	return transport, nil
+}
+
+// newTransport builds a transport for the backend's TLS and proxy settings
+func newTransport(backend config.Backend) (*http.Transport, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+
+	if backend.Proxy != "" {
+		proxyURL, err := url.Parse(backend.Proxy)
+		if err != nil {
+			return nil, fmt.Errorf("invalid proxy URL: %w", err)
+		}
+		// net/http handles http, https and socks5 proxy URLs
+		transport.Proxy = http.ProxyURL(proxyURL)
+	}
+
+	if backend.TLS != nil {
+		tlsConfig := &tls.Config{
+			ServerName:         backend.TLS.ServerName,
+			InsecureSkipVerify: backend.TLS.InsecureSkipVerify,
+		}
+
+		if backend.TLS.CAFile != "" {
+			pem, err := os.ReadFile(backend.TLS.CAFile)
+			if err != nil {
+				return nil, fmt.Errorf("reading CA file: %w", err)
+			}
+			pool := x509.NewCertPool()
+			if !pool.AppendCertsFromPEM(pem) {
+				return nil, fmt.Errorf("no certificates found in CA file %s", backend.TLS.CAFile)
+			}
+			tlsConfig.RootCAs = pool
+		}
+
+		if backend.TLS.CertFile != "" {
+			cert, err := tls.LoadX509KeyPair(backend.TLS.CertFile, backend.TLS.KeyFile)
+			if err != nil {
+				return nil, fmt.Errorf("loading client certificate: %w", err)
+			}
+			tlsConfig.Certificates = []tls.Certificate{cert}
+		}
+
+		transport.TLSClientConfig = tlsConfig
+	}
+
+	return transport, nil
+}
+
+// applyBackendHeaders sets the backend's static headers and credentials on req.
+// They take precedence over headers copied from the incoming request.
+func applyBackendHeaders(req *http.Request, backend config.Backend) {
+	for key, value := range backend.Headers {
+		req.Header.Set(key, value)
+	}
+
+	if backend.Auth != nil {
+		switch backend.Auth.Type {
+		case "bearer":
+			req.Header.Set("Authorization", "Bearer "+backend.Auth.Token)
+		case "basic":
+			req.SetBasicAuth(backend.Auth.Username, backend.Auth.Password)
+		}
+	}
+}
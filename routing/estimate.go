@@ -0,0 +1,46 @@
+package routing
+
+import (
+	"fmt"
+	"immich_ml_proxy/config"
+	"strings"
+	"time"
+)
+
+// selectByEstimate picks the backend expected to finish a request with a
+// payload of the given size first, from the measured round-trip time, upload
+// throughput and processing time of each backend. Healthy backends are
+// preferred. Backends that have not been measured yet are picked round-robin
+// first, so every backend gets measured. It also returns the reason for the trace.
+func (r *Router) selectByEstimate(key string, payloadBytes int64, candidates []config.Backend, peek bool) (*config.Backend, string) {
+	pool := r.cfg.FilterHealthy(candidates)
+	scope := "healthy"
+	if len(pool) == 0 {
+		// No healthy backends, use all backends
+		pool = candidates
+		scope = "all"
+	}
+
+	var unmeasured []config.Backend
+	var best *config.Backend
+	var bestEstimate time.Duration
+	estimates := make([]string, 0, len(pool))
+	for _, backend := range pool {
+		estimate, ok := r.load.EstimateCompletion(backend.Name, payloadBytes)
+		if !ok {
+			unmeasured = append(unmeasured, backend)
+			continue
+		}
+		estimates = append(estimates, fmt.Sprintf("%s %s", backend.Name, estimate.Round(time.Millisecond)))
+		if best == nil || estimate < bestEstimate {
+			b := backend
+			best, bestEstimate = &b, estimate
+		}
+	}
+
+	if len(unmeasured) > 0 {
+		backend := r.selectBackend(key, unmeasured, peek)
+		return backend, fmt.Sprintf("selected %s round-robin from %s, not measured yet", backend.Name, backendNames(unmeasured))
+	}
+	return best, fmt.Sprintf("selected %s by estimated completion time for %d bytes from %s [%s]", best.Name, payloadBytes, scope, strings.Join(estimates, ", "))
+}
@@ -0,0 +1,52 @@
+package routing
+
+import (
+	"immich_ml_proxy/config"
+	"testing"
+	"time"
+)
+
+func TestSelectByEstimate(t *testing.T) {
+	tests := []struct {
+		name      string
+		estimates map[string]time.Duration
+		unhealthy []string
+		want      []string // acceptable picks
+	}{
+		{"nothing measured", nil, nil, []string{"a", "b", "c"}},
+		{"unmeasured first", map[string]time.Duration{"a": time.Microsecond, "b": time.Microsecond}, nil, []string{"c"}},
+		{"lowest estimate", map[string]time.Duration{"a": 3 * time.Microsecond, "b": time.Microsecond, "c": 2 * time.Microsecond}, nil, []string{"b"}},
+		{"unhealthy fastest", map[string]time.Duration{"a": 3 * time.Microsecond, "b": time.Microsecond, "c": 2 * time.Microsecond}, []string{"b"}, []string{"c"}},
+		{"unhealthy unmeasured", map[string]time.Duration{"a": 3 * time.Microsecond, "b": time.Microsecond}, []string{"c"}, []string{"b"}},
+		{"all unhealthy", map[string]time.Duration{"a": 3 * time.Microsecond, "b": time.Microsecond, "c": 2 * time.Microsecond}, []string{"a", "b", "c"}, []string{"b"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			pool := backends("a", "b", "c")
+			r := testRouter(t, config.Settings{DefaultBackend: "a", Backends: pool}, fakeLoad{estimates: test.estimates})
+			for _, name := range test.unhealthy {
+				config.Load().SetHealthStatus(name, config.HealthStatusUnhealthy, "down")
+			}
+
+			backend, reason := r.selectByEstimate("rule", 1000, pool, true)
+			if backend == nil || !contains(test.want, backend.Name) {
+				t.Errorf("selected %v, want one of %v: %s", backend, test.want, reason)
+			}
+		})
+	}
+}
+
+func TestSelectByEstimateMeasuresEveryBackend(t *testing.T) {
+	pool := backends("a", "b", "c")
+	r := testRouter(t, config.Settings{DefaultBackend: "a", Backends: pool}, fakeLoad{estimates: map[string]time.Duration{"a": time.Microsecond}})
+
+	// Unmeasured backends take turns until they have been measured
+	seen := make(map[string]bool)
+	for i := 0; i < 4; i++ {
+		backend, _ := r.selectByEstimate("rule", 1000, pool, false)
+		seen[backend.Name] = true
+	}
+	if !seen["b"] || !seen["c"] || seen["a"] {
+		t.Errorf("selected %v, want b and c in turn", seen)
+	}
+}
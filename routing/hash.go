@@ -0,0 +1,71 @@
+package routing
+
+import (
+	"fmt"
+	"hash/fnv"
+	"immich_ml_proxy/config"
+	"math"
+	"sort"
+)
+
+// boundedLoadFactor caps the in-flight requests of a backend chosen by
+// consistent hashing at this multiple of the pool's average, so a burst of
+// requests for the same content cannot pile up on one backend
+const boundedLoadFactor = 1.25
+
+// selectByHash picks a backend by rendezvous hashing of key: every backend
+// gets a score from the key and its name, and the highest score wins. Adding
+// or removing a backend only moves the keys that scored highest on it.
+// Healthy backends are preferred, and a backend above the load bound is
+// passed over for the next-highest score. It also returns the reason for the trace.
+func (r *Router) selectByHash(key string, candidates []config.Backend) (*config.Backend, string) {
+	pool := r.cfg.FilterHealthy(candidates)
+	scope := "healthy"
+	if len(pool) == 0 {
+		// No healthy backends, use all backends
+		pool = candidates
+		scope = "all"
+	}
+
+	type scored struct {
+		backend config.Backend
+		score   uint64
+	}
+	ranked := make([]scored, 0, len(pool))
+	total := 1 // this request
+	for _, backend := range pool {
+		ranked = append(ranked, scored{backend, rendezvousScore(key, backend.Name)})
+		total += r.load.InFlight(backend.Name)
+	}
+	sort.Slice(ranked, func(i, j int) bool { return ranked[i].score > ranked[j].score })
+
+	bound := int(math.Ceil(boundedLoadFactor * float64(total) / float64(len(ranked))))
+	for i, candidate := range ranked {
+		if r.load.InFlight(candidate.backend.Name)+1 <= bound {
+			backend := candidate.backend
+			if i == 0 {
+				return &backend, fmt.Sprintf("selected %s by content hash from %s %s", backend.Name, scope, backendNames(pool))
+			}
+			return &backend, fmt.Sprintf("selected %s by content hash from %s %s, %s is above the load bound of %d", backend.Name, scope, backendNames(pool), ranked[0].backend.Name, bound)
+		}
+	}
+	backend := ranked[0].backend
+	return &backend, fmt.Sprintf("selected %s by content hash from %s %s", backend.Name, scope, backendNames(pool))
+}
+
+// rendezvousScore returns the score of a backend for a key
+func rendezvousScore(key, backendName string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	h.Write([]byte{0})
+	h.Write([]byte(backendName))
+
+	// FNV mixes similar short inputs poorly, finish with the splitmix64 mixer
+	x := h.Sum64()
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
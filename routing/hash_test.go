@@ -0,0 +1,104 @@
+package routing
+
+import (
+	"fmt"
+	"immich_ml_proxy/config"
+	"testing"
+)
+
+// hashOwners returns the backend each key is routed to
+func hashOwners(r *Router, keys []string, candidates []config.Backend) map[string]string {
+	owners := make(map[string]string)
+	for _, key := range keys {
+		backend, _ := r.selectByHash(key, candidates)
+		owners[key] = backend.Name
+	}
+	return owners
+}
+
+func hashKeys(n int) []string {
+	keys := make([]string, n)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("image-%d", i)
+	}
+	return keys
+}
+
+func TestSelectByHashIsStable(t *testing.T) {
+	pool := backends("a", "b", "c")
+	r := testRouter(t, config.Settings{DefaultBackend: "a", Backends: pool}, fakeLoad{})
+	keys := hashKeys(300)
+
+	first := hashOwners(r, keys, pool)
+	if again := hashOwners(r, keys, pool); fmt.Sprint(again) != fmt.Sprint(first) {
+		t.Error("the same keys were routed differently on the second pass")
+	}
+	counts := make(map[string]int)
+	for _, owner := range first {
+		counts[owner]++
+	}
+	for _, backend := range pool {
+		if counts[backend.Name] < 50 {
+			t.Errorf("%s got %d of 300 keys, want a roughly even spread: %v", backend.Name, counts[backend.Name], counts)
+		}
+	}
+}
+
+func TestSelectByHashRemovalMovesOnlyItsKeys(t *testing.T) {
+	pool := backends("a", "b", "c", "d")
+	r := testRouter(t, config.Settings{DefaultBackend: "a", Backends: pool}, fakeLoad{})
+	keys := hashKeys(300)
+
+	before := hashOwners(r, keys, pool)
+	after := hashOwners(r, keys, pool[:3])
+	for _, key := range keys {
+		if before[key] != "d" && after[key] != before[key] {
+			t.Errorf("%s moved from %s to %s when d was removed", key, before[key], after[key])
+		}
+	}
+}
+
+func TestSelectByHashBoundedLoad(t *testing.T) {
+	pool := backends("a", "b", "c")
+	key := "image-1"
+	owner := hashOwners(testRouter(t, config.Settings{DefaultBackend: "a", Backends: pool}, fakeLoad{}), []string{key}, pool)[key]
+
+	tests := []struct {
+		name     string
+		inFlight map[string]int
+		moved    bool
+	}{
+		{"idle pool", nil, false},
+		{"owner within the bound", map[string]int{owner: 2, "a": 2, "b": 2, "c": 2}, false},
+		{"owner above the bound", map[string]int{owner: 5}, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := testRouter(t, config.Settings{DefaultBackend: "a", Backends: pool}, fakeLoad{inFlight: test.inFlight})
+			backend, reason := r.selectByHash(key, pool)
+			if moved := backend.Name != owner; moved != test.moved {
+				t.Errorf("selected %s with %s owning the key, want moved %v: %s", backend.Name, owner, test.moved, reason)
+			}
+		})
+	}
+}
+
+func TestSelectByHashPrefersHealthy(t *testing.T) {
+	pool := backends("a", "b", "c")
+	key := "image-1"
+	r := testRouter(t, config.Settings{DefaultBackend: "a", Backends: pool}, fakeLoad{})
+	owner := hashOwners(r, []string{key}, pool)[key]
+
+	config.Load().SetHealthStatus(owner, config.HealthStatusUnhealthy, "down")
+	if backend, reason := r.selectByHash(key, pool); backend.Name == owner {
+		t.Errorf("selected unhealthy %s: %s", owner, reason)
+	}
+
+	// Without healthy backends the key keeps its owner
+	for _, backend := range pool {
+		config.Load().SetHealthStatus(backend.Name, config.HealthStatusUnhealthy, "down")
+	}
+	if backend, reason := r.selectByHash(key, pool); backend.Name != owner {
+		t.Errorf("selected %s with all backends down, want %s: %s", backend.Name, owner, reason)
+	}
+}
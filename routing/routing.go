@@ -0,0 +1,498 @@
+package routing
+
+import (
+	"fmt"
+	"immich_ml_proxy/config"
+	"immich_ml_proxy/discovery"
+	"immich_ml_proxy/outlier"
+	"immich_ml_proxy/proxy"
+	"math"
+	"math/rand"
+	"net"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+)
+
+// Request holds the attributes of one type group of a predict request that
+// routing rules can match on
+type Request struct {
+	Task      string
+	ModelType string
+	ModelName string
+	ImageSize int64 // bytes of the uploaded image, 0 if none
+	ClientIP  string
+	Header    http.Header
+
+	// ContentKey returns a hash of the uploaded image or text. It is only
+	// called for rules that balance by consistent hash and may be nil.
+	ContentKey func() string
+}
+
+// Load reports the current load of backends, used by tier spill conditions
+type Load interface {
+	InFlight(backendName string) int             // requests sent to the backend
+	Queued(backendName string) int               // requests waiting for a slot
+	LatencyP95(backendName string) time.Duration // 0 if unknown
+
+	// EstimateCompletion estimates how long a request with the given payload
+	// takes on the backend, false if the backend has not been measured yet
+	EstimateCompletion(backendName string, payloadBytes int64) (time.Duration, bool)
+}
+
+// Decision is the outcome of routing a request
+type Decision struct {
+	Rule       string           `json:"rule"`   // name of the matched rule, or the routing map entry
+	Target     string           `json:"target"` // backend name or label selector of the rule or tier
+	Tier       int              `json:"tier"`   // 1-based tier of a rule with tiers, 0 otherwise
+	Candidates []config.Backend `json:"-"`
+	Backend    *config.Backend  `json:"-"`
+	Timeout    time.Duration    `json:"-"`
+	Retries    int              `json:"retries"`
+	Priority   string           `json:"priority"` // priority class used when the backend's slots are full
+	Implicit   bool             `json:"implicit"` // rule comes from taskRouting, modelTypeRouting or defaultBackend
+	Trace      []string         `json:"trace"`    // why rules matched or were skipped
+
+	hashKey   string // content key if the rule balances by consistent hash
+	leastTime bool   // the rule balances by estimated completion time
+	payload   int64  // image size for least-time balancing
+}
+
+// rule is a routing rule or one of the implicit rules built from the routing maps
+type rule struct {
+	config.RoutingRule
+	implicit bool
+}
+
+// Router resolves predict requests to backends
+type Router struct {
+	cfg      *config.Config
+	load     Load
+	balancer *proxy.RoundRobinBalancer
+}
+
+// NewRouter creates a router over the given configuration. load is consulted
+// for the spill conditions of tiers.
+func NewRouter(cfg *config.Config, load Load) *Router {
+	return &Router{
+		cfg:      cfg,
+		load:     load,
+		balancer: proxy.NewRoundRobinBalancer(),
+	}
+}
+
+// Route finds the first matching rule and selects a backend from its pool
+func (r *Router) Route(req Request) Decision {
+	return r.route(req, false)
+}
+
+// Explain routes like Route but does not advance the balancer, so it can be
+// used to show which backend would be chosen without affecting traffic
+func (r *Router) Explain(req Request) Decision {
+	return r.route(req, true)
+}
+
+// Next selects another backend from the decision's pool for a retry,
+// skipping backends that were already tried. It returns nil when none are left.
+func (r *Router) Next(decision Decision, tried map[string]bool) *config.Backend {
+	var remaining []config.Backend
+	for _, backend := range decision.Candidates {
+		if !tried[backend.Name] {
+			remaining = append(remaining, backend)
+		}
+	}
+	if len(remaining) == 0 {
+		return nil
+	}
+	if decision.hashKey != "" {
+		backend, _ := r.selectByHash(decision.hashKey, remaining)
+		return backend
+	}
+	if decision.leastTime {
+		backend, _ := r.selectByEstimate(decision.Rule, decision.payload, remaining, false)
+		return backend
+	}
+	return r.selectBackend(decision.Rule, remaining, false)
+}
+
+func (r *Router) route(req Request, peek bool) Decision {
+	var decision Decision
+
+	for _, rl := range r.rules(req) {
+		if reason, ok := rl.matches(req); !ok {
+			decision.Trace = append(decision.Trace, fmt.Sprintf("%s: skipped, %s", rl.Name, reason))
+			continue
+		}
+
+		target, tier, candidates := rl.Target, 0, r.cfg.ResolveTarget(rl.Target)
+		if len(rl.Tiers) > 0 {
+			var trace []string
+			target, tier, candidates, trace = r.selectTier(rl)
+			decision.Trace = append(decision.Trace, trace...)
+		}
+		if len(candidates) == 0 {
+			decision.Trace = append(decision.Trace, fmt.Sprintf("%s: matched, but target %q resolves to no backends", rl.Name, target))
+			continue
+		}
+		candidates, ejected := withoutOutliers(candidates)
+		candidates, failing := r.withoutFailingTask(candidates, req)
+		candidates, incapable := withoutIncapable(candidates, req)
+
+		decision.Rule = rl.Name
+		decision.Target = target
+		decision.Tier = tier
+		decision.Candidates = candidates
+		decision.Timeout = rl.Timeout.Std()
+		decision.Retries = rl.Retries
+		decision.Priority = rl.Priority
+		if decision.Priority == "" {
+			decision.Priority = config.DefaultPriorityClass
+		}
+		decision.Implicit = rl.implicit
+		decision.Trace = append(decision.Trace, fmt.Sprintf("%s: matched, target %q resolves to %s", rl.Name, target, backendNames(candidates)))
+		decision.Trace = append(decision.Trace, ejected...)
+		decision.Trace = append(decision.Trace, failing...)
+		decision.Trace = append(decision.Trace, incapable...)
+
+		// Backends in their slow-start window may be passed over for this request
+		candidates, notes := r.slowStart(candidates, peek)
+		decision.Trace = append(decision.Trace, notes...)
+
+		if rl.Balance == config.BalanceConsistentHash && req.ContentKey != nil {
+			decision.hashKey = req.ContentKey()
+		}
+		if decision.hashKey != "" {
+			var reason string
+			decision.Backend, reason = r.selectByHash(decision.hashKey, candidates)
+			decision.Trace = append(decision.Trace, reason)
+			return decision
+		}
+		if rl.Balance == config.BalanceConsistentHash {
+			decision.Trace = append(decision.Trace, "no image or text to hash, falling back to round-robin")
+		}
+		if rl.Balance == config.BalanceLeastTime {
+			decision.leastTime = true
+			decision.payload = req.ImageSize
+			var reason string
+			decision.Backend, reason = r.selectByEstimate(rl.Name, req.ImageSize, candidates, peek)
+			decision.Trace = append(decision.Trace, reason)
+			return decision
+		}
+		decision.Backend = r.selectBackend(rl.Name, candidates, peek)
+		if decision.Backend != nil {
+			decision.Trace = append(decision.Trace, r.selectionReason(candidates, decision.Backend))
+		}
+		return decision
+	}
+
+	decision.Trace = append(decision.Trace, "no rule matched")
+	return decision
+}
+
+// selectTier returns the first tier of the rule whose spill conditions do not
+// hold. The last tier takes whatever spills from the tiers before it; if it has
+// no backends, the last tier that has some is used.
+func (r *Router) selectTier(rl rule) (string, int, []config.Backend, []string) {
+	var trace []string
+	lastTarget, lastTier, lastCandidates := "", 0, []config.Backend(nil)
+
+	for i, tier := range rl.Tiers {
+		candidates := r.cfg.ResolveTarget(tier.Target)
+		if len(candidates) == 0 {
+			trace = append(trace, fmt.Sprintf("%s: tier %d %q resolves to no backends", rl.Name, i+1, tier.Target))
+			continue
+		}
+		if i == len(rl.Tiers)-1 {
+			return tier.Target, i + 1, candidates, trace
+		}
+		if reason, spill := r.spills(tier, candidates); spill {
+			trace = append(trace, fmt.Sprintf("%s: tier %d %q spills, %s", rl.Name, i+1, tier.Target, reason))
+			lastTarget, lastTier, lastCandidates = tier.Target, i+1, candidates
+			continue
+		}
+		return tier.Target, i + 1, candidates, trace
+	}
+
+	if lastCandidates != nil {
+		trace = append(trace, fmt.Sprintf("%s: no later tier has backends, staying on tier %d", rl.Name, lastTier))
+	}
+	return lastTarget, lastTier, lastCandidates, trace
+}
+
+// spills reports whether a tier is too busy for new requests, with the reason
+func (r *Router) spills(tier config.Tier, candidates []config.Backend) (string, bool) {
+	var available []config.Backend
+	for _, backend := range candidates {
+		if r.cfg.GetHealthStatus(backend.Name).Status != config.HealthStatusUnhealthy {
+			available = append(available, backend)
+		}
+	}
+	if len(available) == 0 {
+		return "no healthy backends", true
+	}
+
+	if limit := tier.SpillWhen.QueueLength; limit > 0 {
+		queued := 0
+		for _, backend := range available {
+			queued += r.load.Queued(backend.Name)
+		}
+		if queued > limit {
+			return fmt.Sprintf("%d queued > %d", queued, limit), true
+		}
+	}
+
+	if threshold := tier.SpillWhen.LatencyP95.Std(); threshold > 0 {
+		// Spill only if even the fastest backend is too slow
+		var fastest time.Duration
+		for i, backend := range available {
+			if p95 := r.load.LatencyP95(backend.Name); i == 0 || p95 < fastest {
+				fastest = p95
+			}
+		}
+		if fastest > threshold {
+			return fmt.Sprintf("p95 latency %s > %s", fastest.Round(time.Millisecond), threshold), true
+		}
+	}
+	return "", false
+}
+
+// rules returns the configured rules followed by the implicit rules of the
+// routing maps for this request, in the order the proxy has always applied
+// them: taskRouting[task.modelType], modelTypeRouting[modelType],
+// taskRouting[task], defaultBackend
+//
+// The routing of the active profile applies, if a schedule activated one.
+func (r *Router) rules(req Request) []rule {
+	active := r.cfg.ActiveRouting()
+	var rules []rule
+	for _, rl := range active.Rules {
+		rules = append(rules, rule{RoutingRule: rl})
+	}
+
+	taskRouting := active.TaskRouting
+	modelTypeRouting := active.ModelTypeRouting
+
+	taskType := req.Task + "." + req.ModelType
+	if target, ok := taskRouting[taskType]; ok {
+		rules = append(rules, implicitRule(fmt.Sprintf("taskRouting[%s]", taskType), target))
+	}
+	if target, ok := modelTypeRouting[req.ModelType]; ok {
+		rules = append(rules, implicitRule(fmt.Sprintf("modelTypeRouting[%s]", req.ModelType), target))
+	}
+	if target, ok := taskRouting[req.Task]; ok {
+		rules = append(rules, implicitRule(fmt.Sprintf("taskRouting[%s]", req.Task), target))
+	}
+	if active.DefaultBackend != "" {
+		rules = append(rules, implicitRule("defaultBackend", active.DefaultBackend))
+	}
+	return rules
+}
+
+func implicitRule(name, target string) rule {
+	return rule{
+		RoutingRule: config.RoutingRule{Name: name, Target: target},
+		implicit:    true,
+	}
+}
+
+// matches reports whether the request satisfies the rule, with the reason if not
+func (rl rule) matches(req Request) (string, bool) {
+	m := rl.Match
+
+	if len(m.Tasks) > 0 && !contains(m.Tasks, req.Task) {
+		return fmt.Sprintf("task %q not in %v", req.Task, m.Tasks), false
+	}
+	if len(m.ModelTypes) > 0 && !contains(m.ModelTypes, req.ModelType) {
+		return fmt.Sprintf("modelType %q not in %v", req.ModelType, m.ModelTypes), false
+	}
+	if len(m.ModelNames) > 0 && !matchesAny(m.ModelNames, req.ModelName) {
+		return fmt.Sprintf("modelName %q does not match %v", req.ModelName, m.ModelNames), false
+	}
+	if m.MinImageBytes > 0 && req.ImageSize < m.MinImageBytes {
+		return fmt.Sprintf("image size %d < %d", req.ImageSize, m.MinImageBytes), false
+	}
+	if m.MaxImageBytes > 0 && req.ImageSize > m.MaxImageBytes {
+		return fmt.Sprintf("image size %d > %d", req.ImageSize, m.MaxImageBytes), false
+	}
+	if len(m.Clients) > 0 && !clientMatches(m.Clients, req.ClientIP) {
+		return fmt.Sprintf("client %q not in %v", req.ClientIP, m.Clients), false
+	}
+	for header, pattern := range m.Headers {
+		value := req.Header.Get(header)
+		if _, present := req.Header[http.CanonicalHeaderKey(header)]; !present {
+			return fmt.Sprintf("header %s missing", header), false
+		}
+		if matched, _ := path.Match(pattern, value); !matched {
+			return fmt.Sprintf("header %s=%q does not match %q", header, value, pattern), false
+		}
+	}
+	return "", true
+}
+
+// withoutOutliers removes backends ejected by outlier detection from
+// candidates, unless all of them are ejected. It also returns notes for the trace.
+func withoutOutliers(candidates []config.Backend) ([]config.Backend, []string) {
+	var result []config.Backend
+	var notes []string
+	for _, backend := range candidates {
+		if ejection, ok := outlier.GetInstance().Ejected(backend.Name); ok {
+			notes = append(notes, fmt.Sprintf("outlier: %s ejected until %s, %s", backend.Name, ejection.Until.Format(time.TimeOnly), ejection.Reason))
+			continue
+		}
+		result = append(result, backend)
+	}
+	if len(result) == 0 {
+		return candidates, append(notes, "all backends are ejected, ignoring ejections")
+	}
+	return result, notes
+}
+
+// withoutFailingTask removes backends whose last deep check of the requested
+// task failed from candidates, unless it failed on all of them. It also
+// returns notes for the trace.
+func (r *Router) withoutFailingTask(candidates []config.Backend, req Request) ([]config.Backend, []string) {
+	key := config.TaskKey(req.Task, req.ModelType)
+	var result []config.Backend
+	var notes []string
+	for _, backend := range candidates {
+		if health, ok := r.cfg.GetTaskHealth(backend.Name, key); ok && health.Status != config.HealthStatusHealthy {
+			notes = append(notes, fmt.Sprintf("deep check: %s fails %s, %s", backend.Name, key, health.Error))
+			continue
+		}
+		result = append(result, backend)
+	}
+	if len(result) == 0 {
+		return candidates, append(notes, fmt.Sprintf("%s fails on all backends, ignoring deep checks", key))
+	}
+	return result, notes
+}
+
+// withoutIncapable removes backends that discovery found cannot serve the
+// requested model from candidates, unless none of them can. It also returns
+// notes for the trace.
+func withoutIncapable(candidates []config.Backend, req Request) ([]config.Backend, []string) {
+	var result []config.Backend
+	var notes []string
+	for _, backend := range candidates {
+		if supported, known := discovery.GetInstance().Supports(backend.Name, req.Task, req.ModelType, req.ModelName); known && !supported {
+			notes = append(notes, fmt.Sprintf("capabilities: %s cannot serve %s %s %s", backend.Name, req.Task, req.ModelType, req.ModelName))
+			continue
+		}
+		result = append(result, backend)
+	}
+	if len(result) == 0 {
+		return candidates, append(notes, "no backend can serve the model, ignoring capabilities")
+	}
+	return result, notes
+}
+
+// slowStart drops healthy backends that are ramping up after turning healthy
+// from candidates at random, keeping each with the probability of its weight
+// relative to the highest weight in the pool. With peek set no backend is
+// dropped. It also returns notes for the trace.
+func (r *Router) slowStart(candidates []config.Backend, peek bool) ([]config.Backend, []string) {
+	weights := make(map[string]float64)
+	highest := 0.0
+	for _, backend := range r.cfg.FilterHealthy(candidates) {
+		weights[backend.Name] = r.cfg.SlowStartWeight(backend)
+		highest = math.Max(highest, weights[backend.Name])
+	}
+
+	var result []config.Backend
+	var notes []string
+	for _, backend := range candidates {
+		weight, healthy := weights[backend.Name]
+		if !healthy || weight >= highest {
+			result = append(result, backend)
+			continue
+		}
+		if peek || rand.Float64() < weight/highest {
+			notes = append(notes, fmt.Sprintf("slow start: %s at %.0f%% weight", backend.Name, weight*100))
+			result = append(result, backend)
+		} else {
+			notes = append(notes, fmt.Sprintf("slow start: %s at %.0f%% weight, passed over", backend.Name, weight*100))
+		}
+	}
+	return result, notes
+}
+
+// selectBackend picks a backend from candidates using round-robin, preferring
+// healthy backends. With peek set the balancer is not advanced.
+func (r *Router) selectBackend(key string, candidates []config.Backend, peek bool) *config.Backend {
+	pool := r.cfg.FilterHealthy(candidates)
+	if len(pool) == 0 {
+		// No healthy backends, use all backends
+		pool = candidates
+	}
+
+	names := make([]string, 0, len(pool))
+	for _, b := range pool {
+		names = append(names, b.Name)
+	}
+
+	var selectedName string
+	if peek {
+		selectedName = r.balancer.PeekNextBackend(key, names)
+	} else {
+		selectedName = r.balancer.GetNextBackend(key, names)
+	}
+	for _, b := range pool {
+		if b.Name == selectedName {
+			return &b
+		}
+	}
+	return nil
+}
+
+// selectionReason explains why backend was picked from candidates
+func (r *Router) selectionReason(candidates []config.Backend, backend *config.Backend) string {
+	healthy := r.cfg.FilterHealthy(candidates)
+	if len(healthy) == 0 {
+		return fmt.Sprintf("no healthy backends, selected %s round-robin from all %d", backend.Name, len(candidates))
+	}
+	return fmt.Sprintf("selected %s round-robin from healthy %s", backend.Name, backendNames(healthy))
+}
+
+func backendNames(backends []config.Backend) string {
+	names := make([]string, 0, len(backends))
+	for _, b := range backends {
+		names = append(names, b.Name)
+	}
+	return "[" + strings.Join(names, ", ") + "]"
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func matchesAny(patterns []string, value string) bool {
+	for _, pattern := range patterns {
+		if matched, _ := path.Match(pattern, value); matched {
+			return true
+		}
+	}
+	return false
+}
+
+func clientMatches(clients []string, clientIP string) bool {
+	ip := net.ParseIP(clientIP)
+	if ip == nil {
+		return false
+	}
+	for _, client := range clients {
+		if strings.Contains(client, "/") {
+			if _, network, err := net.ParseCIDR(client); err == nil && network.Contains(ip) {
+				return true
+			}
+		} else if other := net.ParseIP(client); other != nil && other.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,189 @@
+package routing
+
+import (
+	"immich_ml_proxy/config"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestMain(m *testing.M) {
+	dir, err := os.MkdirTemp("", "routing")
+	if err != nil {
+		panic(err)
+	}
+	config.UseStore(config.NewFileStore(filepath.Join(dir, "config.json")))
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+// fakeLoad reports fixed load figures per backend
+type fakeLoad struct {
+	inFlight  map[string]int
+	queued    map[string]int
+	p95       map[string]time.Duration
+	estimates map[string]time.Duration // completion time per payload byte
+}
+
+func (l fakeLoad) InFlight(backendName string) int             { return l.inFlight[backendName] }
+func (l fakeLoad) Queued(backendName string) int               { return l.queued[backendName] }
+func (l fakeLoad) LatencyP95(backendName string) time.Duration { return l.p95[backendName] }
+
+func (l fakeLoad) EstimateCompletion(backendName string, payloadBytes int64) (time.Duration, bool) {
+	perByte, ok := l.estimates[backendName]
+	return perByte * time.Duration(payloadBytes), ok
+}
+
+// testRouter replaces the shared configuration with settings whose backends
+// are all healthy and returns a router over it
+func testRouter(t *testing.T, settings config.Settings, load Load) *Router {
+	t.Helper()
+	cfg := config.Load()
+	if _, err := cfg.Replace("", settings); err != nil {
+		t.Fatal(err)
+	}
+	for _, backend := range settings.Backends {
+		cfg.SetHealthStatus(backend.Name, config.HealthStatusHealthy, "")
+	}
+	return NewRouter(cfg, load)
+}
+
+func backends(names ...string) []config.Backend {
+	var result []config.Backend
+	for _, name := range names {
+		result = append(result, config.Backend{Name: name, URL: "http://" + name + ":3003"})
+	}
+	return result
+}
+
+func TestRuleOrder(t *testing.T) {
+	r := testRouter(t, config.Settings{
+		DefaultBackend:   "default",
+		Backends:         backends("default", "rule", "tasktype", "modeltype", "task"),
+		TaskRouting:      map[string]string{"clip.textual": "tasktype", "clip": "task", "ocr": "task"},
+		ModelTypeRouting: map[string]string{"textual": "modeltype", "visual": "modeltype"},
+		Rules: []config.RoutingRule{
+			{Name: "big-images", Match: config.RuleMatch{Tasks: []string{"clip"}, MinImageBytes: 1000}, Target: "rule"},
+		},
+	}, fakeLoad{})
+
+	tests := []struct {
+		name string
+		req  Request
+		rule string
+	}{
+		{"rules come first", Request{Task: "clip", ModelType: "visual", ImageSize: 2000}, "big-images"},
+		{"task.modelType before modelType", Request{Task: "clip", ModelType: "textual"}, "taskRouting[clip.textual]"},
+		{"modelType before task", Request{Task: "clip", ModelType: "visual", ImageSize: 10}, "modelTypeRouting[visual]"},
+		{"task", Request{Task: "ocr", ModelType: "detection"}, "taskRouting[ocr]"},
+		{"default backend last", Request{Task: "facial-recognition", ModelType: "detection"}, "defaultBackend"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			decision := r.Explain(test.req)
+			if decision.Rule != test.rule {
+				t.Errorf("routed by %q, want %q; trace %v", decision.Rule, test.rule, decision.Trace)
+			}
+			if decision.Implicit != (test.rule != "big-images") {
+				t.Errorf("implicit %v for rule %s", decision.Implicit, decision.Rule)
+			}
+		})
+	}
+}
+
+func TestRuleMatches(t *testing.T) {
+	header := http.Header{}
+	header.Set("X-Client", "immich-web")
+	tests := []struct {
+		name  string
+		match config.RuleMatch
+		req   Request
+		want  bool
+	}{
+		{"empty match", config.RuleMatch{}, Request{Task: "clip"}, true},
+		{"task", config.RuleMatch{Tasks: []string{"ocr"}}, Request{Task: "clip"}, false},
+		{"model type", config.RuleMatch{ModelTypes: []string{"textual"}}, Request{ModelType: "textual"}, true},
+		{"model name glob", config.RuleMatch{ModelNames: []string{"ViT-*"}}, Request{ModelName: "ViT-B-32__openai"}, true},
+		{"model name mismatch", config.RuleMatch{ModelNames: []string{"ViT-*"}}, Request{ModelName: "buffalo_l"}, false},
+		{"below min image size", config.RuleMatch{MinImageBytes: 100}, Request{ImageSize: 99}, false},
+		{"above max image size", config.RuleMatch{MaxImageBytes: 100}, Request{ImageSize: 101}, false},
+		{"client in CIDR", config.RuleMatch{Clients: []string{"10.0.0.0/8"}}, Request{ClientIP: "10.1.2.3"}, true},
+		{"client IP", config.RuleMatch{Clients: []string{"192.168.1.5"}}, Request{ClientIP: "192.168.1.6"}, false},
+		{"header pattern", config.RuleMatch{Headers: map[string]string{"x-client": "immich-*"}}, Request{Header: header}, true},
+		{"header present", config.RuleMatch{Headers: map[string]string{"X-Client": "*"}}, Request{Header: header}, true},
+		{"header missing", config.RuleMatch{Headers: map[string]string{"X-Priority": "*"}}, Request{Header: header}, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rl := rule{RoutingRule: config.RoutingRule{Name: test.name, Match: test.match}}
+			if reason, ok := rl.matches(test.req); ok != test.want {
+				t.Errorf("matches %v (%s), want %v", ok, reason, test.want)
+			}
+		})
+	}
+}
+
+func TestRuleWithoutBackendsFallsThrough(t *testing.T) {
+	r := testRouter(t, config.Settings{
+		DefaultBackend: "default",
+		Backends:       backends("default"),
+		Rules: []config.RoutingRule{
+			{Name: "gpus", Match: config.RuleMatch{Tasks: []string{"clip"}}, Target: "gpu=true"},
+		},
+	}, fakeLoad{})
+
+	decision := r.Explain(Request{Task: "clip", ModelType: "visual"})
+	if decision.Rule != "defaultBackend" || decision.Backend == nil || decision.Backend.Name != "default" {
+		t.Errorf("routed by %q to %v, want the default backend; trace %v", decision.Rule, decision.Backend, decision.Trace)
+	}
+}
+
+func TestSpillover(t *testing.T) {
+	tiers := []config.Tier{
+		{Target: "home", SpillWhen: config.SpillCondition{QueueLength: 2, LatencyP95: config.Duration(500 * time.Millisecond)}},
+		{Target: "cloud"},
+	}
+	tests := []struct {
+		name      string
+		tiers     []config.Tier
+		load      fakeLoad
+		unhealthy string
+		tier      int
+		backend   string
+	}{
+		{"idle first tier", tiers, fakeLoad{}, "", 1, "home"},
+		{"queue at the limit", tiers, fakeLoad{queued: map[string]int{"home": 2}}, "", 1, "home"},
+		{"queue over the limit", tiers, fakeLoad{queued: map[string]int{"home": 3}}, "", 2, "cloud"},
+		{"slow first tier", tiers, fakeLoad{p95: map[string]time.Duration{"home": time.Second}}, "", 2, "cloud"},
+		{"unhealthy first tier", tiers, fakeLoad{}, "home", 2, "cloud"},
+		{"busy last tier takes the spill", tiers, fakeLoad{queued: map[string]int{"home": 3, "cloud": 10}}, "", 2, "cloud"},
+		{
+			"later tier without backends",
+			[]config.Tier{tiers[0], {Target: "gpu=true"}},
+			fakeLoad{queued: map[string]int{"home": 3}}, "", 1, "home",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := testRouter(t, config.Settings{
+				DefaultBackend: "home",
+				Backends:       backends("home", "cloud"),
+				Rules:          []config.RoutingRule{{Name: "clip", Match: config.RuleMatch{Tasks: []string{"clip"}}, Tiers: test.tiers}},
+			}, test.load)
+			if test.unhealthy != "" {
+				config.Load().SetHealthStatus(test.unhealthy, config.HealthStatusUnhealthy, "down")
+			}
+
+			decision := r.Explain(Request{Task: "clip", ModelType: "visual"})
+			if decision.Rule != "clip" || decision.Tier != test.tier {
+				t.Fatalf("routed by %q tier %d, want tier %d; trace %v", decision.Rule, decision.Tier, test.tier, decision.Trace)
+			}
+			if decision.Backend == nil || decision.Backend.Name != test.backend {
+				t.Errorf("routed to %v, want %s; trace %v", decision.Backend, test.backend, decision.Trace)
+			}
+		})
+	}
+}
@@ -0,0 +1,103 @@
+package scheduler
+
+import (
+	"immich_ml_proxy/config"
+	"math"
+	"time"
+)
+
+const (
+	defaultMinLimit = 1
+	defaultMaxLimit = 32
+
+	aimdBackoff       = 0.75 // multiplicative decrease on congestion
+	gradientTolerance = 1.5  // short-term latency may exceed the long-term average by this factor
+	gradientSmoothing = 0.2  // weight of a new estimate in the limit
+	longWindowAlpha   = 0.02 // EWMA factor of the long-term latency
+)
+
+// adaptiveLimiter estimates how many concurrent requests a backend can take.
+//
+// aimd adds 1/limit per successful request and multiplies the limit by 0.75
+// when a request fails or is slower than latencyThreshold.
+//
+// gradient compares each request's latency with a long-term average. While
+// latency stays within the tolerance the limit grows by sqrt(limit); when
+// latency rises the limit shrinks in proportion, and failures halve it.
+type adaptiveLimiter struct {
+	settings config.AdaptiveConcurrency
+	min, max float64
+	limit    float64
+	longRTT  float64 // gradient: long-term latency average in seconds
+}
+
+func newAdaptiveLimiter(settings config.AdaptiveConcurrency, initial int) *adaptiveLimiter {
+	a := &adaptiveLimiter{
+		settings: settings,
+		min:      defaultMinLimit,
+		max:      defaultMaxLimit,
+	}
+	if settings.MinLimit > 0 {
+		a.min = float64(settings.MinLimit)
+	}
+	if settings.MaxLimit > 0 {
+		a.max = float64(settings.MaxLimit)
+	}
+	if a.max < a.min {
+		a.max = a.min
+	}
+	a.limit = a.clamp(float64(initial))
+	return a
+}
+
+// current returns the current limit
+func (a *adaptiveLimiter) current() int {
+	return int(a.limit)
+}
+
+// observe updates the limit from the outcome of a request. inFlight is the
+// number of requests that were in flight, the limit only grows while it is used.
+func (a *adaptiveLimiter) observe(latency time.Duration, failed bool, inFlight int) {
+	utilized := float64(inFlight) >= a.limit/2
+
+	if a.settings.Algorithm == "gradient" {
+		a.observeGradient(latency.Seconds(), failed, utilized)
+		return
+	}
+
+	congested := failed || (a.settings.LatencyThreshold > 0 && latency > a.settings.LatencyThreshold.Std())
+	switch {
+	case congested:
+		a.limit = a.clamp(a.limit * aimdBackoff)
+	case utilized:
+		a.limit = a.clamp(a.limit + 1/a.limit)
+	}
+}
+
+func (a *adaptiveLimiter) observeGradient(rtt float64, failed bool, utilized bool) {
+	if failed {
+		a.limit = a.clamp(a.limit / 2)
+		return
+	}
+
+	if a.longRTT == 0 {
+		a.longRTT = rtt
+	} else {
+		a.longRTT = a.longRTT*(1-longWindowAlpha) + rtt*longWindowAlpha
+	}
+	// Let the average follow latency down quickly, e.g. after a model was loaded
+	if a.longRTT > 2*rtt {
+		a.longRTT *= 0.95
+	}
+	if !utilized || rtt <= 0 {
+		return
+	}
+
+	gradient := math.Max(0.5, math.Min(1, gradientTolerance*a.longRTT/rtt))
+	estimate := a.limit*gradient + math.Sqrt(a.limit)
+	a.limit = a.clamp(a.limit*(1-gradientSmoothing) + estimate*gradientSmoothing)
+}
+
+func (a *adaptiveLimiter) clamp(limit float64) float64 {
+	return math.Max(a.min, math.Min(a.max, limit))
+}
@@ -0,0 +1,118 @@
+package scheduler
+
+import (
+	"immich_ml_proxy/config"
+	"testing"
+	"time"
+)
+
+func TestNewAdaptiveLimiter(t *testing.T) {
+	tests := []struct {
+		name     string
+		settings config.AdaptiveConcurrency
+		initial  int
+		want     int
+	}{
+		{"initial within defaults", config.AdaptiveConcurrency{}, 4, 4},
+		{"no initial limit", config.AdaptiveConcurrency{}, 0, defaultMinLimit},
+		{"initial above default max", config.AdaptiveConcurrency{}, 100, defaultMaxLimit},
+		{"initial below min", config.AdaptiveConcurrency{MinLimit: 2}, 1, 2},
+		{"initial above max", config.AdaptiveConcurrency{MaxLimit: 8}, 16, 8},
+		{"max below min", config.AdaptiveConcurrency{MinLimit: 4, MaxLimit: 2}, 8, 4},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := newAdaptiveLimiter(tt.settings, tt.initial).current(); got != tt.want {
+				t.Errorf("limit %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAIMD(t *testing.T) {
+	threshold := config.AdaptiveConcurrency{Algorithm: "aimd", LatencyThreshold: config.Duration(100 * time.Millisecond)}
+	tests := []struct {
+		name     string
+		settings config.AdaptiveConcurrency
+		initial  int
+		latency  time.Duration
+		failed   bool
+		inFlight int
+		want     float64
+	}{
+		{"success while utilized grows by 1/limit", threshold, 4, 50 * time.Millisecond, false, 2, 4.25},
+		{"success while underused keeps the limit", threshold, 4, 50 * time.Millisecond, false, 1, 4},
+		{"failure backs off", threshold, 4, 50 * time.Millisecond, true, 4, 3},
+		{"slow request backs off", threshold, 4, 200 * time.Millisecond, false, 4, 3},
+		{"no threshold ignores latency", config.AdaptiveConcurrency{}, 4, time.Minute, false, 4, 4.25},
+		{"backoff stops at min", config.AdaptiveConcurrency{MinLimit: 2}, 2, 0, true, 2, 2},
+		{"growth stops at max", config.AdaptiveConcurrency{MaxLimit: 4}, 4, 0, false, 4, 4},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := newAdaptiveLimiter(tt.settings, tt.initial)
+			a.observe(tt.latency, tt.failed, tt.inFlight)
+			if a.limit != tt.want {
+				t.Errorf("limit %v, want %v", a.limit, tt.want)
+			}
+		})
+	}
+}
+
+func TestAIMDRecovers(t *testing.T) {
+	a := newAdaptiveLimiter(config.AdaptiveConcurrency{}, 8)
+	a.observe(0, true, 8)
+	a.observe(0, true, 6)
+	if a.current() != 4 {
+		t.Fatalf("limit %d after two failures, want 4", a.current())
+	}
+	for i := 0; i < 100 && a.current() < 8; i++ {
+		a.observe(time.Millisecond, false, a.current())
+	}
+	if a.current() != 8 {
+		t.Errorf("limit %d after 100 successes, want it back at 8", a.current())
+	}
+}
+
+func TestGradient(t *testing.T) {
+	settings := config.AdaptiveConcurrency{Algorithm: "gradient"}
+
+	t.Run("failure halves the limit", func(t *testing.T) {
+		a := newAdaptiveLimiter(settings, 8)
+		a.observe(time.Second, true, 8)
+		if a.current() != 4 {
+			t.Errorf("limit %d, want 4", a.current())
+		}
+	})
+
+	t.Run("steady latency grows the limit", func(t *testing.T) {
+		a := newAdaptiveLimiter(settings, 4)
+		for i := 0; i < 10; i++ {
+			a.observe(100*time.Millisecond, false, a.current())
+		}
+		if a.current() <= 4 {
+			t.Errorf("limit %d, want it above 4", a.current())
+		}
+	})
+
+	t.Run("underused limit does not grow", func(t *testing.T) {
+		a := newAdaptiveLimiter(settings, 8)
+		for i := 0; i < 10; i++ {
+			a.observe(100*time.Millisecond, false, 1)
+		}
+		if a.current() != 8 {
+			t.Errorf("limit %d, want 8", a.current())
+		}
+	})
+
+	t.Run("rising latency shrinks the limit", func(t *testing.T) {
+		a := newAdaptiveLimiter(settings, 16)
+		a.observe(100*time.Millisecond, false, 1)
+		for i := 0; i < 10; i++ {
+			a.observe(time.Second, false, a.current())
+		}
+		if a.current() >= 16 {
+			t.Errorf("limit %d, want it below 16", a.current())
+		}
+	})
+}
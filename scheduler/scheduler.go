@@ -0,0 +1,312 @@
+package scheduler
+
+import (
+	"container/list"
+	"context"
+	"fmt"
+	"immich_ml_proxy/config"
+	"math"
+	"sync"
+	"time"
+)
+
+// OverloadError is returned when a request is shed because the backend's
+// queue is full or the request waited longer than the queue timeout
+type OverloadError struct {
+	Backend    string
+	Reason     string
+	RetryAfter time.Duration // hint for the Retry-After header
+}
+
+func (e *OverloadError) Error() string {
+	return fmt.Sprintf("backend %s overloaded: %s", e.Backend, e.Reason)
+}
+
+// RetryAfterSeconds returns the Retry-After value in whole seconds, at least 1
+func (e *OverloadError) RetryAfterSeconds() int {
+	return int(math.Max(1, math.Ceil(e.RetryAfter.Seconds())))
+}
+
+// QueueStats describes the load of one backend
+type QueueStats struct {
+	InFlight      int            `json:"inFlight"`
+	Queued        int            `json:"queued"`
+	QueuedByClass map[string]int `json:"queuedByClass,omitempty"`
+	Limit         int            `json:"limit,omitempty"` // maxConcurrency or the adaptive limit
+	Adaptive      bool           `json:"adaptive,omitempty"`
+	MaxQueue      int            `json:"maxQueue,omitempty"`
+}
+
+// Scheduler limits the number of concurrent predict requests per backend,
+// either to a static maxConcurrency or to an adaptive limit.
+// Requests that find all of a backend's slots in use wait in one queue per
+// priority class, and freed slots are handed out weighted-fair across the
+// classes (stride scheduling), so a busy background class cannot hold up
+// interactive requests queued behind it.
+type Scheduler struct {
+	cfg      *config.Config
+	mu       sync.Mutex
+	backends map[string]*backendQueue // backend name -> queue
+}
+
+// backendQueue tracks the slots and waiting requests of one backend
+type backendQueue struct {
+	limit    int
+	adaptive *adaptiveLimiter // nil unless adaptiveConcurrency is set
+	maxQueue int
+	inFlight int
+	classes  map[string]*classQueue
+	pass     float64 // pass of the most recently dequeued class
+}
+
+// classQueue holds the waiting requests of one priority class
+type classQueue struct {
+	waiters *list.List // of *waiter
+	weight  int
+	pass    float64 // virtual time; advanced by 1/weight per dequeued request
+}
+
+type waiter struct {
+	ready   chan struct{}
+	granted bool
+	evicted *OverloadError // set if a higher-priority request took its place in the queue
+}
+
+// New creates a scheduler that reads slot counts and class weights from cfg
+func New(cfg *config.Config) *Scheduler {
+	return &Scheduler{
+		cfg:      cfg,
+		backends: make(map[string]*backendQueue),
+	}
+}
+
+// Acquire waits for a free slot on the backend and returns a function that
+// releases it. Backends without maxConcurrency or adaptiveConcurrency are not
+// limited. A request is
+// shed with an *OverloadError when the backend's queue is full or it waited
+// longer than the backend's queueTimeout. If the queue is full, a request of a
+// higher-priority class takes the place of the newest waiter of the lowest
+// class, which is shed instead. If ctx ends while waiting, the
+// request leaves the queue and ctx's error is returned.
+func (s *Scheduler) Acquire(ctx context.Context, backend config.Backend, class string) (func(), error) {
+	if class == "" {
+		class = config.DefaultPriorityClass
+	}
+	weight := s.cfg.PriorityWeight(class)
+
+	s.mu.Lock()
+	bq := s.queue(backend.Name)
+	bq.configure(backend)
+	// Grant queued requests first if the limit was raised or removed
+	bq.dispatch()
+	if bq.limit <= 0 || (bq.inFlight < bq.limit && bq.waiting() == 0) {
+		bq.inFlight++
+		s.mu.Unlock()
+		return s.releaser(backend.Name), nil
+	}
+
+	if bq.maxQueue > 0 && bq.waiting() >= bq.maxQueue {
+		full := &OverloadError{
+			Backend:    backend.Name,
+			Reason:     fmt.Sprintf("queue full (%d waiting)", bq.maxQueue),
+			RetryAfter: backend.QueueTimeout.Or(time.Second),
+		}
+		// A full queue of lower-priority work must not hold up higher-priority
+		// requests: the newest waiter of the lowest class makes room instead
+		if !bq.evict(weight, full) {
+			s.mu.Unlock()
+			return nil, full
+		}
+	}
+
+	w := &waiter{ready: make(chan struct{})}
+	cq := bq.class(class)
+	cq.weight = weight
+	if cq.waiters.Len() == 0 && cq.pass < bq.pass {
+		// A class that was idle does not get to catch up on the slots it did not use
+		cq.pass = bq.pass
+	}
+	element := cq.waiters.PushBack(w)
+	s.mu.Unlock()
+
+	var timeout <-chan time.Time
+	if backend.QueueTimeout > 0 {
+		timer := time.NewTimer(backend.QueueTimeout.Std())
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	var err error
+	select {
+	case <-w.ready:
+		if w.evicted != nil {
+			return nil, w.evicted
+		}
+		return s.releaser(backend.Name), nil
+	case <-timeout:
+		err = &OverloadError{
+			Backend:    backend.Name,
+			Reason:     fmt.Sprintf("no free slot within %s", backend.QueueTimeout.Std()),
+			RetryAfter: backend.QueueTimeout.Std(),
+		}
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if w.granted {
+		// The slot was handed over just as the request gave up
+		bq.inFlight--
+		bq.dispatch()
+	} else {
+		cq.waiters.Remove(element)
+	}
+	return nil, err
+}
+
+// Observe feeds the outcome of a request to the backend's adaptive limiter
+func (s *Scheduler) Observe(backendName string, latency time.Duration, failed bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	bq, ok := s.backends[backendName]
+	if !ok || bq.adaptive == nil {
+		return
+	}
+	bq.adaptive.observe(latency, failed, bq.inFlight)
+	bq.limit = bq.adaptive.current()
+	bq.dispatch()
+}
+
+// Stats returns the in-flight and queued requests of a backend
+func (s *Scheduler) Stats(backendName string) QueueStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	bq, ok := s.backends[backendName]
+	if !ok {
+		return QueueStats{}
+	}
+	stats := QueueStats{
+		InFlight: bq.inFlight,
+		Queued:   bq.waiting(),
+		Limit:    bq.limit,
+		Adaptive: bq.adaptive != nil,
+		MaxQueue: bq.maxQueue,
+	}
+	for name, cq := range bq.classes {
+		if cq.waiters.Len() > 0 {
+			if stats.QueuedByClass == nil {
+				stats.QueuedByClass = make(map[string]int)
+			}
+			stats.QueuedByClass[name] = cq.waiters.Len()
+		}
+	}
+	return stats
+}
+
+// releaser returns a function that frees one slot of the backend. Calling it
+// more than once has no effect.
+func (s *Scheduler) releaser(backendName string) func() {
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			s.mu.Lock()
+			defer s.mu.Unlock()
+			bq := s.backends[backendName]
+			bq.inFlight--
+			bq.dispatch()
+		})
+	}
+}
+
+// queue returns the queue of a backend, creating it if needed. Callers must hold mu.
+func (s *Scheduler) queue(backendName string) *backendQueue {
+	bq, ok := s.backends[backendName]
+	if !ok {
+		bq = &backendQueue{classes: make(map[string]*classQueue)}
+		s.backends[backendName] = bq
+	}
+	return bq
+}
+
+// configure applies the backend's current load limit settings
+func (bq *backendQueue) configure(backend config.Backend) {
+	bq.maxQueue = backend.MaxQueue
+	if backend.AdaptiveConcurrency == nil {
+		bq.adaptive = nil
+		bq.limit = backend.MaxConcurrency
+		return
+	}
+	if bq.adaptive == nil || bq.adaptive.settings != *backend.AdaptiveConcurrency {
+		// Start from the static limit if there is one, otherwise from the minimum
+		bq.adaptive = newAdaptiveLimiter(*backend.AdaptiveConcurrency, backend.MaxConcurrency)
+	}
+	bq.limit = bq.adaptive.current()
+}
+
+func (bq *backendQueue) class(name string) *classQueue {
+	cq, ok := bq.classes[name]
+	if !ok {
+		cq = &classQueue{waiters: list.New()}
+		bq.classes[name] = cq
+	}
+	return cq
+}
+
+// waiting returns the number of queued requests
+func (bq *backendQueue) waiting() int {
+	n := 0
+	for _, cq := range bq.classes {
+		n += cq.waiters.Len()
+	}
+	return n
+}
+
+// evict sheds the newest waiter of the lowest-weight class whose weight is
+// below weight, so a request of that weight can be queued. It reports whether
+// a waiter was shed.
+func (bq *backendQueue) evict(weight int, err *OverloadError) bool {
+	var lowest *classQueue
+	for _, cq := range bq.classes {
+		if cq.waiters.Len() == 0 || cq.weight >= weight {
+			continue
+		}
+		if lowest == nil || cq.weight < lowest.weight {
+			lowest = cq
+		}
+	}
+	if lowest == nil {
+		return false
+	}
+
+	w := lowest.waiters.Remove(lowest.waiters.Back()).(*waiter)
+	w.evicted = err
+	close(w.ready)
+	return true
+}
+
+// dispatch hands free slots to queued requests, always picking the non-empty
+// class with the lowest pass; ties go to the class with the higher weight
+func (bq *backendQueue) dispatch() {
+	for bq.limit <= 0 || bq.inFlight < bq.limit {
+		var next *classQueue
+		for _, cq := range bq.classes {
+			if cq.waiters.Len() == 0 {
+				continue
+			}
+			if next == nil || cq.pass < next.pass || (cq.pass == next.pass && cq.weight > next.weight) {
+				next = cq
+			}
+		}
+		if next == nil {
+			return
+		}
+
+		w := next.waiters.Remove(next.waiters.Front()).(*waiter)
+		bq.pass = next.pass
+		next.pass += 1 / float64(next.weight)
+		bq.inFlight++
+		w.granted = true
+		close(w.ready)
+	}
+}
@@ -0,0 +1,299 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"immich_ml_proxy/config"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestMain(m *testing.M) {
+	dir, err := os.MkdirTemp("", "scheduler")
+	if err != nil {
+		panic(err)
+	}
+	config.UseStore(config.NewFileStore(filepath.Join(dir, "config.json")))
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+// testScheduler returns a scheduler over a configuration with an interactive
+// class of weight 8 and a background class of weight 1
+func testScheduler(t *testing.T) *Scheduler {
+	t.Helper()
+	cfg := config.Load()
+	_, err := cfg.Replace("", config.Settings{
+		DefaultBackend: "gpu",
+		Backends:       []config.Backend{{Name: "gpu", URL: "http://gpu:3003"}},
+		PriorityClasses: []config.PriorityClass{
+			{Name: "interactive", Weight: 8},
+			{Name: "background", Weight: 1},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return New(cfg)
+}
+
+// queueWaiters adds n waiters of a class with the given weight
+func queueWaiters(bq *backendQueue, class string, weight, n int) []*waiter {
+	cq := bq.class(class)
+	cq.weight = weight
+	var waiters []*waiter
+	for i := 0; i < n; i++ {
+		w := &waiter{ready: make(chan struct{})}
+		cq.waiters.PushBack(w)
+		waiters = append(waiters, w)
+	}
+	return waiters
+}
+
+func TestDispatchWeightedFair(t *testing.T) {
+	bq := &backendQueue{limit: 1, inFlight: 1, classes: make(map[string]*classQueue)}
+	interactive := queueWaiters(bq, "interactive", 8, 20)
+	background := queueWaiters(bq, "background", 1, 20)
+	classOf := make(map[*waiter]string)
+	for _, w := range interactive {
+		classOf[w] = "interactive"
+	}
+	for _, w := range background {
+		classOf[w] = "background"
+	}
+
+	// Free one slot at a time and see which class gets it
+	granted := map[string]int{}
+	for i := 0; i < 18; i++ {
+		bq.inFlight--
+		bq.dispatch()
+		if bq.inFlight != 1 {
+			t.Fatalf("%d in flight after a dispatch, want 1", bq.inFlight)
+		}
+		for w, class := range classOf {
+			if w.granted {
+				granted[class]++
+				delete(classOf, w)
+			}
+		}
+	}
+	if granted["interactive"] != 16 || granted["background"] != 2 {
+		t.Errorf("granted %v, want 16 interactive and 2 background", granted)
+	}
+}
+
+func TestDispatchGrantsInOrder(t *testing.T) {
+	bq := &backendQueue{limit: 2, classes: make(map[string]*classQueue)}
+	waiters := queueWaiters(bq, "default", 1, 3)
+	bq.dispatch()
+	if !waiters[0].granted || !waiters[1].granted || waiters[2].granted {
+		t.Errorf("granted %v %v %v, want the first two", waiters[0].granted, waiters[1].granted, waiters[2].granted)
+	}
+}
+
+func TestAcquireWaitsForHigherPriorityFirst(t *testing.T) {
+	s := testScheduler(t)
+	backend := config.Backend{Name: "gpu", MaxConcurrency: 1}
+	release, err := s.Acquire(context.Background(), backend, "background")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A background request queues before an interactive one
+	order := make(chan string, 2)
+	acquire := func(class string) {
+		release, err := s.Acquire(context.Background(), backend, class)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		order <- class
+		release()
+	}
+	go acquire("background")
+	waitQueued(t, s, "gpu", 1)
+	go acquire("interactive")
+	waitQueued(t, s, "gpu", 2)
+
+	release()
+	if first := <-order; first != "interactive" {
+		t.Errorf("%s request got the slot first, want interactive", first)
+	}
+	<-order
+}
+
+// waitQueued waits until n requests are queued for the backend
+func waitQueued(t *testing.T, s *Scheduler, backendName string, n int) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for s.Stats(backendName).Queued != n {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d requests queued, want %d", s.Stats(backendName).Queued, n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestEvict(t *testing.T) {
+	tests := []struct {
+		name    string
+		weight  int
+		evicted string // class whose newest waiter is shed, empty if none
+	}{
+		{"higher priority evicts lowest class", 8, "background"},
+		{"middle priority evicts lowest class", 4, "background"},
+		{"same priority as lowest class", 1, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bq := &backendQueue{classes: make(map[string]*classQueue)}
+			interactive := queueWaiters(bq, "interactive", 8, 2)
+			background := queueWaiters(bq, "background", 1, 2)
+			full := &OverloadError{Backend: "gpu", Reason: "queue full"}
+
+			if got := bq.evict(tt.weight, full); got != (tt.evicted != "") {
+				t.Fatalf("evict(%d) = %v", tt.weight, got)
+			}
+			for _, w := range interactive {
+				if w.evicted != nil {
+					t.Error("interactive waiter was evicted")
+				}
+			}
+			if tt.evicted == "" {
+				if bq.waiting() != 4 {
+					t.Errorf("%d waiting, want 4", bq.waiting())
+				}
+				return
+			}
+			if background[0].evicted != nil {
+				t.Error("oldest background waiter was evicted")
+			}
+			if background[1].evicted != full {
+				t.Error("newest background waiter was not evicted")
+			}
+			select {
+			case <-background[1].ready:
+			default:
+				t.Error("evicted waiter was not woken")
+			}
+			if bq.waiting() != 3 {
+				t.Errorf("%d waiting, want 3", bq.waiting())
+			}
+		})
+	}
+}
+
+func TestAcquireShedsWhenQueueFull(t *testing.T) {
+	s := testScheduler(t)
+	backend := config.Backend{Name: "gpu", MaxConcurrency: 1, MaxQueue: 1}
+	release, err := s.Acquire(context.Background(), backend, "interactive")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer release()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go s.Acquire(ctx, backend, "interactive")
+	waitQueued(t, s, "gpu", 1)
+
+	_, err = s.Acquire(context.Background(), backend, "interactive")
+	var overload *OverloadError
+	if !errors.As(err, &overload) {
+		t.Fatalf("Acquire with a full queue = %v, want an OverloadError", err)
+	}
+	if overload.RetryAfterSeconds() < 1 {
+		t.Errorf("Retry-After %d, want at least 1", overload.RetryAfterSeconds())
+	}
+}
+
+func TestAcquireEvictsLowerPriority(t *testing.T) {
+	s := testScheduler(t)
+	backend := config.Backend{Name: "gpu", MaxConcurrency: 1, MaxQueue: 1}
+	release, err := s.Acquire(context.Background(), backend, "background")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	evicted := make(chan error, 1)
+	go func() {
+		_, err := s.Acquire(context.Background(), backend, "background")
+		evicted <- err
+	}()
+	waitQueued(t, s, "gpu", 1)
+
+	granted := make(chan error, 1)
+	go func() {
+		release, err := s.Acquire(context.Background(), backend, "interactive")
+		if err == nil {
+			release()
+		}
+		granted <- err
+	}()
+
+	var overload *OverloadError
+	if err := <-evicted; !errors.As(err, &overload) {
+		t.Fatalf("queued background request got %v, want an OverloadError", err)
+	}
+	release()
+	if err := <-granted; err != nil {
+		t.Errorf("interactive request got %v after the slot was released", err)
+	}
+}
+
+func TestAcquireQueueTimeout(t *testing.T) {
+	s := testScheduler(t)
+	backend := config.Backend{Name: "gpu", MaxConcurrency: 1, QueueTimeout: config.Duration(10 * time.Millisecond)}
+	release, err := s.Acquire(context.Background(), backend, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer release()
+
+	_, err = s.Acquire(context.Background(), backend, "")
+	var overload *OverloadError
+	if !errors.As(err, &overload) {
+		t.Fatalf("Acquire past the queue timeout = %v, want an OverloadError", err)
+	}
+	if queued := s.Stats("gpu").Queued; queued != 0 {
+		t.Errorf("%d still queued after the timeout", queued)
+	}
+}
+
+func TestAcquireCancelLeavesQueue(t *testing.T) {
+	s := testScheduler(t)
+	backend := config.Backend{Name: "gpu", MaxConcurrency: 1}
+	release, err := s.Acquire(context.Background(), backend, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		_, err := s.Acquire(ctx, backend, "")
+		done <- err
+	}()
+	waitQueued(t, s, "gpu", 1)
+	cancel()
+	if err := <-done; !errors.Is(err, context.Canceled) {
+		t.Fatalf("cancelled Acquire = %v, want context.Canceled", err)
+	}
+	if queued := s.Stats("gpu").Queued; queued != 0 {
+		t.Errorf("%d still queued after the cancel", queued)
+	}
+
+	// The released slot goes to the next request rather than the cancelled one
+	release()
+	release, err = s.Acquire(context.Background(), backend, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	release()
+	if inFlight := s.Stats("gpu").InFlight; inFlight != 0 {
+		t.Errorf("%d in flight after all releases, want 0", inFlight)
+	}
+}
@@ -0,0 +1,287 @@
+package stats
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	windowSize = 200             // samples kept per backend
+	windowAge  = 5 * time.Minute // samples older than this are ignored
+
+	ewmaAlpha       = 0.2             // weight of a new transfer measurement
+	minUploadSample = 256 << 10       // smaller bodies fit in socket buffers and say nothing about throughput
+	estimateMaxAge  = 1 * time.Minute // small requests re-measure backends with older estimates
+)
+
+// BackendStats summarizes the predict requests sent to one backend. Counters
+// cover the whole uptime; rates and percentiles cover the recent window.
+type BackendStats struct {
+	Requests     uint64  `json:"requests"`
+	Failures     uint64  `json:"failures"`
+	Window       int     `json:"window"` // samples in the window
+	ErrorRate    float64 `json:"errorRate"`
+	LatencyP50Ms float64 `json:"latencyP50Ms"`
+	LatencyP95Ms float64 `json:"latencyP95Ms"`
+	LatencyP99Ms float64 `json:"latencyP99Ms"`
+
+	// Link and processing speed, measured from real traffic (moving averages)
+	UploadBytesPerSec float64 `json:"uploadBytesPerSec,omitempty"`
+	RTTMs             float64 `json:"rttMs,omitempty"`
+	ServerTimeMs      float64 `json:"serverTimeMs,omitempty"` // processing time, measured on small requests
+}
+
+type sample struct {
+	at      time.Time
+	task    string // task key of the request, e.g. "clip/visual"
+	latency time.Duration
+	failed  bool
+}
+
+type backendStats struct {
+	requests uint64
+	failures uint64
+	samples  []sample // ring buffer
+	next     int
+
+	uploadBytesPerSec float64 // 0 until measured
+	rtt               time.Duration
+	serverTime        time.Duration
+	serverTimeSource  int64     // body size of the first server time sample, 0 once measured on a small request
+	lastTransfer      time.Time // zero until measured
+}
+
+// Collector records the latency and outcome of predict requests per backend
+type Collector struct {
+	mu       sync.Mutex
+	backends map[string]*backendStats // backend name -> stats
+}
+
+var (
+	instance *Collector
+	once     sync.Once
+)
+
+// GetInstance returns the singleton Collector
+func GetInstance() *Collector {
+	once.Do(func() {
+		instance = &Collector{
+			backends: make(map[string]*backendStats),
+		}
+	})
+	return instance
+}
+
+// Record adds the outcome of one request for a task to a backend
+func (c *Collector) Record(backendName, task string, latency time.Duration, failed bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	bs := c.backend(backendName)
+	bs.requests++
+	if failed {
+		bs.failures++
+	}
+
+	s := sample{at: time.Now(), task: task, latency: latency, failed: failed}
+	if len(bs.samples) < windowSize {
+		bs.samples = append(bs.samples, s)
+	} else {
+		bs.samples[bs.next] = s
+	}
+	bs.next = (bs.next + 1) % windowSize
+}
+
+// RecordTransfer adds a measurement of a predict request with a body of
+// bodyBytes: upload is the time it took to write the request, total the time
+// until the response headers arrived.
+//
+// Writing the body only takes until the last bytes are in the socket buffers,
+// so the server time (total - upload) is only taken from small requests, and
+// the throughput of large requests from the total time minus the server time.
+func (c *Collector) RecordTransfer(backendName string, bodyBytes int64, upload time.Duration, total time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	bs := c.backend(backendName)
+	small := bodyBytes < minUploadSample
+	serverTime := total - upload
+	switch {
+	case bs.lastTransfer.IsZero():
+		// First measurement, use it even if it is inflated by the upload
+		bs.serverTime = serverTime
+		if !small {
+			bs.serverTimeSource = bodyBytes
+		}
+	case small && bs.serverTimeSource > 0:
+		// Replace the inflated first measurement
+		bs.serverTime = serverTime
+		bs.serverTimeSource = 0
+	case small:
+		bs.serverTime = time.Duration(ewma(float64(bs.serverTime), float64(serverTime)))
+	}
+
+	if !small {
+		transfer := total - bs.serverTime
+		if transfer < upload {
+			transfer = upload
+		}
+		if transfer > 0 {
+			bs.uploadBytesPerSec = ewma(bs.uploadBytesPerSec, float64(bodyBytes)/transfer.Seconds())
+		}
+	}
+	bs.lastTransfer = time.Now()
+}
+
+// RecordRTT adds a round-trip time measurement, e.g. a TCP connect or a /ping
+func (c *Collector) RecordRTT(backendName string, rtt time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	bs := c.backend(backendName)
+	bs.rtt = time.Duration(ewma(float64(bs.rtt), float64(rtt)))
+}
+
+// EstimateCompletion estimates how long a request with a body of bodyBytes
+// takes on the backend: one round trip, the upload, and the backend's
+// processing. concurrent uploads share the link. It returns false if the
+// backend has not been measured yet, and for small requests if its server
+// time has not been measured on a small request or the last measurement is
+// older than a minute, so that the backend is measured again.
+func (c *Collector) EstimateCompletion(backendName string, bodyBytes int64, concurrent int) (time.Duration, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	bs, ok := c.backends[backendName]
+	if !ok || bs.lastTransfer.IsZero() {
+		return 0, false
+	}
+	if bodyBytes < minUploadSample && (bs.serverTimeSource > 0 || time.Since(bs.lastTransfer) > estimateMaxAge) {
+		return 0, false
+	}
+	estimate := bs.rtt + bs.serverTime
+	if bs.uploadBytesPerSec > 0 {
+		seconds := float64(bodyBytes) * float64(concurrent+1) / bs.uploadBytesPerSec
+		estimate += time.Duration(seconds * float64(time.Second))
+	}
+	return estimate, true
+}
+
+// Get returns the stats of a backend
+func (c *Collector) Get(backendName string) BackendStats {
+	return c.GetSince(backendName, time.Time{})
+}
+
+// GetSince returns the stats of a backend with the window limited to requests
+// made after since, e.g. to judge a backend only by requests after a change
+func (c *Collector) GetSince(backendName string, since time.Time) BackendStats {
+	return c.GetTaskSince(backendName, "", since)
+}
+
+// GetTaskSince is GetSince with the window limited to requests for a task,
+// so backends can be compared on the same work. An empty task covers all.
+func (c *Collector) GetTaskSince(backendName, task string, since time.Time) BackendStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	bs, ok := c.backends[backendName]
+	if !ok {
+		return BackendStats{}
+	}
+	result := BackendStats{
+		Requests:          bs.requests,
+		Failures:          bs.failures,
+		UploadBytesPerSec: bs.uploadBytesPerSec,
+		RTTMs:             milliseconds(bs.rtt),
+		ServerTimeMs:      milliseconds(bs.serverTime),
+	}
+
+	// Latency percentiles only use successful requests, failures are often fast
+	cutoff := time.Now().Add(-windowAge)
+	if since.After(cutoff) {
+		cutoff = since
+	}
+	var latencies []time.Duration
+	failures := 0
+	for _, s := range bs.samples {
+		if s.at.Before(cutoff) || (task != "" && s.task != task) {
+			continue
+		}
+		result.Window++
+		if s.failed {
+			failures++
+		} else {
+			latencies = append(latencies, s.latency)
+		}
+	}
+	if result.Window > 0 {
+		result.ErrorRate = float64(failures) / float64(result.Window)
+	}
+
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	result.LatencyP50Ms = milliseconds(percentile(latencies, 0.50))
+	result.LatencyP95Ms = milliseconds(percentile(latencies, 0.95))
+	result.LatencyP99Ms = milliseconds(percentile(latencies, 0.99))
+	return result
+}
+
+// Tasks returns the tasks of the requests in a backend's recent window
+func (c *Collector) Tasks(backendName string, since time.Time) []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	bs, ok := c.backends[backendName]
+	if !ok {
+		return nil
+	}
+	cutoff := time.Now().Add(-windowAge)
+	if since.After(cutoff) {
+		cutoff = since
+	}
+	seen := make(map[string]bool)
+	var tasks []string
+	for _, s := range bs.samples {
+		if !s.at.Before(cutoff) && !seen[s.task] {
+			seen[s.task] = true
+			tasks = append(tasks, s.task)
+		}
+	}
+	sort.Strings(tasks)
+	return tasks
+}
+
+// backend returns the stats of a backend, creating them if needed. Callers must hold mu.
+func (c *Collector) backend(backendName string) *backendStats {
+	bs, ok := c.backends[backendName]
+	if !ok {
+		bs = &backendStats{}
+		c.backends[backendName] = bs
+	}
+	return bs
+}
+
+// ewma blends a new measurement into a moving average; a zero average takes the measurement as is
+func ewma(average, value float64) float64 {
+	if average == 0 {
+		return value
+	}
+	return average*(1-ewmaAlpha) + value*ewmaAlpha
+}
+
+// percentile returns the p-th percentile of sorted latencies, or 0 if there are none
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	index := int(float64(len(sorted))*p+0.5) - 1
+	if index < 0 {
+		index = 0
+	}
+	if index >= len(sorted) {
+		index = len(sorted) - 1
+	}
+	return sorted[index]
+}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}